@@ -0,0 +1,401 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"smc-exporter/collector/sff"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sourceMlxlink = "mlxlink"
+	sourceEthtool = "ethtool"
+)
+
+// ethtoolModuleRetryInterval is how long an interface whose module could
+// not be read is skipped, so that a module plugged into an empty cage shows
+// up without a rediscovery.
+const ethtoolModuleRetryInterval = 5 * time.Minute
+
+// Drivers whose ports are read through mlxlink
+var mlxlinkDrivers = map[string]bool{
+	"mlx5_core": true,
+	"mlx4_core": true,
+}
+
+// EthtoolModuleCollector reads transceiver diagnostics of NICs that mlxlink
+// does not support through the ethtool module EEPROM interface. It uses the
+// ETHTOOL_GMODULEEEPROM ioctl and falls back to parsing `ethtool -m`.
+// Virtual functions are skipped, and interfaces that could not be read either
+// way are not tried again for ethtoolModuleRetryInterval or until Rediscover
+// is called.
+type EthtoolModuleCollector struct {
+	sysPath    string
+	readModule func(iface string) (PortMetrics, bool)
+
+	unreadableMu sync.Mutex
+	// unreadable holds when the module of an interface last failed to read
+	unreadable map[string]time.Time
+}
+
+func NewEthtoolModuleCollector() *EthtoolModuleCollector {
+	return &EthtoolModuleCollector{
+		sysPath:    "/sys",
+		readModule: readEthtoolModule,
+		unreadable: make(map[string]time.Time),
+	}
+}
+
+// Rediscover makes the next CollectModules try the interfaces whose module
+// could not be read again.
+func (e *EthtoolModuleCollector) Rediscover() {
+	e.unreadableMu.Lock()
+	defer e.unreadableMu.Unlock()
+	clear(e.unreadable)
+}
+
+// CollectModules implements ModuleCollector.
+func (e *EthtoolModuleCollector) CollectModules(hostname, systemserial string, slots Slots) []PortMetrics {
+	netPath := filepath.Join(e.sysPath, "class/net")
+	interfaces, err := os.ReadDir(netPath)
+	if err != nil {
+		log.Errorf("Error listing network interfaces: %s", err)
+		return nil
+	}
+
+	var result []PortMetrics
+	for _, iface := range interfaces {
+		name := iface.Name()
+		var port PortMetrics
+		var ok bool
+		collectDevice("ethtool_module", name, func() {
+			port, ok = e.collectModule(netPath, name, hostname, systemserial, slots)
+		})
+		if ok {
+			result = append(result, port)
+		}
+	}
+	return result
+}
+
+// collectModule reads the module of the interface name, if it is a PCI
+// device that mlxlink does not cover.
+func (e *EthtoolModuleCollector) collectModule(netPath, name, hostname, systemserial string, slots Slots) (PortMetrics, bool) {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(netPath, name, "device"))
+	if err != nil {
+		// virtual interface
+		return PortMetrics{}, false
+	}
+	pciAddress := filepath.Base(devicePath)
+	if _, err := parsePciDeviceLocation(pciAddress); err != nil {
+		return PortMetrics{}, false
+	}
+	driver, err := os.Readlink(filepath.Join(devicePath, "driver"))
+	if err != nil || mlxlinkDrivers[filepath.Base(driver)] {
+		return PortMetrics{}, false
+	}
+	// Virtual functions have no module of their own
+	if _, err := os.Lstat(filepath.Join(devicePath, "physfn")); err == nil {
+		return PortMetrics{}, false
+	}
+
+	e.unreadableMu.Lock()
+	failed, unreadable := e.unreadable[name]
+	e.unreadableMu.Unlock()
+	if unreadable && time.Since(failed) < ethtoolModuleRetryInterval {
+		return PortMetrics{}, false
+	}
+	port, ok := e.readModule(name)
+	e.unreadableMu.Lock()
+	if ok {
+		delete(e.unreadable, name)
+	} else {
+		e.unreadable[name] = time.Now()
+	}
+	e.unreadableMu.Unlock()
+	if !ok {
+		log.Debugf("Not reading the module of %s again for %s", name, ethtoolModuleRetryInterval)
+		return PortMetrics{}, false
+	}
+	port.mode = "ethernet"
+	port.netdev = name
+	port.hostname = hostname
+	port.systemserial = systemserial
+	port.slot = slots.getSlot(pciAddress)
+	if function, ok := getFunction(pciAddress); ok {
+		port.port = strconv.Itoa(function + 1)
+	}
+	e.readLinkState(name, &port)
+	return port, true
+}
+
+func readEthtoolModule(iface string) (PortMetrics, bool) {
+	port, err := decodeEthtoolModule(iface)
+	if err == nil {
+		return port, true
+	}
+	log.Debugf("Error reading module EEPROM of %s: %s", iface, err)
+
+	cmd := exec.Command("ethtool", "-m", iface) // #nosec G204
+	output, err := cmd.Output()
+	if err != nil {
+		log.Debugf("Error running ethtool -m %s: %s", iface, err)
+		return PortMetrics{}, false
+	}
+	return parseEthtoolModuleOutput(string(output))
+}
+
+func decodeEthtoolModule(iface string) (PortMetrics, error) {
+	moduleType, eeprom, err := readModuleEeprom(iface)
+	if err != nil {
+		return PortMetrics{}, err
+	}
+	memory, err := sff.FromEthtool(moduleType, eeprom)
+	if err != nil {
+		return PortMetrics{}, err
+	}
+	module, err := sff.Decode(memory)
+	if err != nil {
+		return PortMetrics{}, err
+	}
+	return portMetricsFromModule(module), nil
+}
+
+func (e *EthtoolModuleCollector) readLinkState(iface string, port *PortMetrics) {
+	ifacePath := filepath.Join(e.sysPath, "class/net", iface)
+	port.stateName = "Disable"
+	if operstate, err := SysReadFile(filepath.Join(ifacePath, "operstate")); err == nil && operstate == "up" {
+		port.stateName = activeState
+	}
+	port.state = stateValues[port.stateName]
+	if speed, err := SysReadFile(filepath.Join(ifacePath, "speed")); err == nil {
+		if mbps, err := strconv.ParseFloat(speed, 64); err == nil && mbps > 0 {
+			port.speed = mbps * 1000000
+		}
+	}
+	if port.width > 0 {
+		port.laneSpeed = port.speed / port.width
+	}
+}
+
+func newEthtoolPortMetrics() PortMetrics {
+	return PortMetrics{
+		source:             sourceEthtool,
+		moduleStateName:    "N/A",
+		attenuation:        map[string]float64{},
+		rxPower:            []float64{},
+		txPower:            []float64{},
+		snrMedia:           []float64{},
+		snrHost:            []float64{},
+		fecErrors:          []float64{},
+		biasCurrent:        []float64{},
+		rxLos:              []bool{},
+		dataPathState:      []float64{},
+		dataPathStateNames: []string{},
+		rawErrors:          []float64{},
+	}
+}
+
+// sffModuleStates maps CMIS module states to the names mlxlink reports.
+var sffModuleStates = map[sff.ModuleState]string{
+	sff.ModuleLowPwr: "LowPwr state",
+	sff.ModulePwrUp:  "PwrUp state",
+	sff.ModuleReady:  "Ready state",
+	sff.ModulePwrDn:  "PwrDn state",
+	sff.ModuleFault:  faultModuleState,
+}
+
+// portMetricsFromModule converts a decoded module EEPROM into the values
+// parseOutput reads from mlxlink.
+func portMetricsFromModule(module *sff.Module) PortMetrics {
+	port := newEthtoolPortMetrics()
+	port.vendor = module.Vendor
+	port.partNumber = module.PartNumber
+	port.serial = module.SerialNumber
+	port.wavelength = module.Wavelength
+	port.width = float64(module.Lanes)
+	if name, ok := sffModuleStates[module.ModuleState]; ok {
+		port.moduleStateName = name
+		port.moduleState = moduleStateValues[name]
+	}
+	for _, state := range module.DataPathState {
+		port.dataPathState = append(port.dataPathState, dataPathStateValues[state.String()])
+		port.dataPathStateNames = append(port.dataPathStateNames, state.String())
+	}
+	for _, flags := range module.LaneFlags {
+		port.rxLos = append(port.rxLos, flags.RxLOS)
+	}
+
+	if diagnostics := module.Diagnostics; diagnostics != nil {
+		port.temperature = diagnostics.Temperature
+		port.voltage = diagnostics.Voltage * 1000
+		port.biasCurrent = append(port.biasCurrent, diagnostics.Bias...)
+		for _, mw := range diagnostics.TxPower {
+			port.txPower = append(port.txPower, sff.MilliwattsToDbm(mw))
+		}
+		for _, mw := range diagnostics.RxPower {
+			port.rxPower = append(port.rxPower, sff.MilliwattsToDbm(mw))
+		}
+		port.snrMedia = append(port.snrMedia, diagnostics.SNRMedia...)
+		port.snrHost = append(port.snrHost, diagnostics.SNRHost...)
+	}
+
+	// mlxlink prints the alarm thresholds as the range of a reading
+	if thresholds := module.Thresholds; thresholds != nil {
+		port.biasCurrentRange = &valueRange{thresholds.Bias.LowAlarm, thresholds.Bias.HighAlarm}
+		port.txPowerRange = &valueRange{sff.MilliwattsToDbm(thresholds.TxPower.LowAlarm), sff.MilliwattsToDbm(thresholds.TxPower.HighAlarm)}
+		port.rxPowerRange = &valueRange{sff.MilliwattsToDbm(thresholds.RxPower.LowAlarm), sff.MilliwattsToDbm(thresholds.RxPower.HighAlarm)}
+	}
+	return port
+}
+
+var (
+	ethtoolChannelRegex = regexp.MustCompile(`\(\s*Channel\s+(\d+)\s*\)`)
+	ethtoolValueRegex   = regexp.MustCompile(`(-?[\d\.]+|-inf)\s*(mA|dBm|degrees C|V|nm)`)
+)
+
+// parseEthtoolModuleOutput parses the decoded diagnostics printed by
+// `ethtool -m` for SFP and QSFP modules.
+func parseEthtoolModuleOutput(output string) (PortMetrics, bool) {
+	port := newEthtoolPortMetrics()
+	lanes := map[string]map[int]float64{}
+	ranges := map[string]*valueRange{}
+	found := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Vendor name":
+			port.vendor = value
+			found = true
+			continue
+		case "Vendor PN":
+			port.partNumber = value
+			continue
+		case "Vendor SN":
+			port.serial = value
+			continue
+		case "Rx loss of signal":
+			// "None" or "[ Yes, No, No, No ]"
+			for _, los := range strings.Split(strings.Trim(value, "[] "), ",") {
+				port.rxLos = append(port.rxLos, strings.TrimSpace(los) == "Yes")
+			}
+			continue
+		}
+
+		match := ethtoolValueRegex.FindStringSubmatch(value)
+		if match == nil {
+			continue
+		}
+		number, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		unit := match[2]
+		if math.IsInf(number, -1) {
+			// ethtool prints no light as -inf dBm
+			number = sff.MilliwattsToDbm(0)
+		}
+		lowerKey := strings.ToLower(key)
+
+		var measurement string
+		switch {
+		case strings.Contains(lowerKey, "wavelength") && !strings.Contains(lowerKey, "tolerance") && unit == "nm":
+			port.wavelength = number
+			continue
+		case strings.HasPrefix(lowerKey, "module temperature") && unit == "degrees C" && !strings.Contains(lowerKey, "threshold"):
+			port.temperature = number
+			continue
+		case strings.HasPrefix(lowerKey, "module voltage") && unit == "V" && !strings.Contains(lowerKey, "threshold"):
+			port.voltage = number * 1000
+			continue
+		case strings.Contains(lowerKey, "bias") && unit == "mA":
+			measurement = "bias"
+		case (strings.Contains(lowerKey, "output power") || strings.Contains(lowerKey, "transmit")) && unit == "dBm":
+			measurement = "tx"
+		case (strings.Contains(lowerKey, "receiver") || strings.Contains(lowerKey, "rcvr") || strings.Contains(lowerKey, "rx power")) && unit == "dBm":
+			measurement = "rx"
+		default:
+			continue
+		}
+
+		if strings.Contains(lowerKey, "alarm threshold") {
+			if ranges[measurement] == nil {
+				ranges[measurement] = &valueRange{}
+			}
+			if strings.Contains(lowerKey, "high") {
+				ranges[measurement].high = number
+			} else if strings.Contains(lowerKey, "low") {
+				ranges[measurement].low = number
+			}
+			continue
+		}
+		if strings.Contains(lowerKey, "threshold") {
+			continue
+		}
+
+		lane := 1
+		if channel := ethtoolChannelRegex.FindStringSubmatch(key); channel != nil {
+			lane, _ = strconv.Atoi(channel[1])
+		}
+		if lanes[measurement] == nil {
+			lanes[measurement] = map[int]float64{}
+		}
+		lanes[measurement][lane] = number
+	}
+
+	port.biasCurrent = laneValues(lanes["bias"])
+	port.txPower = laneValues(lanes["tx"])
+	port.rxPower = laneValues(lanes["rx"])
+	port.biasCurrentRange = ranges["bias"]
+	port.txPowerRange = ranges["tx"]
+	port.rxPowerRange = ranges["rx"]
+	port.width = float64(max(len(port.rxPower), len(port.txPower)))
+	return port, found
+}
+
+func laneValues(values map[int]float64) []float64 {
+	result := make([]float64, len(values))
+	for lane, value := range values {
+		if lane >= 1 && lane <= len(values) {
+			result[lane-1] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	siocEthtool          = 0x8946
+	ethtoolGModuleInfo   = 0x42
+	ethtoolGModuleEeprom = 0x43
+	// Largest legacy EEPROM dump, SFF-8636 with upper pages 00h-03h
+	ethModuleMaxLen = 640
+)
+
+type ethtoolModinfo struct {
+	cmd       uint32
+	typ       uint32
+	eepromLen uint32
+	reserved  [8]uint32
+}
+
+type ethtoolEeprom struct {
+	cmd    uint32
+	magic  uint32
+	offset uint32
+	len    uint32
+	data   [ethModuleMaxLen]byte
+}
+
+// ifreq with the ifr_data member of the union, padded to the kernel size
+type ifreq struct {
+	name [16]byte
+	data unsafe.Pointer
+	_    [16]byte
+}
+
+func ethtoolIoctl(fd int, iface string, data unsafe.Pointer) error {
+	var request ifreq
+	copy(request.name[:], iface)
+	request.data = data
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&request))) // #nosec G103
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readModuleEeprom returns the ethtool module type and the raw EEPROM of the
+// transceiver plugged into iface.
+func readModuleEeprom(iface string) (uint32, []byte, error) {
+	if len(iface) >= 16 {
+		return 0, nil, fmt.Errorf("interface name too long: %s", iface)
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer syscall.Close(fd)
+
+	modinfo := ethtoolModinfo{cmd: ethtoolGModuleInfo}
+	if err := ethtoolIoctl(fd, iface, unsafe.Pointer(&modinfo)); err != nil { // #nosec G103
+		return 0, nil, fmt.Errorf("ETHTOOL_GMODULEINFO: %w", err)
+	}
+	if modinfo.eepromLen == 0 || modinfo.eepromLen > ethModuleMaxLen {
+		return 0, nil, fmt.Errorf("unexpected EEPROM length %d", modinfo.eepromLen)
+	}
+
+	eeprom := ethtoolEeprom{cmd: ethtoolGModuleEeprom, len: modinfo.eepromLen}
+	if err := ethtoolIoctl(fd, iface, unsafe.Pointer(&eeprom)); err != nil { // #nosec G103
+		return 0, nil, fmt.Errorf("ETHTOOL_GMODULEEEPROM: %w", err)
+	}
+	return modinfo.typ, eeprom.data[:eeprom.len], nil
+}
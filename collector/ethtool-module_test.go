@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"smc-exporter/collector/sff"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseEthtoolModuleOutputSFP(t *testing.T) {
+	bytes, _ := os.ReadFile("testdata/ethtool_m_sfp.txt")
+	result, ok := parseEthtoolModuleOutput(string(bytes))
+	assert.True(t, ok)
+	assert.Equal(t, sourceEthtool, result.source)
+	assert.Equal(t, "Intel Corp", result.vendor)
+	assert.Equal(t, "E25GSFP28SR", result.partNumber)
+	assert.Equal(t, "AZN0X5W", result.serial)
+	assert.Equal(t, 850.0, result.wavelength)
+	assert.Equal(t, 38.55, result.temperature)
+	assert.InDelta(t, 3313.6, result.voltage, 1e-9)
+	assert.Equal(t, 1.0, result.width)
+	assert.Equal(t, []float64{6.758}, result.biasCurrent)
+	assert.Equal(t, []float64{-2.13}, result.txPower)
+	assert.Equal(t, []float64{-2.63}, result.rxPower)
+	assert.Equal(t, &valueRange{3, 13}, result.biasCurrentRange)
+	assert.Equal(t, &valueRange{-11, 2.5}, result.txPowerRange)
+	assert.Equal(t, &valueRange{-16, 2.5}, result.rxPowerRange)
+}
+
+func TestParseEthtoolModuleOutputQSFP(t *testing.T) {
+	bytes, _ := os.ReadFile("testdata/ethtool_m_qsfp.txt")
+	result, ok := parseEthtoolModuleOutput(string(bytes))
+	assert.True(t, ok)
+	assert.Equal(t, "BROADCOM", result.vendor)
+	assert.Equal(t, "AFBR-89CDDZ", result.partNumber)
+	assert.Equal(t, "A2011300ABC", result.serial)
+	assert.Equal(t, 850.0, result.wavelength)
+	assert.Equal(t, 4.0, result.width)
+	assert.Equal(t, []bool{false, false, true, false}, result.rxLos)
+	assert.Equal(t, []float64{6.8, 6.9, 7.0, 7.1}, result.biasCurrent)
+	assert.Equal(t, []float64{-1, -2, -3, -4}, result.txPower)
+	assert.Equal(t, []float64{-2, -3, -40, -4}, result.rxPower)
+	assert.Equal(t, &valueRange{2, 10}, result.biasCurrentRange)
+	assert.Equal(t, &valueRange{-10.5, 4.5}, result.txPowerRange)
+	assert.Equal(t, &valueRange{-13.5, 4.5}, result.rxPowerRange)
+}
+
+func TestParseEthtoolModuleOutputNoModule(t *testing.T) {
+	_, ok := parseEthtoolModuleOutput("Cannot get module EEPROM information: Input/output error\n")
+	assert.False(t, ok)
+}
+
+func decodeModuleFixture(t *testing.T, path string, read func([]byte) (*sff.Memory, error)) PortMetrics {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	memory, err := read(data)
+	require.NoError(t, err)
+	module, err := sff.Decode(memory)
+	require.NoError(t, err)
+	return portMetricsFromModule(module)
+}
+
+func TestPortMetricsFromSFF8472(t *testing.T) {
+	result := decodeModuleFixture(t, "sff/testdata/sff8472.bin", func(data []byte) (*sff.Memory, error) {
+		return sff.FromEthtool(sff.EthtoolSFF8472, data)
+	})
+	assert.Equal(t, sourceEthtool, result.source)
+	assert.Equal(t, "Intel Corp", result.vendor)
+	assert.Equal(t, "E25GSFP28SR", result.partNumber)
+	assert.Equal(t, "AZN0X5W", result.serial)
+	assert.Equal(t, 850.0, result.wavelength)
+	assert.Equal(t, 1.0, result.width)
+	assert.Equal(t, "N/A", result.moduleStateName)
+	assert.InDelta(t, 38.55, result.temperature, 0.01)
+	assert.InDelta(t, 3313.6, result.voltage, 1e-9)
+	assert.InDeltaSlice(t, []float64{6.758}, result.biasCurrent, 1e-9)
+	assert.InDeltaSlice(t, []float64{-2.13}, result.txPower, 0.01)
+	assert.InDeltaSlice(t, []float64{-2.63}, result.rxPower, 0.01)
+	assert.Equal(t, []bool{false}, result.rxLos)
+	assert.InDelta(t, 3, result.biasCurrentRange.low, 1e-9)
+	assert.InDelta(t, 13, result.biasCurrentRange.high, 1e-9)
+	assert.InDelta(t, -11, result.txPowerRange.low, 0.01)
+	assert.InDelta(t, 2.5, result.txPowerRange.high, 0.01)
+	assert.InDelta(t, -16, result.rxPowerRange.low, 0.01)
+	assert.InDelta(t, 2.5, result.rxPowerRange.high, 0.01)
+}
+
+// The CMIS fixture holds the pages of the module in
+// mlxlink_active_ethernet.json, so decoding it must give the module values
+// parseOutput reads from mlxlink.
+func TestPortMetricsFromCMISMatchesMlxlink(t *testing.T) {
+	bytes, _ := os.ReadFile("testdata/mlxlink_active_ethernet.json")
+	mlxlink := parseOutput(gjson.Parse(string(bytes)), "hostname", "systemserial", "slot", "1", DeviceInfo{mode: "ethernet"})
+	result := decodeModuleFixture(t, "sff/testdata/cmis.bin", sff.ReadPageDump)
+
+	assert.Equal(t, mlxlink.vendor, result.vendor)
+	assert.Equal(t, mlxlink.partNumber, result.partNumber)
+	assert.Equal(t, mlxlink.serial, result.serial)
+	assert.Equal(t, mlxlink.wavelength, result.wavelength)
+	assert.Equal(t, mlxlink.width, result.width)
+	assert.Equal(t, mlxlink.moduleStateName, result.moduleStateName)
+	assert.Equal(t, mlxlink.moduleState, result.moduleState)
+	assert.Equal(t, mlxlink.dataPathState, result.dataPathState)
+	assert.Equal(t, mlxlink.dataPathStateNames, result.dataPathStateNames)
+	assert.Equal(t, mlxlink.rxLos, result.rxLos)
+	assert.Equal(t, mlxlink.temperature, result.temperature)
+	assert.InDelta(t, mlxlink.voltage, result.voltage, 1e-9)
+	assert.InDeltaSlice(t, mlxlink.biasCurrent, result.biasCurrent, 1e-9)
+	assert.InDeltaSlice(t, mlxlink.rxPower, result.rxPower, 0.001)
+	assert.InDeltaSlice(t, mlxlink.txPower, result.txPower, 0.001)
+	assert.InDelta(t, mlxlink.biasCurrentRange.low, result.biasCurrentRange.low, 1e-9)
+	assert.InDelta(t, mlxlink.biasCurrentRange.high, result.biasCurrentRange.high, 1e-9)
+	assert.InDelta(t, mlxlink.rxPowerRange.low, result.rxPowerRange.low, 0.001)
+	assert.InDelta(t, mlxlink.rxPowerRange.high, result.rxPowerRange.high, 0.001)
+	assert.InDelta(t, mlxlink.txPowerRange.low, result.txPowerRange.low, 0.001)
+	assert.InDelta(t, mlxlink.txPowerRange.high, result.txPowerRange.high, 0.001)
+}
+
+func TestEthtoolModuleCollectorSkipsVFsAndUnreadable(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/driver": "->../../../../bus/pci/drivers/ice",
+		"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.1/driver": "->../../../../bus/pci/drivers/ice",
+		"devices/pci0000:3a/0000:3a:00.0/0000:3b:01.0/driver": "->../../../../bus/pci/drivers/iavf",
+		"devices/pci0000:3a/0000:3a:00.0/0000:3b:01.0/physfn": "->../0000:3b:00.0",
+		"class/net/eth0/device":                               "->../../../devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0",
+		"class/net/eth0/operstate":                            "up",
+		"class/net/eth1/device":                               "->../../../devices/pci0000:3a/0000:3a:00.0/0000:3b:00.1",
+		"class/net/eth2/device":                               "->../../../devices/pci0000:3a/0000:3a:00.0/0000:3b:01.0",
+	})
+	reads := map[string]int{}
+	collector := NewEthtoolModuleCollector()
+	collector.sysPath = root
+	collector.readModule = func(iface string) (PortMetrics, bool) {
+		reads[iface]++
+		if iface == "eth1" {
+			return PortMetrics{}, false
+		}
+		return newEthtoolPortMetrics(), true
+	}
+
+	for range 2 {
+		ports := collector.CollectModules("hostname", "systemserial", nil)
+		require.Len(t, ports, 1)
+		assert.Equal(t, "eth0", ports[0].netdev)
+		assert.Equal(t, activeState, ports[0].stateName)
+	}
+	assert.Equal(t, map[string]int{"eth0": 2, "eth1": 1}, reads)
+
+	collector.Rediscover()
+	collector.CollectModules("hostname", "systemserial", nil)
+	assert.Equal(t, map[string]int{"eth0": 3, "eth1": 2}, reads)
+}
+
+func TestEthtoolModuleCollectorRetriesUnreadable(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/driver": "->../../../../bus/pci/drivers/ice",
+		"class/net/eth0/device":                               "->../../../devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0",
+	})
+	inserted := false
+	reads := 0
+	collector := NewEthtoolModuleCollector()
+	collector.sysPath = root
+	collector.readModule = func(iface string) (PortMetrics, bool) {
+		reads++
+		if !inserted {
+			return PortMetrics{}, false
+		}
+		return newEthtoolPortMetrics(), true
+	}
+
+	// The cage is empty
+	assert.Empty(t, collector.CollectModules("hostname", "systemserial", nil))
+	inserted = true
+	assert.Empty(t, collector.CollectModules("hostname", "systemserial", nil))
+	assert.Equal(t, 1, reads)
+
+	// A module plugged in is read once the retry interval has passed
+	collector.unreadable["eth0"] = time.Now().Add(-ethtoolModuleRetryInterval)
+	ports := collector.CollectModules("hostname", "systemserial", nil)
+	require.Len(t, ports, 1)
+	assert.Equal(t, "eth0", ports[0].netdev)
+	assert.Empty(t, collector.unreadable)
+	assert.Equal(t, 2, reads)
+}
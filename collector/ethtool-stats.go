@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const ethtoolStatsSubsystem = "nic_ethtool"
+
+// DefaultEthtoolStatsAllowlist matches the mlx5 counters that show drops,
+// buffer overruns, pause storms and link flaps.
+const DefaultEthtoolStatsAllowlist = `^(rx_discards_phy|rx_out_of_buffer|rx_pause_ctrl_phy|tx_pause_ctrl_phy|link_down_events_phy|rx_prio\d+_pause|tx_prio\d+_pause|rx_prio\d+_discards)$`
+
+// EthtoolStatsCollector exports the driver statistics shown by `ethtool -S`
+// for the netdevs of Mellanox NICs. Only statistics matching the allowlist
+// are exported. The driver private statistics are not carried by the
+// ethtool netlink API, so they are read with the ETHTOOL_GSTATS ioctl with
+// a fallback to parsing `ethtool -S`. The NICs and slots are the ones devices
+// and slots return, usually NicModuleCollector.Devices and Slots, so that
+// they are not discovered again on every scrape.
+type EthtoolStatsCollector struct {
+	statDesc  *prometheus.Desc
+	allowlist *regexp.Regexp
+	devices   func() map[string]DeviceInfo
+	slots     func() Slots
+	readStats func(iface string) (map[string]uint64, error)
+}
+
+func NewEthtoolStatsCollector(namespace, allowlist string, devices func() map[string]DeviceInfo, slots func() Slots) (*EthtoolStatsCollector, error) {
+	allowlistRegex, err := regexp.Compile(allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ethtool statistics allowlist: %w", err)
+	}
+	return &EthtoolStatsCollector{
+		statDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, ethtoolStatsSubsystem, "stat_total"),
+			"Driver statistic from ethtool -S <netdev>.",
+			[]string{"caname", "netdev", "slot", "port", "stat"},
+			nil,
+		),
+		allowlist: allowlistRegex,
+		devices:   devices,
+		slots:     slots,
+		readStats: readEthtoolStats,
+	}, nil
+}
+
+// Describe sends the metric descriptions to Prometheus
+func (e *EthtoolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.statDesc
+}
+
+// Collect runs on every /metrics scrape
+func (e *EthtoolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	defer recoverCollect("ethtool_stats", "")
+	devices := e.devices()
+	if len(devices) == 0 {
+		return
+	}
+	slots := e.slots()
+	for pciAddress, device := range devices {
+		if device.netDev == "" {
+			continue
+		}
+		collectDevice("ethtool_stats", device.netDev, func() {
+			stats, err := e.readStats(device.netDev)
+			if err != nil {
+				log.Errorf("Error reading ethtool statistics of %s: %s", device.netDev, err)
+				return
+			}
+			var port string
+			if function, ok := getFunction(pciAddress); ok {
+				port = strconv.Itoa(function + 1)
+			}
+			e.collectDevice(ch, device, slots.getSlot(pciAddress), port, stats)
+		})
+	}
+}
+
+func (e *EthtoolStatsCollector) collectDevice(ch chan<- prometheus.Metric, device DeviceInfo, slot, port string, stats map[string]uint64) {
+	for stat, value := range stats {
+		if !e.allowlist.MatchString(stat) {
+			continue
+		}
+		sendConstMetric(ch, e.statDesc, prometheus.CounterValue, float64(value),
+			device.caName, device.netDev, slot, port, stat)
+	}
+}
+
+func readEthtoolStats(iface string) (map[string]uint64, error) {
+	stats, err := readEthtoolStatsIoctl(iface)
+	if err == nil {
+		return stats, nil
+	}
+	log.Debugf("Error reading ethtool statistics of %s through ioctl: %s", iface, err)
+
+	cmd := exec.Command("ethtool", "-S", iface) // #nosec G204
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseEthtoolStatsOutput(string(output)), nil
+}
+
+// parseEthtoolStatsOutput parses the "name: value" lines printed by
+// `ethtool -S`.
+func parseEthtoolStatsOutput(output string) map[string]uint64 {
+	stats := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			// "NIC statistics:" header
+			continue
+		}
+		stats[strings.TrimSpace(name)] = number
+	}
+	return stats
+}
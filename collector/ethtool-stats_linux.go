@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ethtoolGStrings  = 0x1b
+	ethtoolGStats    = 0x1d
+	ethtoolGSsetInfo = 0x37
+	ethSsStats       = 1
+	ethGstringLen    = 32
+)
+
+type ethtoolSsetInfo struct {
+	cmd      uint32
+	reserved uint32
+	sSetMask uint64
+	data     uint32
+}
+
+// readEthtoolStatsIoctl reads the names and values of the ETH_SS_STATS
+// string set of iface.
+func readEthtoolStatsIoctl(iface string) (map[string]uint64, error) {
+	if len(iface) >= 16 {
+		return nil, fmt.Errorf("interface name too long: %s", iface)
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	info := ethtoolSsetInfo{cmd: ethtoolGSsetInfo, sSetMask: 1 << ethSsStats}
+	if err := ethtoolIoctl(fd, iface, unsafe.Pointer(&info)); err != nil { // #nosec G103
+		return nil, fmt.Errorf("ETHTOOL_GSSET_INFO: %w", err)
+	}
+	count := int(info.data)
+	if info.sSetMask == 0 || count == 0 {
+		return map[string]uint64{}, nil
+	}
+
+	// struct ethtool_gstrings: cmd, string_set, len, then len names
+	stringsBuf := make([]byte, 12+count*ethGstringLen)
+	binary.NativeEndian.PutUint32(stringsBuf[0:], ethtoolGStrings)
+	binary.NativeEndian.PutUint32(stringsBuf[4:], ethSsStats)
+	binary.NativeEndian.PutUint32(stringsBuf[8:], uint32(count))
+	if err := ethtoolIoctl(fd, iface, unsafe.Pointer(&stringsBuf[0])); err != nil { // #nosec G103
+		return nil, fmt.Errorf("ETHTOOL_GSTRINGS: %w", err)
+	}
+
+	// struct ethtool_stats: cmd, n_stats, then n_stats values
+	statsBuf := make([]byte, 8+count*8)
+	binary.NativeEndian.PutUint32(statsBuf[0:], ethtoolGStats)
+	binary.NativeEndian.PutUint32(statsBuf[4:], uint32(count))
+	if err := ethtoolIoctl(fd, iface, unsafe.Pointer(&statsBuf[0])); err != nil { // #nosec G103
+		return nil, fmt.Errorf("ETHTOOL_GSTATS: %w", err)
+	}
+	// The set can shrink between the two calls
+	count = min(count, int(binary.NativeEndian.Uint32(statsBuf[4:])), int(binary.NativeEndian.Uint32(stringsBuf[8:])))
+
+	stats := make(map[string]uint64, count)
+	for i := 0; i < count; i++ {
+		name := stringsBuf[12+i*ethGstringLen : 12+(i+1)*ethGstringLen]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		stats[string(name)] = binary.NativeEndian.Uint64(statsBuf[8+i*8:])
+	}
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEthtoolStatsOutput(t *testing.T) {
+	bytes, _ := os.ReadFile("testdata/ethtool_S_mlx5.txt")
+	stats := parseEthtoolStatsOutput(string(bytes))
+	assert.Len(t, stats, 17)
+	assert.Equal(t, uint64(2573063592817), stats["rx_bytes"])
+	assert.Equal(t, uint64(1207), stats["rx_out_of_buffer"])
+	assert.Equal(t, uint64(3), stats["link_down_events_phy"])
+	assert.NotContains(t, stats, "NIC statistics")
+}
+
+func TestDefaultEthtoolStatsAllowlist(t *testing.T) {
+	allowlist := regexp.MustCompile(DefaultEthtoolStatsAllowlist)
+	for _, stat := range []string{"rx_discards_phy", "rx_out_of_buffer", "tx_pause_ctrl_phy", "link_down_events_phy", "rx_prio0_pause", "rx_prio7_pause"} {
+		assert.True(t, allowlist.MatchString(stat), stat)
+	}
+	for _, stat := range []string{"rx_packets", "rx_prio0_bytes", "tx_discards_phy_extra", "ch0_arm"} {
+		assert.False(t, allowlist.MatchString(stat), stat)
+	}
+}
+
+func TestEthtoolStatsCollectDevice(t *testing.T) {
+	e, err := NewEthtoolStatsCollector("smc", `^(rx_out_of_buffer|rx_prio\d+_pause|link_down_events_phy)$`, nil, nil)
+	assert.NoError(t, err)
+	bytes, _ := os.ReadFile("testdata/ethtool_S_mlx5.txt")
+	stats := parseEthtoolStatsOutput(string(bytes))
+	device := DeviceInfo{pciAddress: "0000:1b:00.1", caName: "mlx5_1", netDev: "ens1f1np1"}
+
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		e.collectDevice(ch, device, "3", "2", stats)
+	})
+	expected := `
+# HELP smc_nic_ethtool_stat_total Driver statistic from ethtool -S <netdev>.
+# TYPE smc_nic_ethtool_stat_total counter
+smc_nic_ethtool_stat_total{caname="mlx5_1",netdev="ens1f1np1",port="2",slot="3",stat="link_down_events_phy"} 3
+smc_nic_ethtool_stat_total{caname="mlx5_1",netdev="ens1f1np1",port="2",slot="3",stat="rx_out_of_buffer"} 1207
+smc_nic_ethtool_stat_total{caname="mlx5_1",netdev="ens1f1np1",port="2",slot="3",stat="rx_prio0_pause"} 0
+smc_nic_ethtool_stat_total{caname="mlx5_1",netdev="ens1f1np1",port="2",slot="3",stat="rx_prio3_pause"} 4821
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestNewEthtoolStatsCollectorInvalidAllowlist(t *testing.T) {
+	_, err := NewEthtoolStatsCollector("smc", "(", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestEthtoolStatsCollectUsesDevices(t *testing.T) {
+	devices := map[string]DeviceInfo{
+		"0000:1b:00.0": {pciAddress: "0000:1b:00.0", caName: "mlx5_0"},
+		"0000:1b:00.1": {pciAddress: "0000:1b:00.1", caName: "mlx5_1", netDev: "ens1f1np1"},
+	}
+	e, err := NewEthtoolStatsCollector("smc", `^rx_out_of_buffer$`, func() map[string]DeviceInfo { return devices }, func() Slots {
+		return Slots{{Designation: "PCIe Slot 5", BusAddress: "0000:1b:00.0", SlotNumber: "5"}}
+	})
+	assert.NoError(t, err)
+	var read []string
+	e.readStats = func(iface string) (map[string]uint64, error) {
+		read = append(read, iface)
+		return map[string]uint64{"rx_out_of_buffer": 7}, nil
+	}
+	expected := `
+# HELP smc_nic_ethtool_stat_total Driver statistic from ethtool -S <netdev>.
+# TYPE smc_nic_ethtool_stat_total counter
+smc_nic_ethtool_stat_total{caname="mlx5_1",netdev="ens1f1np1",port="2",slot="5",stat="rx_out_of_buffer"} 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(e, strings.NewReader(expected)))
+	assert.Equal(t, []string{"ens1f1np1"}, read)
+}
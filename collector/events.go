@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventLinkUp               EventType = "link_up"
+	EventLinkDown             EventType = "link_down"
+	EventModuleInserted       EventType = "module_inserted"
+	EventModuleRemoved        EventType = "module_removed"
+	EventModuleFault          EventType = "module_fault"
+	EventModuleFaultCleared   EventType = "module_fault_cleared"
+	EventLaneLOS              EventType = "lane_los"
+	EventLaneLOSCleared       EventType = "lane_los_cleared"
+	EventFECModeChange        EventType = "fec_mode_change"
+	EventBerThresholdExceeded EventType = "ber_threshold_exceeded"
+	EventBerThresholdCleared  EventType = "ber_threshold_cleared"
+)
+
+// eventTypes are all the event types detectEvents sends.
+var eventTypes = []EventType{
+	EventLinkUp,
+	EventLinkDown,
+	EventModuleInserted,
+	EventModuleRemoved,
+	EventModuleFault,
+	EventModuleFaultCleared,
+	EventLaneLOS,
+	EventLaneLOSCleared,
+	EventFECModeChange,
+	EventBerThresholdExceeded,
+	EventBerThresholdCleared,
+}
+
+// ParseEventType returns the event type named name, or an error when there
+// is no such event type.
+func ParseEventType(name string) (EventType, error) {
+	if eventType := EventType(name); slices.Contains(eventTypes, eventType) {
+		return eventType, nil
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+const (
+	activeState      = "Active"
+	faultModuleState = "Fault state"
+)
+
+// Event describes a change detected between two consecutive UpdateMetrics
+// cycles on a single port.
+type Event struct {
+	Type     EventType `json:"type"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Mode     string    `json:"mode"`
+	Caname   string    `json:"caname"`
+	Netdev   string    `json:"netdev"`
+	Slot     string    `json:"slot"`
+	Port     string    `json:"port"`
+	Serial   string    `json:"serial"`
+	Lane     int       `json:"lane,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	Current  string    `json:"current,omitempty"`
+}
+
+// EventBroker fans out events to any number of subscribers. Subscribers that
+// do not keep up have events dropped rather than blocking UpdateMetrics.
+type EventBroker struct {
+	subscribes   chan chan Event
+	unsubscribes chan chan Event
+	publishes    chan Event
+}
+
+const eventSubscriberBuffer = 64
+
+func NewEventBroker() *EventBroker {
+	broker := &EventBroker{
+		subscribes:   make(chan chan Event),
+		unsubscribes: make(chan chan Event),
+		publishes:    make(chan Event),
+	}
+	go broker.manageSubscribers()
+	return broker
+}
+
+// Subscribe returns a channel that receives every event published from now
+// on. It must be released with Unsubscribe.
+func (b *EventBroker) Subscribe() chan Event {
+	subscriber := make(chan Event, eventSubscriberBuffer)
+	b.subscribes <- subscriber
+	return subscriber
+}
+
+func (b *EventBroker) Unsubscribe(subscriber chan Event) {
+	b.unsubscribes <- subscriber
+}
+
+func (b *EventBroker) Publish(event Event) {
+	b.publishes <- event
+}
+
+func (b *EventBroker) manageSubscribers() {
+	subscribers := make(map[chan Event]struct{})
+	for {
+		select {
+		case subscriber := <-b.subscribes:
+			subscribers[subscriber] = struct{}{}
+		case subscriber := <-b.unsubscribes:
+			if _, ok := subscribers[subscriber]; ok {
+				delete(subscribers, subscriber)
+				close(subscriber)
+			}
+		case event := <-b.publishes:
+			for subscriber := range subscribers {
+				select {
+				case subscriber <- event:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// moduleSerial returns the serial of the module in port, empty when there is
+// none. mlxlink reports "N/A" when no module is present.
+func moduleSerial(port PortMetrics) string {
+	if port.serial == "N/A" {
+		return ""
+	}
+	return port.serial
+}
+
+func portKey(port PortMetrics) string {
+	return port.caname + "|" + port.netdev + "|" + port.slot + "|" + port.port
+}
+
+// detectEvents compares two snapshots of port metrics. Ports only present in
+// one of the snapshots are ignored, so a failed mlxlink run does not look
+// like a removed module. A zero rawBerThreshold disables BER events.
+func detectEvents(previous, current []PortMetrics, rawBerThreshold float64, now time.Time) []Event {
+	previousPorts := make(map[string]PortMetrics, len(previous))
+	for _, port := range previous {
+		previousPorts[portKey(port)] = port
+	}
+
+	var events []Event
+	for _, cur := range current {
+		prev, ok := previousPorts[portKey(cur)]
+		if !ok {
+			continue
+		}
+		newEvent := func(eventType EventType, previousValue, currentValue string) Event {
+			return Event{
+				Type:     eventType,
+				Time:     now,
+				Hostname: cur.hostname,
+				Mode:     cur.mode,
+				Caname:   cur.caname,
+				Netdev:   cur.netdev,
+				Slot:     cur.slot,
+				Port:     cur.port,
+				Serial:   moduleSerial(cur),
+				Previous: previousValue,
+				Current:  currentValue,
+			}
+		}
+
+		if prev.stateName != cur.stateName {
+			if cur.stateName == activeState {
+				events = append(events, newEvent(EventLinkUp, prev.stateName, cur.stateName))
+			} else if prev.stateName == activeState {
+				events = append(events, newEvent(EventLinkDown, prev.stateName, cur.stateName))
+			}
+		}
+
+		if prevSerial, curSerial := moduleSerial(prev), moduleSerial(cur); prevSerial != curSerial {
+			if prevSerial != "" {
+				event := newEvent(EventModuleRemoved, prevSerial, curSerial)
+				event.Serial = prevSerial
+				events = append(events, event)
+			}
+			if curSerial != "" {
+				events = append(events, newEvent(EventModuleInserted, prevSerial, curSerial))
+			}
+		}
+
+		if prev.moduleStateName != cur.moduleStateName {
+			if cur.moduleStateName == faultModuleState {
+				events = append(events, newEvent(EventModuleFault, prev.moduleStateName, cur.moduleStateName))
+			} else if prev.moduleStateName == faultModuleState {
+				events = append(events, newEvent(EventModuleFaultCleared, prev.moduleStateName, cur.moduleStateName))
+			}
+		}
+
+		for laneIdx, los := range cur.rxLos {
+			if laneIdx >= len(prev.rxLos) || prev.rxLos[laneIdx] == los {
+				continue
+			}
+			eventType := EventLaneLOSCleared
+			if los {
+				eventType = EventLaneLOS
+			}
+			event := newEvent(eventType, strconv.FormatBool(prev.rxLos[laneIdx]), strconv.FormatBool(los))
+			event.Lane = laneIdx + 1
+			events = append(events, event)
+		}
+
+		if prev.fecMode != cur.fecMode {
+			events = append(events, newEvent(EventFECModeChange, prev.fecMode, cur.fecMode))
+		}
+
+		if rawBerThreshold > 0 {
+			previousBer := strconv.FormatFloat(prev.rawBer, 'g', -1, 64)
+			currentBer := strconv.FormatFloat(cur.rawBer, 'g', -1, 64)
+			if prev.rawBer <= rawBerThreshold && cur.rawBer > rawBerThreshold {
+				events = append(events, newEvent(EventBerThresholdExceeded, previousBer, currentBer))
+			} else if prev.rawBer > rawBerThreshold && cur.rawBer <= rawBerThreshold {
+				events = append(events, newEvent(EventBerThresholdCleared, previousBer, currentBer))
+			}
+		}
+	}
+	return events
+}
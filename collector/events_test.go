@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectEvents(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	previous := []PortMetrics{
+		{caname: "mlx5_0", slot: "40", port: "1", serial: "A", stateName: "Active", moduleStateName: "Ready state", fecMode: "RS-FEC", rxLos: []bool{false, false}},
+		{caname: "mlx5_1", slot: "40", port: "2", serial: "B", stateName: "Polling", moduleStateName: "Ready state", fecMode: "N/A"},
+		{caname: "mlx5_2", slot: "38", port: "1", serial: "C", stateName: "Active"},
+	}
+	current := []PortMetrics{
+		{caname: "mlx5_0", slot: "40", port: "1", serial: "A", stateName: "Polling", moduleStateName: "Fault state", fecMode: "N/A", rxLos: []bool{false, true}},
+		{caname: "mlx5_1", slot: "40", port: "2", serial: "D", stateName: "Active", moduleStateName: "Ready state", fecMode: "N/A"},
+	}
+
+	events := detectEvents(previous, current, 0, now)
+
+	types := []EventType{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []EventType{
+		EventLinkDown,
+		EventModuleFault,
+		EventLaneLOS,
+		EventFECModeChange,
+		EventLinkUp,
+		EventModuleRemoved,
+		EventModuleInserted,
+	}, types)
+	assert.Equal(t, 2, events[2].Lane)
+	assert.Equal(t, "B", events[5].Serial)
+	assert.Equal(t, "D", events[6].Serial)
+}
+
+func TestDetectEventsFirstCycle(t *testing.T) {
+	current := []PortMetrics{{caname: "mlx5_0", serial: "A", stateName: "Active"}}
+	assert.Empty(t, detectEvents(nil, current, 0, time.Now()))
+}
+
+func TestDetectEventsNoModule(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	previous := []PortMetrics{
+		{caname: "mlx5_0", serial: ""},
+		{caname: "mlx5_1", serial: "A"},
+		{caname: "mlx5_2", serial: "N/A"},
+	}
+	current := []PortMetrics{
+		{caname: "mlx5_0", serial: "N/A"},
+		{caname: "mlx5_1", serial: "N/A"},
+		{caname: "mlx5_2", serial: "B"},
+	}
+
+	events := detectEvents(previous, current, 0, now)
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventModuleRemoved, events[0].Type)
+	assert.Equal(t, "A", events[0].Serial)
+	assert.Equal(t, "", events[0].Current)
+	assert.Equal(t, EventModuleInserted, events[1].Type)
+	assert.Equal(t, "", events[1].Previous)
+	assert.Equal(t, "B", events[1].Serial)
+}
+
+func TestDetectEventsRawBerThreshold(t *testing.T) {
+	previous := []PortMetrics{
+		{caname: "mlx5_0", rawBer: 1e-12},
+		{caname: "mlx5_1", rawBer: 1e-6},
+	}
+	current := []PortMetrics{
+		{caname: "mlx5_0", rawBer: 1e-6},
+		{caname: "mlx5_1", rawBer: 1e-12},
+	}
+	events := detectEvents(previous, current, 1e-8, time.Now())
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventBerThresholdExceeded, events[0].Type)
+	assert.Equal(t, "1e-06", events[0].Current)
+	assert.Equal(t, EventBerThresholdCleared, events[1].Type)
+	assert.Empty(t, detectEvents(previous, current, 0, time.Now()))
+}
+
+func TestParseEventType(t *testing.T) {
+	eventType, err := ParseEventType("module_fault")
+	assert.NoError(t, err)
+	assert.Equal(t, EventModuleFault, eventType)
+	_, err = ParseEventType("modul_fault")
+	assert.Error(t, err)
+	_, err = ParseEventType("")
+	assert.Error(t, err)
+}
+
+func TestEventBroker(t *testing.T) {
+	broker := NewEventBroker()
+	subscriber := broker.Subscribe()
+	broker.Publish(Event{Type: EventLinkUp})
+	assert.Equal(t, EventLinkUp, (<-subscriber).Type)
+	broker.Unsubscribe(subscriber)
+	_, open := <-subscriber
+	assert.False(t, open)
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	infinibandSubsystem = "infiniband"
+	infinibandClassPath = "class/infiniband"
+)
+
+var infinibandPortLabelNames = []string{"caname", "netdev", "slot", "port", "ib_port"}
+
+// InfinibandCollector exports the port attributes, counters and RoCE
+// hw_counters the kernel keeps in /sys/class/infiniband, labelled like the
+// NIC module metrics so they can be joined with the transceiver they belong
+// to.
+type InfinibandCollector struct {
+	sysPath string
+	slots   func() Slots
+	ports   func() []PortMetrics
+
+	infoDesc          *prometheus.Desc
+	moduleInfoDesc    *prometheus.Desc
+	stateDesc         *prometheus.Desc
+	physicalStateDesc *prometheus.Desc
+	rateDesc          *prometheus.Desc
+	lidDesc           *prometheus.Desc
+	smLidDesc         *prometheus.Desc
+	transmittedDesc   *prometheus.Desc
+	receivedDesc      *prometheus.Desc
+	counterDesc       *prometheus.Desc
+	hwCounterDesc     *prometheus.Desc
+}
+
+// NewInfinibandCollector creates the collector. slots returns the PCIe slots
+// the ports are labelled with and ports the transceivers whose identity is
+// exported for each caname, eg. NicModuleCollector.Slots and Ports.
+func NewInfinibandCollector(namespace string, slots func() Slots, ports func() []PortMetrics) (*InfinibandCollector, error) {
+	desc := func(name, help string, extraLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, infinibandSubsystem, name),
+			help,
+			append(append([]string{}, infinibandPortLabelNames...), extraLabels...),
+			nil,
+		)
+	}
+	return &InfinibandCollector{
+		sysPath:           "/sys",
+		slots:             slots,
+		ports:             ports,
+		infoDesc:          desc("port_info", "Non-numeric data from /sys/class/infiniband/<ca>/ports/<port>, value is always 1.", "link_layer", "node_guid", "port_guid", "state", "physical_state"),
+		moduleInfoDesc:    desc("port_module_info", "Transceiver of the port as read by the NIC module collector, value is always 1.", "serial", "vendor", "part_number"),
+		stateDesc:         desc("port_state", "Port state (1: Down, 2: Init, 3: Armed, 4: Active, 5: Active defer)."),
+		physicalStateDesc: desc("port_physical_state", "Port physical state (1: Sleep, 2: Polling, 3: Disabled, 4: PortConfigurationTraining, 5: LinkUp, 6: LinkErrorRecovery, 7: Phy Test)."),
+		rateDesc:          desc("port_rate_bps", "Port rate in bits per second."),
+		lidDesc:           desc("port_lid", "Port LID."),
+		smLidDesc:         desc("port_sm_lid", "LID of the subnet manager of the port."),
+		transmittedDesc:   desc("port_transmitted_bytes_total", "Data transmitted on the port in bytes."),
+		receivedDesc:      desc("port_received_bytes_total", "Data received on the port in bytes."),
+		counterDesc:       desc("port_counter_total", "Port counter from /sys/class/infiniband/<ca>/ports/<port>/counters.", "counter"),
+		hwCounterDesc:     desc("port_hw_counter_total", "Driver counter from /sys/class/infiniband/<ca>/ports/<port>/hw_counters.", "counter"),
+	}, nil
+}
+
+// Describe sends the metric descriptions to Prometheus
+func (c *InfinibandCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.infoDesc
+	ch <- c.moduleInfoDesc
+	ch <- c.stateDesc
+	ch <- c.physicalStateDesc
+	ch <- c.rateDesc
+	ch <- c.lidDesc
+	ch <- c.smLidDesc
+	ch <- c.transmittedDesc
+	ch <- c.receivedDesc
+	ch <- c.counterDesc
+	ch <- c.hwCounterDesc
+}
+
+// Collect runs on every /metrics scrape
+func (c *InfinibandCollector) Collect(ch chan<- prometheus.Metric) {
+	defer recoverCollect("infiniband", "")
+	devices, err := GetInfinibandDevices(c.sysPath)
+	if err != nil {
+		log.Errorf("Error reading infiniband devices: %s", err)
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+	modules := map[string]PortMetrics{}
+	for _, port := range c.ports() {
+		if port.caname != "" {
+			modules[port.caname] = port
+		}
+	}
+	c.collectDevices(ch, devices, c.slots(), modules)
+}
+
+// collectDevices exports the ports of devices. modules holds the transceiver
+// of each caname, which is exported as port_module_info so that the counters
+// can be joined with the module identity.
+func (c *InfinibandCollector) collectDevices(ch chan<- prometheus.Metric, devices []InfinibandDevice, slots Slots, modules map[string]PortMetrics) {
+	for _, device := range devices {
+		collectDevice("infiniband", device.Name, func() {
+			var slot, port string
+			if device.PciAddress != "" {
+				slot = slots.getSlot(device.PciAddress)
+				if function, ok := getFunction(device.PciAddress); ok {
+					port = strconv.Itoa(function + 1)
+				}
+			}
+			for _, ibPort := range device.Ports {
+				labels := []string{device.Name, device.Netdev, slot, port, strconv.Itoa(ibPort.Port)}
+				gauge := func(desc *prometheus.Desc, value float64, extra ...string) {
+					sendConstMetric(ch, desc, prometheus.GaugeValue, value, append(append([]string{}, labels...), extra...)...)
+				}
+				counter := func(desc *prometheus.Desc, value float64, extra ...string) {
+					sendConstMetric(ch, desc, prometheus.CounterValue, value, append(append([]string{}, labels...), extra...)...)
+				}
+
+				gauge(c.infoDesc, 1, ibPort.LinkLayer, device.NodeGUID, ibPort.PortGUID, ibPort.State, ibPort.PhysicalState)
+				if module, ok := modules[device.Name]; ok {
+					gauge(c.moduleInfoDesc, 1, module.serial, module.vendor, module.partNumber)
+				}
+				gauge(c.stateDesc, float64(ibPort.StateID))
+				gauge(c.physicalStateDesc, float64(ibPort.PhysicalStateID))
+				gauge(c.rateDesc, ibPort.Rate)
+				gauge(c.lidDesc, float64(ibPort.LID))
+				gauge(c.smLidDesc, float64(ibPort.SMLID))
+
+				for name, value := range ibPort.Counters {
+					switch name {
+					// Data counters are in units of 4 octets
+					case "port_xmit_data":
+						counter(c.transmittedDesc, float64(value)*4)
+					case "port_rcv_data":
+						counter(c.receivedDesc, float64(value)*4)
+					default:
+						counter(c.counterDesc, float64(value), name)
+					}
+				}
+				for name, value := range ibPort.HwCounters {
+					counter(c.hwCounterDesc, float64(value), name)
+				}
+			}
+		})
+	}
+}
+
+// InfinibandDevice contains info from files in /sys/class/infiniband for a
+// single host channel adapter.
+type InfinibandDevice struct {
+	Name       string
+	PciAddress string // /sys/class/infiniband/<Name>/device link
+	Netdev     string // /sys/class/infiniband/<Name>/device/net
+	NodeGUID   string // /sys/class/infiniband/<Name>/node_guid
+	Ports      []InfinibandPort
+}
+
+// InfinibandPort contains info from /sys/class/infiniband/<ca>/ports/<Port>.
+type InfinibandPort struct {
+	Port            int
+	State           string // e.g. ACTIVE
+	StateID         uint
+	PhysicalState   string // e.g. LinkUp
+	PhysicalStateID uint
+	Rate            float64 // bits per second
+	LID             uint
+	SMLID           uint
+	LinkLayer       string
+	PortGUID        string            // interface ID of GID 0
+	Counters        map[string]uint64 // counters/*
+	HwCounters      map[string]uint64 // hw_counters/*, RoCE and congestion control
+}
+
+// GetInfinibandDevices returns info for all host channel adapters read from
+// /sys/class/infiniband. A missing directory is not an error.
+func GetInfinibandDevices(basePath string) ([]InfinibandDevice, error) {
+	classPath := filepath.Join(basePath, infinibandClassPath)
+	entries, err := os.ReadDir(classPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ca2PciAddress := readDevice2PciAddress(basePath, "infiniband")
+	devices := make([]InfinibandDevice, 0, len(entries))
+	for _, entry := range entries {
+		device, err := parseInfinibandDevice(filepath.Join(classPath, entry.Name()), ca2PciAddress[entry.Name()])
+		if err != nil {
+			log.Errorf("Error reading infiniband device %s: %s", entry.Name(), err)
+			continue
+		}
+		devices = append(devices, *device)
+	}
+	return devices, nil
+}
+
+func parseInfinibandDevice(devicePath, pciAddress string) (*InfinibandDevice, error) {
+	device := &InfinibandDevice{Name: filepath.Base(devicePath), PciAddress: pciAddress}
+	if netdevs, err := os.ReadDir(filepath.Join(devicePath, "device", "net")); err == nil && len(netdevs) > 0 {
+		device.Netdev = netdevs[0].Name()
+	}
+	device.NodeGUID, _ = SysReadFile(filepath.Join(devicePath, "node_guid"))
+
+	ports, err := os.ReadDir(filepath.Join(devicePath, "ports"))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range ports {
+		port, err := parseInfinibandPort(filepath.Join(devicePath, "ports", entry.Name()))
+		if err != nil {
+			log.Errorf("Error reading port %s of %s: %s", entry.Name(), device.Name, err)
+			continue
+		}
+		device.Ports = append(device.Ports, *port)
+	}
+	sort.Slice(device.Ports, func(i, j int) bool { return device.Ports[i].Port < device.Ports[j].Port })
+	return device, nil
+}
+
+func parseInfinibandPort(portPath string) (*InfinibandPort, error) {
+	number, err := strconv.Atoi(filepath.Base(portPath))
+	if err != nil {
+		return nil, fmt.Errorf("invalid port number: %w", err)
+	}
+	port := &InfinibandPort{Port: number}
+
+	// state and phys_state look like "4: ACTIVE" and "5: LinkUp"
+	if state, err := SysReadFile(filepath.Join(portPath, "state")); err == nil {
+		port.StateID, port.State = parseInfinibandState(state)
+	}
+	if state, err := SysReadFile(filepath.Join(portPath, "phys_state")); err == nil {
+		port.PhysicalStateID, port.PhysicalState = parseInfinibandState(state)
+	}
+	if rate, err := SysReadFile(filepath.Join(portPath, "rate")); err == nil {
+		port.Rate = parseInfinibandRate(rate)
+	}
+	if lid, err := SysReadFile(filepath.Join(portPath, "lid")); err == nil {
+		port.LID = parseHexUint(lid)
+	}
+	if smLid, err := SysReadFile(filepath.Join(portPath, "sm_lid")); err == nil {
+		port.SMLID = parseHexUint(smLid)
+	}
+	port.LinkLayer, _ = SysReadFile(filepath.Join(portPath, "link_layer"))
+	if gid, err := SysReadFile(filepath.Join(portPath, "gids", "0")); err == nil {
+		// The interface ID is the lower 64 bits of the GID
+		if parts := strings.Split(gid, ":"); len(parts) == 8 {
+			port.PortGUID = strings.Join(parts[4:], ":")
+		}
+	}
+	port.Counters = readCounterDir(filepath.Join(portPath, "counters"))
+	port.HwCounters = readCounterDir(filepath.Join(portPath, "hw_counters"))
+	// lifespan is the update interval of the counters, not a counter
+	delete(port.HwCounters, "lifespan")
+	return port, nil
+}
+
+func parseInfinibandState(s string) (uint, string) {
+	id, name, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, strings.TrimSpace(s)
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(id), 10, 32)
+	return uint(value), strings.TrimSpace(name)
+}
+
+var infinibandRateRegex = regexp.MustCompile(`^([\d\.]+)\s+Gb/sec`)
+
+// parseInfinibandRate parses a rate like "200 Gb/sec (4X HDR)".
+func parseInfinibandRate(s string) float64 {
+	match := infinibandRateRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0
+	}
+	gbps, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+	return gbps * 1e9
+}
+
+func parseHexUint(s string) uint {
+	value, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(value)
+}
+
+// readCounterDir reads every counter file in dir. Counters that cannot be
+// read, such as "N/A (no PMA)", are skipped.
+func readCounterDir(dir string) map[string]uint64 {
+	counters := make(map[string]uint64)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return counters
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := SysReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(content, 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[entry.Name()] = value
+	}
+	return counters
+}
@@ -0,0 +1,242 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSysfs creates files below root. Values starting with "->" become
+// symlinks to the rest of the value.
+func writeSysfs(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			require.NoError(t, os.Symlink(target, path))
+			continue
+		}
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o644))
+	}
+}
+
+func writeInfinibandSysfs(t *testing.T) string {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"devices/pci0000:17/0000:17:01.0/0000:1b:00.0/net/ibp27s0/address":   "00:00:10:49:fe:80:00:00:00:00:00:00:0c:42:a1:03:00:12:34:56",
+		"devices/pci0000:17/0000:17:01.0/0000:1b:00.1/net/ens1f1np1/address": "0c:42:a1:12:34:57",
+
+		"class/infiniband/mlx5_0/device":                                           "->../../../devices/pci0000:17/0000:17:01.0/0000:1b:00.0",
+		"class/infiniband/mlx5_0/node_guid":                                        "0c42:a103:0012:3456",
+		"class/infiniband/mlx5_0/ports/1/state":                                    "4: ACTIVE",
+		"class/infiniband/mlx5_0/ports/1/phys_state":                               "5: LinkUp",
+		"class/infiniband/mlx5_0/ports/1/rate":                                     "200 Gb/sec (4X HDR)",
+		"class/infiniband/mlx5_0/ports/1/lid":                                      "0x12",
+		"class/infiniband/mlx5_0/ports/1/sm_lid":                                   "0x1",
+		"class/infiniband/mlx5_0/ports/1/link_layer":                               "InfiniBand",
+		"class/infiniband/mlx5_0/ports/1/gids/0":                                   "fe80:0000:0000:0000:0c42:a103:0012:3456",
+		"class/infiniband/mlx5_0/ports/1/counters/port_xmit_data":                  "1000",
+		"class/infiniband/mlx5_0/ports/1/counters/port_rcv_data":                   "2000",
+		"class/infiniband/mlx5_0/ports/1/counters/port_rcv_errors":                 "3",
+		"class/infiniband/mlx5_0/ports/1/counters/excessive_buffer_overrun_errors": "1",
+		"class/infiniband/mlx5_0/ports/1/counters/VL15_dropped":                    "0",
+		"class/infiniband/mlx5_0/ports/1/counters/port_xmit_wait":                  "N/A (no PMA)",
+
+		"class/infiniband/mlx5_1/device":                                         "->../../../devices/pci0000:17/0000:17:01.0/0000:1b:00.1",
+		"class/infiniband/mlx5_1/node_guid":                                      "0c42:a103:0012:3457",
+		"class/infiniband/mlx5_1/ports/1/state":                                  "1: DOWN",
+		"class/infiniband/mlx5_1/ports/1/phys_state":                             "3: Disabled",
+		"class/infiniband/mlx5_1/ports/1/rate":                                   "40 Gb/sec (4X QDR)",
+		"class/infiniband/mlx5_1/ports/1/lid":                                    "0x0",
+		"class/infiniband/mlx5_1/ports/1/sm_lid":                                 "0x0",
+		"class/infiniband/mlx5_1/ports/1/link_layer":                             "Ethernet",
+		"class/infiniband/mlx5_1/ports/1/gids/0":                                 "fe80:0000:0000:0000:0e42:a1ff:fe12:3457",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/np_cnp_sent":                "120",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/rp_cnp_handled":             "118",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/np_ecn_marked_roce_packets": "240",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/out_of_sequence":            "7",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/local_ack_timeout_err":      "2",
+		"class/infiniband/mlx5_1/ports/1/hw_counters/lifespan":                   "10",
+	})
+	return root
+}
+
+func TestGetInfinibandDevices(t *testing.T) {
+	devices, err := GetInfinibandDevices(writeInfinibandSysfs(t))
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+
+	assert.Equal(t, InfinibandDevice{
+		Name:       "mlx5_0",
+		PciAddress: "0000:1b:00.0",
+		Netdev:     "ibp27s0",
+		NodeGUID:   "0c42:a103:0012:3456",
+		Ports: []InfinibandPort{{
+			Port:            1,
+			State:           "ACTIVE",
+			StateID:         4,
+			PhysicalState:   "LinkUp",
+			PhysicalStateID: 5,
+			Rate:            200e9,
+			LID:             0x12,
+			SMLID:           1,
+			LinkLayer:       "InfiniBand",
+			PortGUID:        "0c42:a103:0012:3456",
+			Counters: map[string]uint64{
+				"port_xmit_data":                  1000,
+				"port_rcv_data":                   2000,
+				"port_rcv_errors":                 3,
+				"excessive_buffer_overrun_errors": 1,
+				"VL15_dropped":                    0,
+			},
+			HwCounters: map[string]uint64{},
+		}},
+	}, devices[0])
+	assert.Equal(t, "ens1f1np1", devices[1].Netdev)
+	assert.Equal(t, "Ethernet", devices[1].Ports[0].LinkLayer)
+	assert.Equal(t, 40e9, devices[1].Ports[0].Rate)
+	assert.Empty(t, devices[1].Ports[0].Counters)
+	assert.Equal(t, map[string]uint64{
+		"np_cnp_sent":                120,
+		"rp_cnp_handled":             118,
+		"np_ecn_marked_roce_packets": 240,
+		"out_of_sequence":            7,
+		"local_ack_timeout_err":      2,
+	}, devices[1].Ports[0].HwCounters)
+}
+
+func TestGetInfinibandDevicesMissing(t *testing.T) {
+	devices, err := GetInfinibandDevices(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, devices)
+}
+
+func TestInfinibandCollectDevices(t *testing.T) {
+	devices, err := GetInfinibandDevices(writeInfinibandSysfs(t))
+	require.NoError(t, err)
+	c, err := NewInfinibandCollector("smc", func() Slots { return nil }, nil)
+	require.NoError(t, err)
+	slots := Slots{{Designation: "PCIe Slot 3", BusAddress: "0000:1b:00.0", SlotNumber: "3"}}
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectDevices(ch, devices[:1], slots, nil)
+	})
+	expected := `
+# HELP smc_infiniband_port_counter_total Port counter from /sys/class/infiniband/<ca>/ports/<port>/counters.
+# TYPE smc_infiniband_port_counter_total counter
+smc_infiniband_port_counter_total{caname="mlx5_0",counter="VL15_dropped",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 0
+smc_infiniband_port_counter_total{caname="mlx5_0",counter="excessive_buffer_overrun_errors",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 1
+smc_infiniband_port_counter_total{caname="mlx5_0",counter="port_rcv_errors",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 3
+# HELP smc_infiniband_port_received_bytes_total Data received on the port in bytes.
+# TYPE smc_infiniband_port_received_bytes_total counter
+smc_infiniband_port_received_bytes_total{caname="mlx5_0",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 8000
+# HELP smc_infiniband_port_transmitted_bytes_total Data transmitted on the port in bytes.
+# TYPE smc_infiniband_port_transmitted_bytes_total counter
+smc_infiniband_port_transmitted_bytes_total{caname="mlx5_0",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 4000
+# HELP smc_infiniband_port_info Non-numeric data from /sys/class/infiniband/<ca>/ports/<port>, value is always 1.
+# TYPE smc_infiniband_port_info gauge
+smc_infiniband_port_info{caname="mlx5_0",ib_port="1",link_layer="InfiniBand",netdev="ibp27s0",node_guid="0c42:a103:0012:3456",physical_state="LinkUp",port="1",port_guid="0c42:a103:0012:3456",slot="3",state="ACTIVE"} 1
+# HELP smc_infiniband_port_rate_bps Port rate in bits per second.
+# TYPE smc_infiniband_port_rate_bps gauge
+smc_infiniband_port_rate_bps{caname="mlx5_0",ib_port="1",netdev="ibp27s0",port="1",slot="3"} 2e+11
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"smc_infiniband_port_counter_total", "smc_infiniband_port_received_bytes_total", "smc_infiniband_port_transmitted_bytes_total",
+		"smc_infiniband_port_info", "smc_infiniband_port_rate_bps"))
+}
+
+func TestInfinibandCollectHwCounters(t *testing.T) {
+	devices, err := GetInfinibandDevices(writeInfinibandSysfs(t))
+	require.NoError(t, err)
+	c, err := NewInfinibandCollector("smc", func() Slots { return nil }, nil)
+	require.NoError(t, err)
+	slots := Slots{{Designation: "PCIe Slot 3", BusAddress: "0000:1b:00.0", SlotNumber: "3"}}
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectDevices(ch, devices[1:], slots, nil)
+	})
+	expected := `
+# HELP smc_infiniband_port_hw_counter_total Driver counter from /sys/class/infiniband/<ca>/ports/<port>/hw_counters.
+# TYPE smc_infiniband_port_hw_counter_total counter
+smc_infiniband_port_hw_counter_total{caname="mlx5_1",counter="local_ack_timeout_err",ib_port="1",netdev="ens1f1np1",port="2",slot="3"} 2
+smc_infiniband_port_hw_counter_total{caname="mlx5_1",counter="np_cnp_sent",ib_port="1",netdev="ens1f1np1",port="2",slot="3"} 120
+smc_infiniband_port_hw_counter_total{caname="mlx5_1",counter="np_ecn_marked_roce_packets",ib_port="1",netdev="ens1f1np1",port="2",slot="3"} 240
+smc_infiniband_port_hw_counter_total{caname="mlx5_1",counter="out_of_sequence",ib_port="1",netdev="ens1f1np1",port="2",slot="3"} 7
+smc_infiniband_port_hw_counter_total{caname="mlx5_1",counter="rp_cnp_handled",ib_port="1",netdev="ens1f1np1",port="2",slot="3"} 118
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "smc_infiniband_port_hw_counter_total"))
+}
+
+func TestInfinibandCollectUsesSlots(t *testing.T) {
+	nm := NewNicModuleCollector("smc_nic_module", NicModuleOptions{})
+	nm.cacheMetrics(metricsSnapshot{slots: Slots{{Designation: "PCIe Slot 7", BusAddress: "0000:1b:00.0", SlotNumber: "7"}}})
+	c, err := NewInfinibandCollector("smc", nm.Slots, nm.Ports)
+	require.NoError(t, err)
+	c.sysPath = writeInfinibandSysfs(t)
+	expected := `
+# HELP smc_infiniband_port_rate_bps Port rate in bits per second.
+# TYPE smc_infiniband_port_rate_bps gauge
+smc_infiniband_port_rate_bps{caname="mlx5_0",ib_port="1",netdev="ibp27s0",port="1",slot="7"} 2e+11
+smc_infiniband_port_rate_bps{caname="mlx5_1",ib_port="1",netdev="ens1f1np1",port="2",slot="7"} 4e+10
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "smc_infiniband_port_rate_bps"))
+}
+
+func TestInfinibandModuleInfoJoin(t *testing.T) {
+	ports := []PortMetrics{
+		{caname: "mlx5_0", serial: "5C2410312316", vendor: "Firmus", partNumber: "OSFP400I-SR4-5M"},
+		{caname: "mlx5_1", serial: "MT2310FT0123", vendor: "Mellanox", partNumber: "MMA1B00-C100D"},
+		// ports read through ethtool have no caname
+		{netdev: "eth0", serial: "SFP0001"},
+	}
+	c, err := NewInfinibandCollector("smc", func() Slots { return nil }, func() []PortMetrics { return ports })
+	require.NoError(t, err)
+	c.sysPath = writeInfinibandSysfs(t)
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	// Join the hw_counters with the module info on caname and ib_port, like
+	// hw_counter_total * on(caname, ib_port) group_left(serial) port_module_info
+	labels := func(metric *dto.Metric) map[string]string {
+		result := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			result[label.GetName()] = label.GetValue()
+		}
+		return result
+	}
+	serials := map[string]string{}
+	var hwCounters []map[string]string
+	for _, family := range families {
+		switch family.GetName() {
+		case "smc_infiniband_port_module_info":
+			for _, metric := range family.GetMetric() {
+				l := labels(metric)
+				serials[l["caname"]+"/"+l["ib_port"]] = l["serial"]
+			}
+		case "smc_infiniband_port_hw_counter_total":
+			for _, metric := range family.GetMetric() {
+				hwCounters = append(hwCounters, labels(metric))
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{"mlx5_0/1": "5C2410312316", "mlx5_1/1": "MT2310FT0123"}, serials)
+	require.NotEmpty(t, hwCounters)
+	for _, l := range hwCounters {
+		assert.Equal(t, "MT2310FT0123", serials[l["caname"]+"/"+l["ib_port"]], l["counter"])
+	}
+
+	expected := `
+# HELP smc_infiniband_port_module_info Transceiver of the port as read by the NIC module collector, value is always 1.
+# TYPE smc_infiniband_port_module_info gauge
+smc_infiniband_port_module_info{caname="mlx5_0",ib_port="1",netdev="ibp27s0",part_number="OSFP400I-SR4-5M",port="1",serial="5C2410312316",slot="",vendor="Firmus"} 1
+smc_infiniband_port_module_info{caname="mlx5_1",ib_port="1",netdev="ens1f1np1",part_number="MMA1B00-C100D",port="2",serial="MT2310FT0123",slot="",vendor="Mellanox"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "smc_infiniband_port_module_info"))
+}
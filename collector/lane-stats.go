@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"math"
+	"slices"
+)
+
+type laneStats struct {
+	min    float64
+	max    float64
+	spread float64
+	// deviations holds, per lane, the difference between the lane and the
+	// median of the other lanes of the port, in the unit of the measurement.
+	deviations []float64
+}
+
+// laneMeasurement names a per-lane slice of PortMetrics for the lane
+// statistics metrics.
+type laneMeasurement struct {
+	name   string
+	values func(port PortMetrics) []float64
+}
+
+var laneMeasurements = []laneMeasurement{
+	{"rx_power_dBm", func(port PortMetrics) []float64 { return port.rxPower }},
+	{"tx_power_dBm", func(port PortMetrics) []float64 { return port.txPower }},
+	{"bias_current_mA", func(port PortMetrics) []float64 { return port.biasCurrent }},
+	{"snr_media_dB", func(port PortMetrics) []float64 { return port.snrMedia }},
+	{"snr_host_dB", func(port PortMetrics) []float64 { return port.snrHost }},
+}
+
+// computeLaneStats returns false for ports with fewer than two lanes, where
+// there are no siblings to compare against.
+func computeLaneStats(values []float64) (laneStats, bool) {
+	if len(values) < 2 {
+		return laneStats{}, false
+	}
+
+	stats := laneStats{min: values[0], max: values[0]}
+	for _, value := range values {
+		stats.min = math.Min(stats.min, value)
+		stats.max = math.Max(stats.max, value)
+	}
+	stats.spread = stats.max - stats.min
+
+	// The lane is left out of what it is compared against, so that one weak
+	// lane does not pull the reference towards itself.
+	stats.deviations = make([]float64, len(values))
+	others := make([]float64, 0, len(values)-1)
+	for i, value := range values {
+		others = append(append(others[:0], values[:i]...), values[i+1:]...)
+		stats.deviations[i] = value - median(others)
+	}
+	return stats, true
+}
+
+// median sorts values and returns their median.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeLaneStatsWeakLane(t *testing.T) {
+	stats, ok := computeLaneStats([]float64{-1, -1, -4, -1})
+	assert.True(t, ok)
+	assert.Equal(t, -4.0, stats.min)
+	assert.Equal(t, -1.0, stats.max)
+	assert.Equal(t, 3.0, stats.spread)
+	assert.Equal(t, []float64{0, 0, -3, 0}, stats.deviations)
+}
+
+func TestComputeLaneStatsWeakLaneAmongNoise(t *testing.T) {
+	// One lane 3 dB below noisy siblings stands out from the noise
+	stats, ok := computeLaneStats([]float64{-1.1, -0.9, -1.0, -4.0, -1.2, -0.8, -1.0, -1.0})
+	assert.True(t, ok)
+	assert.InDelta(t, -3.0, stats.deviations[3], 1e-9)
+	for i, deviation := range stats.deviations {
+		if i != 3 {
+			assert.Less(t, deviation*deviation, 0.05, "lane %d", i)
+		}
+	}
+
+	// and by how much it is low, not only that it is the outlier
+	stats, _ = computeLaneStats([]float64{-1, -1, -1.1, -1})
+	assert.InDelta(t, -0.1, stats.deviations[2], 1e-9)
+	stats, _ = computeLaneStats([]float64{-1, -1, -11, -1})
+	assert.InDelta(t, -10.0, stats.deviations[2], 1e-9)
+}
+
+func TestComputeLaneStatsEqualLanes(t *testing.T) {
+	stats, ok := computeLaneStats([]float64{2, 2, 2, 2})
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, stats.spread)
+	assert.Equal(t, []float64{0, 0, 0, 0}, stats.deviations)
+}
+
+func TestComputeLaneStatsTwoLanes(t *testing.T) {
+	stats, ok := computeLaneStats([]float64{-1, -4})
+	assert.True(t, ok)
+	assert.Equal(t, []float64{3, -3}, stats.deviations)
+}
+
+func TestComputeLaneStatsSingleLane(t *testing.T) {
+	_, ok := computeLaneStats([]float64{-3})
+	assert.False(t, ok)
+	_, ok = computeLaneStats([]float64{})
+	assert.False(t, ok)
+}
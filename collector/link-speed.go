@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// linkSpeed is the rate of a link decoded from the speed mlxlink reports in
+// Operational Info.
+type linkSpeed struct {
+	// bps is the total rate of the link.
+	bps float64
+	// laneBps is the rate of one lane, 0 when the number of lanes is not
+	// known.
+	laneBps float64
+}
+
+// ibLaneRates is the data rate of one lane of each InfiniBand generation.
+var ibLaneRates = map[string]float64{
+	"SDR":   2.5e9,
+	"DDR":   5e9,
+	"QDR":   10e9,
+	"FDR10": 10e9,
+	"FDR":   14e9,
+	"EDR":   25e9,
+	"HDR":   50e9,
+	"NDR":   100e9,
+	"XDR":   200e9,
+}
+
+// ibDefaultWidth is the width InfiniBand rates are given for when mlxlink
+// does not report one.
+const ibDefaultWidth = 4
+
+// ethLegacySpeeds holds the Ethernet names that do not spell out their
+// rate, with their number of lanes.
+var ethLegacySpeeds = map[string]struct {
+	bps   float64
+	lanes int
+}{
+	"CX":  {1e9, 1},
+	"KX":  {1e9, 1},
+	"CX4": {10e9, 4},
+	"KX4": {10e9, 4},
+}
+
+var speedUnits = map[string]float64{
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+}
+
+var (
+	ibSpeedPattern = regexp.MustCompile(`^IB-([A-Z]+\d*)$`)
+	// Ethernet speeds are a rate, optionally followed by the lanes it is
+	// carried on, eg. "BaseT1000M", "25GbE", "400G", "200G_2X" or "1.6T_8X"
+	ethSpeedPattern = regexp.MustCompile(`^(?:BaseTx?)?(\d+(?:\.\d+)?)([MGT])(?:bE)?(?:_(\d+)X)?$`)
+)
+
+// parseLinkSpeed decodes the speed mlxlink reports, using width, the
+// number of active lanes, when the speed does not name them. A link without
+// speed ("N/A" or empty) is zero and recognised; false means the speed is
+// not understood.
+func parseLinkSpeed(speed string, width int) (linkSpeed, bool) {
+	switch speed {
+	case "", "N/A":
+		return linkSpeed{}, true
+	}
+	if match := ibSpeedPattern.FindStringSubmatch(speed); match != nil {
+		laneRate, ok := ibLaneRates[match[1]]
+		if !ok {
+			return linkSpeed{}, false
+		}
+		lanes := width
+		if lanes <= 0 {
+			lanes = ibDefaultWidth
+		}
+		return linkSpeed{bps: laneRate * float64(lanes), laneBps: laneRate}, true
+	}
+	if legacy, ok := ethLegacySpeeds[speed]; ok {
+		return linkSpeed{bps: legacy.bps, laneBps: legacy.bps / float64(legacy.lanes)}, true
+	}
+	if match := ethSpeedPattern.FindStringSubmatch(speed); match != nil {
+		rate, err := strconv.ParseFloat(match[1], 64)
+		if err != nil || rate <= 0 {
+			return linkSpeed{}, false
+		}
+		result := linkSpeed{bps: rate * speedUnits[match[2]]}
+		lanes := width
+		if match[3] != "" {
+			lanes, _ = strconv.Atoi(match[3])
+		}
+		if lanes > 0 {
+			result.laneBps = result.bps / float64(lanes)
+		}
+		return result, true
+	}
+	return linkSpeed{}, false
+}
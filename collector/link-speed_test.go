@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseLinkSpeed(t *testing.T) {
+	tests := []struct {
+		speed   string
+		width   int
+		bps     float64
+		laneBps float64
+		ok      bool
+	}{
+		// InfiniBand rates are per lane times the width
+		{"IB-NDR", 4, 400e9, 100e9, true},
+		{"IB-NDR", 0, 400e9, 100e9, true},
+		{"IB-NDR", 2, 200e9, 100e9, true},
+		{"IB-HDR", 2, 100e9, 50e9, true},
+		{"IB-FDR", 4, 56e9, 14e9, true},
+		{"IB-FDR10", 4, 40e9, 10e9, true},
+		{"IB-SDR", 4, 10e9, 2.5e9, true},
+		{"IB-XDR", 4, 800e9, 200e9, true},
+		// Ethernet extended, with and without lanes
+		{"200G", 4, 200e9, 50e9, true},
+		{"200G_2X", 4, 200e9, 100e9, true},
+		{"400G_4X", 0, 400e9, 100e9, true},
+		{"800G_8X", 8, 800e9, 100e9, true},
+		{"1.6T_8X", 8, 1.6e12, 200e9, true},
+		{"2.5G", 1, 2.5e9, 2.5e9, true},
+		{"10M", 0, 10e6, 0, true},
+		// Ethernet legacy
+		{"100GbE", 4, 100e9, 25e9, true},
+		{"BaseTx100M", 1, 100e6, 100e6, true},
+		{"BaseT1000M", 0, 1e9, 0, true},
+		{"BaseT10G", 1, 10e9, 10e9, true},
+		{"KX4", 0, 10e9, 2.5e9, true},
+		{"CX", 0, 1e9, 1e9, true},
+		// No link
+		{"N/A", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+		// Not understood
+		{"IB-ZDR", 4, 0, 0, false},
+		{"400G_4Y", 4, 0, 0, false},
+		{"0G", 4, 0, 0, false},
+		{"fast", 4, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.speed, func(t *testing.T) {
+			speed, ok := parseLinkSpeed(test.speed, test.width)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.bps, speed.bps)
+			assert.Equal(t, test.laneBps, speed.laneBps)
+		})
+	}
+}
+
+func TestUnrecognizedLinkSpeed(t *testing.T) {
+	bytes, err := os.ReadFile("testdata/mlxlink_active_infiniband.json")
+	require.NoError(t, err)
+	output := strings.Replace(string(bytes), `"IB-NDR"`, `"IB-ZDR"`, 1)
+	port := parseOutput(gjson.Parse(output), "hostname", "systemserial", "slot", "1", DeviceInfo{caName: "mlx5_0", mode: "infiniband"})
+	assert.Equal(t, 0.0, port.speed)
+	assert.Equal(t, "IB-ZDR", port.unrecognizedSpeed)
+
+	collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{})
+	collector.countUnrecognizedSpeeds([]PortMetrics{port, port, {caname: "mlx5_1"}})
+	expected := `
+# HELP smc_nic_module_link_speed_unrecognized_total Number of times a port reported a link speed the exporter does not understand
+# TYPE smc_nic_module_link_speed_unrecognized_total counter
+smc_nic_module_link_speed_unrecognized_total{speed="IB-ZDR"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "smc_nic_module_link_speed_unrecognized_total"))
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// mlxlinkOutputPath is where mlxlink -json puts its sections.
+const mlxlinkOutputPath = "result.output."
+
+// mlxlinkPath is the keys a value can have in the output of one MFT release,
+// tried in order. Paths are relative to result.output; a path without keys is a
+// value the release does not report.
+type mlxlinkPath []string
+
+// mlxlinkSchema holds where parseOutput finds each value in the JSON output
+// of the mlxlink releases from minVersion on.
+type mlxlinkSchema struct {
+	name       string
+	minVersion mftVersion
+
+	state         mlxlinkPath
+	physicalState mlxlinkPath
+	speed         mlxlinkPath
+	width         mlxlinkPath
+	fec           mlxlinkPath
+
+	enabledSpeeds        mlxlinkPath
+	supportedCableSpeeds mlxlinkPath
+
+	moduleInfo    mlxlinkPath
+	serial        mlxlinkPath
+	vendor        mlxlinkPath
+	partNumber    mlxlinkPath
+	moduleState   mlxlinkPath
+	cableType     mlxlinkPath
+	temperature   mlxlinkPath
+	snrMedia      mlxlinkPath
+	snrHost       mlxlinkPath
+	rxLos         mlxlinkPath
+	dataPathState mlxlinkPath
+	rxPower       mlxlinkPath
+	txPower       mlxlinkPath
+	biasCurrent   mlxlinkPath
+	voltage       mlxlinkPath
+	wavelength    mlxlinkPath
+
+	effectiveBer    mlxlinkPath
+	effectiveErrors mlxlinkPath
+	rawBer          mlxlinkPath
+	rawErrors       mlxlinkPath
+	symbolBer       mlxlinkPath
+	symbolErrors    mlxlinkPath
+	linkDown        mlxlinkPath
+	linkRecovery    mlxlinkPath
+	lastClearTime   mlxlinkPath
+	fecHistogram    mlxlinkPath
+}
+
+// get returns the value at the first key of path that is in the output, or
+// a missing value when there is none.
+func (s *mlxlinkSchema) get(mlxout gjson.Result, path mlxlinkPath) gjson.Result {
+	for _, key := range path {
+		if value := mlxout.Get(mlxlinkOutputPath + key); value.Exists() {
+			return value
+		}
+	}
+	return gjson.Result{}
+}
+
+// mlxlinkSchemaMFT422 is the output of MFT 4.22 and later.
+var mlxlinkSchemaMFT422 = mlxlinkSchema{
+	name:       "mft-4.22",
+	minVersion: mftVersion{4, 22, 0},
+
+	state:         mlxlinkPath{"Operational Info.State"},
+	physicalState: mlxlinkPath{"Operational Info.Physical state"},
+	speed:         mlxlinkPath{"Operational Info.Speed"},
+	width:         mlxlinkPath{"Operational Info.Width"},
+	fec:           mlxlinkPath{"Operational Info.FEC"},
+
+	// Ports with extended Ethernet speeds report the (Ext.) keys, the others
+	// keep the older names
+	enabledSpeeds:        mlxlinkPath{"Supported Info.Enabled Link Speed (Ext\\.).values", "Supported Info.Enabled Link Speed.values"},
+	supportedCableSpeeds: mlxlinkPath{"Supported Info.Supported Cable Speed (Ext\\.).values", "Supported Info.Supported Cable Speed.values"},
+
+	moduleInfo:    mlxlinkPath{"Module Info"},
+	serial:        mlxlinkPath{"Module Info.Vendor Serial Number"},
+	vendor:        mlxlinkPath{"Module Info.Vendor Name"},
+	partNumber:    mlxlinkPath{"Module Info.Vendor Part Number"},
+	moduleState:   mlxlinkPath{"Module Info.Module State"},
+	cableType:     mlxlinkPath{"Module Info.Cable Type"},
+	temperature:   mlxlinkPath{"Module Info.Temperature [C]"},
+	snrMedia:      mlxlinkPath{"Module Info.SNR Media Lanes [dB].values"},
+	snrHost:       mlxlinkPath{"Module Info.SNR Host Lanes [dB].values"},
+	rxLos:         mlxlinkPath{"Module Info.Rx LOS [per lane].values"},
+	dataPathState: mlxlinkPath{"Module Info.DataPath state [per lane].values"},
+	rxPower:       mlxlinkPath{"Module Info.Rx Power Current [dBm]"},
+	txPower:       mlxlinkPath{"Module Info.Tx Power Current [dBm]"},
+	biasCurrent:   mlxlinkPath{"Module Info.Bias Current [mA]"},
+	voltage:       mlxlinkPath{"Module Info.Voltage [mV]"},
+	wavelength:    mlxlinkPath{"Module Info.Wavelength [nm]"},
+
+	effectiveBer:    mlxlinkPath{"Physical Counters and BER Info.Effective Physical BER"},
+	effectiveErrors: mlxlinkPath{"Physical Counters and BER Info.Effective Physical Errors"},
+	rawBer:          mlxlinkPath{"Physical Counters and BER Info.Raw Physical BER"},
+	rawErrors:       mlxlinkPath{"Physical Counters and BER Info.Raw Physical Errors Per Lane.values"},
+	symbolBer:       mlxlinkPath{"Physical Counters and BER Info.Symbol BER"},
+	symbolErrors:    mlxlinkPath{"Physical Counters and BER Info.Symbol Errors"},
+	linkDown:        mlxlinkPath{"Physical Counters and BER Info.Link Down Counter"},
+	linkRecovery:    mlxlinkPath{"Physical Counters and BER Info.Link Error Recovery Counter"},
+	lastClearTime:   mlxlinkPath{"Physical Counters and BER Info.Time Since Last Clear [Min]"},
+	fecHistogram:    mlxlinkPath{"Histogram of FEC Errors"},
+}
+
+// mlxlinkSchemas are the known schemas, newest first. A schema is only added
+// for a release whose output has been captured in testdata; the output of
+// older releases is read with the oldest schema.
+var mlxlinkSchemas = []*mlxlinkSchema{
+	&mlxlinkSchemaMFT422,
+}
+
+// mftVersion is the major, minor and patch version of an MFT release.
+type mftVersion [3]int
+
+func (v mftVersion) less(other mftVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+var mftVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseMftVersion parses the MFT Version of Tool Information, eg.
+// "mft 4.22.1-307".
+func parseMftVersion(value string) (mftVersion, bool) {
+	match := mftVersionPattern.FindStringSubmatch(value)
+	if match == nil {
+		return mftVersion{}, false
+	}
+	var version mftVersion
+	for i := range version {
+		version[i], _ = strconv.Atoi(match[i+1])
+	}
+	return version, true
+}
+
+// selectMlxlinkSchema returns the schema of the MFT release that produced
+// the output and the raw MFT version. Output without a version that can be
+// parsed is read with the newest schema, and output older than every schema
+// with the oldest one.
+func selectMlxlinkSchema(mlxout gjson.Result) (*mlxlinkSchema, string) {
+	raw := mlxout.Get(mlxlinkOutputPath + "Tool Information.MFT Version").String()
+	version, ok := parseMftVersion(raw)
+	if !ok {
+		return mlxlinkSchemas[0], raw
+	}
+	for _, schema := range mlxlinkSchemas {
+		if !version.less(schema.minVersion) {
+			return schema, raw
+		}
+	}
+	return mlxlinkSchemas[len(mlxlinkSchemas)-1], raw
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of the mlxlink fixtures")
+
+func TestParseMftVersion(t *testing.T) {
+	version, ok := parseMftVersion("mft 4.22.1-307")
+	assert.True(t, ok)
+	assert.Equal(t, mftVersion{4, 22, 1}, version)
+
+	version, ok = parseMftVersion("4.30")
+	assert.True(t, ok)
+	assert.Equal(t, mftVersion{4, 30, 0}, version)
+
+	_, ok = parseMftVersion("N/A")
+	assert.False(t, ok)
+}
+
+func TestSelectMlxlinkSchema(t *testing.T) {
+	tests := []struct {
+		mftVersion string
+		schema     string
+	}{
+		// Releases older than every schema are read with the oldest one
+		{"mft 4.18.0-106", "mft-4.22"},
+		{"mft 4.21.9-1", "mft-4.22"},
+		{"mft 4.22.0-1", "mft-4.22"},
+		{"mft 4.22.1-307", "mft-4.22"},
+		{"mft 4.26.1-3", "mft-4.22"},
+		{"mft 5.0.0-1", "mft-4.22"},
+		// Unknown versions are read with the newest schema
+		{"", "mft-4.22"},
+		{"unknown", "mft-4.22"},
+	}
+	for _, test := range tests {
+		mlxout := gjson.Parse(`{"result":{"output":{"Tool Information":{"MFT Version":"` + test.mftVersion + `"}}}}`)
+		schema, mftVersion := selectMlxlinkSchema(mlxout)
+		assert.Equal(t, test.schema, schema.name, test.mftVersion)
+		assert.Equal(t, test.mftVersion, mftVersion)
+	}
+}
+
+func TestMlxlinkSchemaRenamedKeys(t *testing.T) {
+	output := func(mftVersion, supportedInfo string) gjson.Result {
+		return gjson.Parse(`{"result":{"output":{"Supported Info":` + supportedInfo + `,"Tool Information":{"MFT Version":"` + mftVersion + `"}}}}`)
+	}
+	device := DeviceInfo{pciAddress: "0000:1a:00.0", mode: "ethernet", caName: "mlx5_0", netDev: "eth0"}
+
+	// Ports with extended Ethernet speeds name them with (Ext.)
+	port := parseOutput(output("mft 4.22.1-307", `{"Enabled Link Speed (Ext.)":{"values":["200G_4X","100G_2X"]},"Supported Cable Speed (Ext.)":{"values":["200G_4X"]}}`), "hostname", "systemserial", "38", "1", device)
+	assert.Equal(t, "mft-4.22", port.mlxlinkSchema)
+	assert.Equal(t, []string{"200G_4X", "100G_2X"}, port.enabledSpeeds)
+	assert.Equal(t, []string{"200G_4X"}, port.supportedCableSpeeds)
+
+	// and keeps the older names for the other ports
+	port = parseOutput(output("mft 4.22.1-307", `{"Enabled Link Speed":{"values":["NDR","HDR"]},"Supported Cable Speed":{"values":["NDR"]}}`), "hostname", "systemserial", "38", "1", device)
+	assert.Equal(t, []string{"NDR", "HDR"}, port.enabledSpeeds)
+	assert.Equal(t, []string{"NDR"}, port.supportedCableSpeeds)
+}
+
+// TestMlxlinkGolden parses every mlxlink fixture and compares the metrics
+// exported for it with testdata/golden/<fixture>.prom. Run with -update to
+// rewrite the golden files after a deliberate change.
+func TestMlxlinkGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/mlxlink_*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			output, err := os.ReadFile(fixture)
+			require.NoError(t, err)
+			mode := "ethernet"
+			if strings.Contains(name, "infiniband") {
+				mode = "infiniband"
+			}
+			device := DeviceInfo{pciAddress: "0000:1a:00.0", mode: mode, caName: "mlx5_0", netDev: "eth0"}
+			port := parseOutput(gjson.Parse(string(output)), "hostname", "systemserial", "38", "1", device)
+
+			collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{})
+			collector.cacheMetrics(metricsSnapshot{ports: []PortMetrics{port}, time: time.Unix(1700000000, 0)})
+			registry := prometheus.NewPedanticRegistry()
+			require.NoError(t, registry.Register(collector))
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			var buffer bytes.Buffer
+			encoder := expfmt.NewEncoder(&buffer, expfmt.NewFormat(expfmt.TypeTextPlain))
+			for _, family := range families {
+				require.NoError(t, encoder.Encode(family))
+			}
+			metrics := buffer.Bytes()
+
+			golden := filepath.Join("testdata/golden", name+".prom")
+			if *updateGolden {
+				require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+				require.NoError(t, os.WriteFile(golden, metrics, 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(metrics))
+		})
+	}
+}
@@ -23,10 +23,14 @@ import (
 	"bufio"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -48,7 +52,17 @@ type DeviceInfo struct {
 	pkey       string
 }
 
+// portName is the device label of a port in smc_collect_errors_total: the
+// caname, or the netdev of ports without one.
+func portName(caname, netdev string) string {
+	if caname == "" {
+		return netdev
+	}
+	return caname
+}
+
 type PortMetrics struct {
+	source       string
 	mode         string
 	caname       string
 	netdev       string
@@ -61,11 +75,30 @@ type PortMetrics struct {
 	port         string
 	pkey         string
 
+	stateName          string
+	physicalStateName  string
+	moduleStateName    string
+	dataPathStateNames []string
+	fecMode            string
+	// mlxlinkSchema is the name of the schema the mlxlink output of the
+	// MFT release mftVersion was read with.
+	mlxlinkSchema string
+	mftVersion    string
+	// unrecognizedSpeed is the speed mlxlink reported when parseLinkSpeed
+	// does not understand it.
+	unrecognizedSpeed string
+	// enabledSpeeds and supportedCableSpeeds are the speeds mlxlink lists
+	// in Supported Info, eg. "200G_2X" or "NDR".
+	enabledSpeeds        []string
+	supportedCableSpeeds []string
+
 	state            float64
 	physicalState    float64
 	moduleState      float64
 	dataPathState    []float64
+	rxLos            []bool
 	speed            float64
+	laneSpeed        float64
 	width            float64
 	biasCurrent      []float64
 	temperature      float64
@@ -74,6 +107,10 @@ type PortMetrics struct {
 	transferDistance float64
 	rxPower          []float64
 	txPower          []float64
+	rxPowerRange     *valueRange
+	txPowerRange     *valueRange
+	biasCurrentRange *valueRange
+	trends           []trendEstimate
 	snrMedia         []float64
 	snrHost          []float64
 	attenuation      map[string]float64
@@ -89,41 +126,114 @@ type PortMetrics struct {
 	lastClearTime    float64
 }
 
+// ModuleCollector reads transceivers that mlxlink does not cover. The ports
+// it returns are exported with the same metrics and labels as the mlxlink
+// ones.
+type ModuleCollector interface {
+	CollectModules(hostname, systemserial string, slots Slots) []PortMetrics
+}
+
+// NicModuleOptions holds the optional behaviour of a NicModuleCollector.
+type NicModuleOptions struct {
+	// RawBerThreshold publishes a ber_threshold_exceeded event when a port's
+	// raw BER rises above it. Zero disables the check.
+	RawBerThreshold float64
+	// TrendWindow is how far back rx/tx power and bias current samples are
+	// kept for the degradation trend. Zero disables trend tracking.
+	TrendWindow time.Duration
+	// TrendResolution is the minimum time between two kept samples.
+	TrendResolution time.Duration
+	// ModuleCollectors read transceivers that mlxlink does not cover.
+	ModuleCollectors []ModuleCollector
+	// StateStore persists the last snapshot and the trend samples across
+	// restarts. Nil keeps all state in memory.
+	StateStore *StateStore
+	// NumericStates also exports the link, physical, module and datapath
+	// states as the numeric gauges of earlier versions, next to the state
+	// sets.
+	NumericStates bool
+}
+
 type NicModuleCollector struct {
 	cachedMetricsReads  chan readCachedMetricsRequest
-	cachedMetricsWrites chan []PortMetrics
-
-	netInfoDesc          *prometheus.Desc
-	stateDesc            *prometheus.Desc
-	physicalStateDesc    *prometheus.Desc
-	moduleStateDesc      *prometheus.Desc
-	dataPathStateDesc    *prometheus.Desc
-	speedDesc            *prometheus.Desc
-	widthDesc            *prometheus.Desc
-	biasCurrentDesc      *prometheus.Desc
-	temperatureDesc      *prometheus.Desc
-	voltageDesc          *prometheus.Desc
-	wavelengthDesc       *prometheus.Desc
-	transferDistanceDesc *prometheus.Desc
-	rxPowerDesc          *prometheus.Desc
-	txPowerDesc          *prometheus.Desc
-	snrMediaDesc         *prometheus.Desc
-	snrHostDesc          *prometheus.Desc
-	attenuationDesc      *prometheus.Desc
-	effectiveBerDesc     *prometheus.Desc
-	effectiveErrorsDesc  *prometheus.Desc
-	rawBerDesc           *prometheus.Desc
-	rawErrorsDesc        *prometheus.Desc
-	fecErrorsDesc        *prometheus.Desc
-	symbolBerDesc        *prometheus.Desc
-	symbolErrorsDesc     *prometheus.Desc
-	linkDownDesc         *prometheus.Desc
-	linkRecoveryDesc     *prometheus.Desc
-	lastClearTimeDesc    *prometheus.Desc
+	cachedMetricsWrites chan metricsSnapshot
+	events              *EventBroker
+	rediscover          chan struct{}
+	options             NicModuleOptions
+	system              *systemInventory
+	trends              *trendTracker
+
+	// savedPorts and savedTime are the snapshot last written to the state
+	// store, so that saveState does not rewrite it when nothing changed.
+	savedPorts []storedPort
+	savedTime  time.Time
+
+	// unrecognizedSpeeds counts the ports seen with each speed that
+	// parseLinkSpeed does not understand.
+	unrecognizedSpeedsMu sync.Mutex
+	unrecognizedSpeeds   map[string]float64
+
+	linkStateSet     *stateSet
+	physicalStateSet *stateSet
+	moduleStateSet   *stateSet
+	dataPathStateSet *stateSet
+
+	netInfoDesc           *prometheus.Desc
+	stateDesc             *prometheus.Desc
+	physicalStateDesc     *prometheus.Desc
+	moduleStateDesc       *prometheus.Desc
+	dataPathStateDesc     *prometheus.Desc
+	speedDesc             *prometheus.Desc
+	laneSpeedDesc         *prometheus.Desc
+	mlxlinkSchemaDesc     *prometheus.Desc
+	speedUnrecognizedDesc *prometheus.Desc
+	speedEnabledDesc      *prometheus.Desc
+	cableSpeedDesc        *prometheus.Desc
+	widthDesc             *prometheus.Desc
+	biasCurrentDesc       *prometheus.Desc
+	temperatureDesc       *prometheus.Desc
+	voltageDesc           *prometheus.Desc
+	wavelengthDesc        *prometheus.Desc
+	transferDistanceDesc  *prometheus.Desc
+	rxPowerDesc           *prometheus.Desc
+	txPowerDesc           *prometheus.Desc
+	snrMediaDesc          *prometheus.Desc
+	snrHostDesc           *prometheus.Desc
+	attenuationDesc       *prometheus.Desc
+	effectiveBerDesc      *prometheus.Desc
+	effectiveErrorsDesc   *prometheus.Desc
+	rawBerDesc            *prometheus.Desc
+	rawErrorsDesc         *prometheus.Desc
+	fecErrorsDesc         *prometheus.Desc
+	symbolBerDesc         *prometheus.Desc
+	symbolErrorsDesc      *prometheus.Desc
+	linkDownDesc          *prometheus.Desc
+	linkRecoveryDesc      *prometheus.Desc
+	lastClearTimeDesc     *prometheus.Desc
+	laneMinDesc           *prometheus.Desc
+	laneMaxDesc           *prometheus.Desc
+	laneSpreadDesc        *prometheus.Desc
+	laneDeviationDesc     *prometheus.Desc
+	trendSlopeDesc        *prometheus.Desc
+	secondsToLimitDesc    *prometheus.Desc
+	snapshotStaleDesc     *prometheus.Desc
+	snapshotTimeDesc      *prometheus.Desc
+}
+
+// metricsSnapshot is the result of one UpdateMetrics cycle. A snapshot
+// restored from the state store is stale until the first cycle completes.
+type metricsSnapshot struct {
+	ports []PortMetrics
+	time  time.Time
+	stale bool
+	// devices are the NICs found by the update, keyed by PCI address
+	devices map[string]DeviceInfo
+	// slots are the PCIe slots read by the update
+	slots Slots
 }
 
 type readCachedMetricsRequest struct {
-	resp chan []PortMetrics
+	resp chan metricsSnapshot
 }
 
 type runMlxlinkResponse struct {
@@ -148,49 +258,6 @@ var stateValues = map[string]float64{
 	"SubFSM active":   13,
 }
 
-var speed2bps = map[string]float64{
-	// IB
-	"IB-SDR":   10000000000,
-	"IB-DDR":   20000000000,
-	"IB-QDR":   40000000000,
-	"IB-FDR10": 40000000000,
-	"IB-FDR":   56000000000,
-	"IB-EDR":   100000000000,
-	"IB-HDR":   200000000000,
-	"IB-NDR":   400000000000,
-	"IB-XDR":   800000000000,
-	// Eth
-	"BaseTx100M": 100000000,
-	"BaseT1000M": 1000000000,
-	"BaseT10M":   10000000,
-	"CX":         1000000000,
-	"KX":         1000000000,
-	"CX4":        10000000000,
-	"KX4":        10000000000,
-	"BaseT10G":   10000000000,
-	"10GbE":      10000000000,
-	"20GbE":      20000000000,
-	"25GbE":      25000000000,
-	"40GbE":      40000000000,
-	"50GbE":      50000000000,
-	"56GbE":      56000000000,
-	"100GbE":     100000000000,
-	// Ext Eth
-	"100M": 100000000,
-	"1G":   1000000000,
-	"2.5G": 2500000000,
-	"5G":   5000000000,
-	"10G":  10000000000,
-	"40G":  40000000000,
-	"25G":  25000000000,
-	"50G":  50000000000,
-	"100G": 100000000000,
-	"200G": 200000000000,
-	"400G": 400000000000,
-	"800G": 800000000000,
-	"10M":  10000000,
-}
-
 var physicalStateValues = map[string]float64{
 	"Disabled":                   0,
 	"Initializing":               1,
@@ -236,28 +303,75 @@ var dataPathStateValues = map[string]float64{
 
 type Slots []SlotInfo
 
+// slotSysPath is the sysfs mount getSlot reads the PCI topology from.
+var slotSysPath = "/sys"
+
+// getSlot returns the slot number of the device. When the device is not in
+// a slot itself, such as a NIC behind an on-card PCIe switch or a riser
+// bridge, the slot of the nearest upstream bridge in a slot is returned.
 func (s *Slots) getSlot(pciAddress string) string {
+	if pciAddress == "" {
+		return ""
+	}
+	addresses := []string{pciAddress}
+	for _, ancestor := range getPciAncestors(slotSysPath, pciAddress) {
+		addresses = append(addresses, ancestor.Address())
+	}
+	for _, address := range addresses {
+		if slot, ok := s.findSlot(address); ok {
+			return slot
+		}
+	}
+	return ""
+}
+
+// findSlot returns the slot number of the slot at the address, ignoring the
+// function.
+func (s *Slots) findSlot(pciAddress string) (string, bool) {
 	slotAddress := pciAddress[:len(pciAddress)-1] + "0"
 	for _, slot := range *s {
 		if slot.BusAddress == slotAddress {
 			if utf8.ValidString(slot.SlotNumber) {
-				return slot.SlotNumber
+				return slot.SlotNumber, true
 			} else {
-				return "unknown"
+				return "unknown", true
 			}
 		}
 	}
-	return ""
+	return "", false
 }
 
-func NewNicModuleCollector(namespace string) *NicModuleCollector {
+// getPciAncestors returns the upstream bridges of a device, from its parent
+// up to the root port, or nil when the device is not in sysfs.
+func getPciAncestors(basePath, pciAddress string) []PciDeviceLocation {
+	realPath, err := os.Readlink(path.Join(basePath, pciDevicesPath, pciAddress))
+	if err != nil {
+		return nil
+	}
+	_, ancestors := parsePciDeviceChain(realPath)
+	return ancestors
+}
+
+func NewNicModuleCollector(namespace string, options NicModuleOptions) *NicModuleCollector {
 	laneLabel := []string{"lane"}
 	binLabel := []string{"bin"}
 	speedLabel := []string{"speed"}
+	measurementLabel := []string{"measurement"}
+	boundLabel := []string{"bound"}
 	stdLabels := []string{"mode", "caname", "netdev", "serial", "hostname", "product_serial", "vendor", "part_number", "slot", "port"}
 	collector := &NicModuleCollector{
 		cachedMetricsReads:  make(chan readCachedMetricsRequest),
-		cachedMetricsWrites: make(chan []PortMetrics),
+		cachedMetricsWrites: make(chan metricsSnapshot),
+		events:              NewEventBroker(),
+		rediscover:          make(chan struct{}, 1),
+		options:             options,
+		system:              newSystemInventory("/sys"),
+		unrecognizedSpeeds:  map[string]float64{},
+
+		linkStateSet:     newStateSet(namespace+"_link_state", "Link state", stateValues, stdLabels),
+		physicalStateSet: newStateSet(namespace+"_physical_state", "Physical link state", physicalStateValues, stdLabels),
+		moduleStateSet:   newStateSet(namespace+"_module_power_state", "Module state", moduleStateValues, stdLabels),
+		dataPathStateSet: newStateSet(namespace+"_datapath_lane_state", "DataPath state per lane", dataPathStateValues, append(laneLabel, stdLabels...)),
 
 		netInfoDesc: prometheus.NewDesc(
 			namespace+"_network_info",
@@ -301,6 +415,41 @@ func NewNicModuleCollector(namespace string) *NicModuleCollector {
 			nil,
 		),
 
+		laneSpeedDesc: prometheus.NewDesc(
+			namespace+"_link_lane_speed_bps",
+			"Link speed of one lane in bps",
+			stdLabels,
+			nil,
+		),
+
+		mlxlinkSchemaDesc: prometheus.NewDesc(
+			namespace+"_mlxlink_schema_info",
+			"Schema the mlxlink output of the port was read with and the MFT version that produced it, value is always 1.",
+			append(append([]string{}, stdLabels...), "schema", "mft_version"),
+			nil,
+		),
+
+		speedUnrecognizedDesc: prometheus.NewDesc(
+			namespace+"_link_speed_unrecognized_total",
+			"Number of times a port reported a link speed the exporter does not understand",
+			speedLabel,
+			nil,
+		),
+
+		speedEnabledDesc: prometheus.NewDesc(
+			namespace+"_link_speed_enabled",
+			"Link speed enabled on the port as named by mlxlink, value is always 1.",
+			append(speedLabel, stdLabels...),
+			nil,
+		),
+
+		cableSpeedDesc: prometheus.NewDesc(
+			namespace+"_cable_speed_supported",
+			"Link speed the cable supports as named by mlxlink, value is always 1.",
+			append(speedLabel, stdLabels...),
+			nil,
+		),
+
 		widthDesc: prometheus.NewDesc(
 			namespace+"_width",
 			"Width",
@@ -447,18 +596,143 @@ func NewNicModuleCollector(namespace string) *NicModuleCollector {
 			stdLabels,
 			nil,
 		),
+
+		laneMinDesc: prometheus.NewDesc(
+			namespace+"_lane_min",
+			"Lowest per lane value of a measurement across the lanes of the port",
+			append(measurementLabel, stdLabels...),
+			nil,
+		),
+
+		laneMaxDesc: prometheus.NewDesc(
+			namespace+"_lane_max",
+			"Highest per lane value of a measurement across the lanes of the port",
+			append(measurementLabel, stdLabels...),
+			nil,
+		),
+
+		laneSpreadDesc: prometheus.NewDesc(
+			namespace+"_lane_spread",
+			"Difference between the highest and lowest lane of a measurement",
+			append(measurementLabel, stdLabels...),
+			nil,
+		),
+
+		laneDeviationDesc: prometheus.NewDesc(
+			namespace+"_lane_deviation",
+			"Difference between a lane and the median of the other lanes of the port, in the unit of the measurement",
+			append(append(laneLabel, measurementLabel...), stdLabels...),
+			nil,
+		),
+
+		trendSlopeDesc: prometheus.NewDesc(
+			namespace+"_optical_trend_slope_per_second",
+			"Slope of a least squares fit over the trend window of a per lane measurement, in its unit per second",
+			append(append(laneLabel, measurementLabel...), stdLabels...),
+			nil,
+		),
+
+		secondsToLimitDesc: prometheus.NewDesc(
+			namespace+"_optical_seconds_to_threshold",
+			"Estimated seconds until the trend of a per lane measurement reaches the low or high end of the vendor range",
+			append(append(append(laneLabel, measurementLabel...), boundLabel...), stdLabels...),
+			nil,
+		),
+
+		snapshotStaleDesc: prometheus.NewDesc(
+			namespace+"_snapshot_stale",
+			"1 while the metrics are the snapshot restored from disk at startup, 0 once they have been refreshed",
+			nil,
+			nil,
+		),
+
+		snapshotTimeDesc: prometheus.NewDesc(
+			namespace+"_snapshot_timestamp_seconds",
+			"Unix time the metrics were read from the NICs",
+			nil,
+			nil,
+		),
+	}
+	if options.TrendWindow > 0 {
+		collector.trends = newTrendTracker(options.TrendWindow, options.TrendResolution)
 	}
 	go collector.manageCachedMetricsAccess()
+	collector.restoreState()
 	return collector
 }
 
+// restoreState serves the snapshot of the previous run, flagged as stale,
+// and restores the trend samples.
+func (n *NicModuleCollector) restoreState() {
+	if n.options.StateStore == nil {
+		return
+	}
+	var snapshot storedSnapshot
+	if err := n.options.StateStore.Load(snapshotRecord, &snapshot); err == nil {
+		ports := make([]PortMetrics, len(snapshot.Ports))
+		for i, port := range snapshot.Ports {
+			ports[i] = fromStoredPort(port)
+		}
+		n.cacheMetrics(metricsSnapshot{ports: ports, time: time.Unix(snapshot.Time, 0), stale: true})
+		n.savedPorts, n.savedTime = snapshot.Ports, time.Unix(snapshot.Time, 0)
+		log.Infof("Restored snapshot of %d ports from %s", len(ports), time.Unix(snapshot.Time, 0))
+	} else if err != ErrNoState {
+		log.Errorf("Error loading snapshot: %s", err)
+	}
+
+	var history storedHistory
+	if err := n.options.StateStore.Load(historyRecord, &history); err == nil {
+		if n.trends != nil {
+			n.trends.restore(history.Trends)
+		}
+	} else if err != ErrNoState {
+		log.Errorf("Error loading history: %s", err)
+	}
+}
+
+// saveState writes the snapshot when it differs from the one last saved, at
+// most once per trend resolution, and the trend samples when a sample was
+// added.
+func (n *NicModuleCollector) saveState(snapshot metricsSnapshot, historyChanged bool) {
+	if n.options.StateStore == nil {
+		return
+	}
+	stored := storedSnapshot{Time: snapshot.time.Unix(), Ports: make([]storedPort, len(snapshot.ports))}
+	for i, port := range snapshot.ports {
+		stored.Ports[i] = toStoredPort(port)
+	}
+	if !reflect.DeepEqual(stored.Ports, n.savedPorts) && snapshot.time.Sub(n.savedTime) >= n.options.TrendResolution {
+		if err := n.options.StateStore.Save(snapshotRecord, stored); err != nil {
+			log.Errorf("Error saving snapshot: %s", err)
+		} else {
+			n.savedPorts, n.savedTime = stored.Ports, snapshot.time
+		}
+	}
+	if historyChanged && n.trends != nil {
+		if err := n.options.StateStore.Save(historyRecord, storedHistory{Trends: n.trends.series}); err != nil {
+			log.Errorf("Error saving history: %s", err)
+		}
+	}
+}
+
 func (n *NicModuleCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- n.netInfoDesc
-	ch <- n.stateDesc
-	ch <- n.physicalStateDesc
-	ch <- n.moduleStateDesc
-	ch <- n.dataPathStateDesc
+	if n.options.NumericStates {
+		ch <- n.stateDesc
+		ch <- n.physicalStateDesc
+		ch <- n.moduleStateDesc
+		ch <- n.dataPathStateDesc
+	}
+	n.linkStateSet.describe(ch)
+	n.physicalStateSet.describe(ch)
+	n.moduleStateSet.describe(ch)
+	n.dataPathStateSet.describe(ch)
 	ch <- n.speedDesc
+	ch <- n.laneSpeedDesc
+	ch <- n.mlxlinkSchemaDesc
+	ch <- n.speedUnrecognizedDesc
+	ch <- n.speedEnabledDesc
+	ch <- n.cableSpeedDesc
 	ch <- n.widthDesc
 	ch <- n.biasCurrentDesc
 	ch <- n.temperatureDesc
@@ -480,89 +754,185 @@ func (n *NicModuleCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- n.linkDownDesc
 	ch <- n.linkRecoveryDesc
 	ch <- n.lastClearTimeDesc
+	ch <- n.laneMinDesc
+	ch <- n.laneMaxDesc
+	ch <- n.laneSpreadDesc
+	ch <- n.laneDeviationDesc
+	ch <- n.trendSlopeDesc
+	ch <- n.secondsToLimitDesc
+	ch <- n.snapshotStaleDesc
+	ch <- n.snapshotTimeDesc
 }
 
 func (n *NicModuleCollector) Collect(ch chan<- prometheus.Metric) {
-	cachedMetrics := n.getCachedMetrics()
-	for _, port := range cachedMetrics {
-		if port.netdev != "" {
-			ch <- prometheus.MustNewConstMetric(n.netInfoDesc, prometheus.GaugeValue, 1, port.netdev, port.pkey)
+	defer recoverCollect("nic_module", "")
+	snapshot := n.getCachedMetrics()
+	if !snapshot.time.IsZero() {
+		stale := 0.0
+		if snapshot.stale {
+			stale = 1
 		}
-		stdLabelValues := []string{port.mode, port.caname, port.netdev, port.serial, port.hostname, port.systemserial, port.vendor, port.partNumber, port.slot, port.port}
-		ch <- prometheus.MustNewConstMetric(n.stateDesc, prometheus.GaugeValue, port.state, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.physicalStateDesc, prometheus.GaugeValue, port.physicalState, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.moduleStateDesc, prometheus.GaugeValue, port.moduleState, stdLabelValues...)
+		sendConstMetric(ch, n.snapshotStaleDesc, prometheus.GaugeValue, stale)
+		sendConstMetric(ch, n.snapshotTimeDesc, prometheus.GaugeValue, float64(snapshot.time.Unix()))
+	}
+	n.unrecognizedSpeedsMu.Lock()
+	for speed, count := range n.unrecognizedSpeeds {
+		sendConstMetric(ch, n.speedUnrecognizedDesc, prometheus.CounterValue, count, speed)
+	}
+	n.unrecognizedSpeedsMu.Unlock()
+	for _, port := range snapshot.ports {
+		collectDevice("nic_module", portName(port.caname, port.netdev), func() { n.collectPort(ch, port) })
+	}
+}
+
+// collectPort exports the metrics of one port.
+func (n *NicModuleCollector) collectPort(ch chan<- prometheus.Metric, port PortMetrics) {
+	if port.netdev != "" {
+		sendConstMetric(ch, n.netInfoDesc, prometheus.GaugeValue, 1, port.netdev, port.pkey)
+	}
+	stdLabelValues := []string{port.mode, port.caname, port.netdev, port.serial, port.hostname, port.systemserial, port.vendor, port.partNumber, port.slot, port.port}
+	if n.options.NumericStates {
+		sendConstMetric(ch, n.stateDesc, prometheus.GaugeValue, port.state, stdLabelValues...)
+		sendConstMetric(ch, n.physicalStateDesc, prometheus.GaugeValue, port.physicalState, stdLabelValues...)
+		sendConstMetric(ch, n.moduleStateDesc, prometheus.GaugeValue, port.moduleState, stdLabelValues...)
 		for laneIdx, dataPathState := range port.dataPathState {
 			laneValue := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.dataPathStateDesc, prometheus.GaugeValue, dataPathState, append(laneValue, stdLabelValues...)...)
-		}
-		ch <- prometheus.MustNewConstMetric(n.speedDesc, prometheus.GaugeValue, port.speed, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.widthDesc, prometheus.GaugeValue, port.width, stdLabelValues...)
-		for laneIdx, biasCurrentValue := range port.biasCurrent {
-			laneValue := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.biasCurrentDesc, prometheus.GaugeValue, biasCurrentValue, append(laneValue, stdLabelValues...)...)
-		}
-		ch <- prometheus.MustNewConstMetric(n.temperatureDesc, prometheus.GaugeValue, port.temperature, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.voltageDesc, prometheus.GaugeValue, port.voltage, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.wavelengthDesc, prometheus.GaugeValue, port.wavelength, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.transferDistanceDesc, prometheus.GaugeValue, port.transferDistance, stdLabelValues...)
-		for laneIdx, rxPowerValue := range port.rxPower {
-			laneValue := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.rxPowerDesc, prometheus.GaugeValue, rxPowerValue, append(laneValue, stdLabelValues...)...)
-		}
-		for laneIdx, txPowerValue := range port.txPower {
-			laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.txPowerDesc, prometheus.GaugeValue, txPowerValue, append(laneLabelValues, stdLabelValues...)...)
-		}
-		for laneIdx, snrMediaValue := range port.snrMedia {
-			laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.snrMediaDesc, prometheus.GaugeValue, snrMediaValue, append(laneLabelValues, stdLabelValues...)...)
-		}
-		for laneIdx, snrHostValue := range port.snrHost {
-			laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.snrHostDesc, prometheus.GaugeValue, snrHostValue, append(laneLabelValues, stdLabelValues...)...)
+			sendConstMetric(ch, n.dataPathStateDesc, prometheus.GaugeValue, dataPathState, append(laneValue, stdLabelValues...)...)
 		}
-		for speedValue, attenuationValue := range port.attenuation {
-			speedLabelValues := []string{speedValue}
-			ch <- prometheus.MustNewConstMetric(n.attenuationDesc, prometheus.GaugeValue, attenuationValue, append(speedLabelValues, stdLabelValues...)...)
-		}
-		ch <- prometheus.MustNewConstMetric(n.effectiveBerDesc, prometheus.GaugeValue, port.effectiveBer, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.effectiveErrorsDesc, prometheus.CounterValue, port.effectiveErrors, stdLabelValues...)
-		ch <- prometheus.MustNewConstMetric(n.rawBerDesc, prometheus.GaugeValue, port.rawBer, stdLabelValues...)
+	}
+	n.linkStateSet.collect(ch, port.stateName, stdLabelValues...)
+	n.physicalStateSet.collect(ch, port.physicalStateName, stdLabelValues...)
+	n.moduleStateSet.collect(ch, port.moduleStateName, stdLabelValues...)
+	for laneIdx, dataPathState := range port.dataPathStateNames {
+		laneValue := []string{strconv.Itoa(laneIdx + 1)}
+		n.dataPathStateSet.collect(ch, dataPathState, append(laneValue, stdLabelValues...)...)
+	}
+	sendConstMetric(ch, n.speedDesc, prometheus.GaugeValue, port.speed, stdLabelValues...)
+	if port.mlxlinkSchema != "" {
+		sendConstMetric(ch, n.mlxlinkSchemaDesc, prometheus.GaugeValue, 1, append(append([]string{}, stdLabelValues...), port.mlxlinkSchema, port.mftVersion)...)
+	}
+	for _, speed := range port.enabledSpeeds {
+		sendConstMetric(ch, n.speedEnabledDesc, prometheus.GaugeValue, 1, append([]string{speed}, stdLabelValues...)...)
+	}
+	for _, speed := range port.supportedCableSpeeds {
+		sendConstMetric(ch, n.cableSpeedDesc, prometheus.GaugeValue, 1, append([]string{speed}, stdLabelValues...)...)
+	}
+	if port.laneSpeed > 0 {
+		sendConstMetric(ch, n.laneSpeedDesc, prometheus.GaugeValue, port.laneSpeed, stdLabelValues...)
+	}
+	sendConstMetric(ch, n.widthDesc, prometheus.GaugeValue, port.width, stdLabelValues...)
+	for laneIdx, biasCurrentValue := range port.biasCurrent {
+		laneValue := []string{strconv.Itoa(laneIdx + 1)}
+		sendConstMetric(ch, n.biasCurrentDesc, prometheus.GaugeValue, biasCurrentValue, append(laneValue, stdLabelValues...)...)
+	}
+	sendConstMetric(ch, n.temperatureDesc, prometheus.GaugeValue, port.temperature, stdLabelValues...)
+	sendConstMetric(ch, n.voltageDesc, prometheus.GaugeValue, port.voltage, stdLabelValues...)
+	sendConstMetric(ch, n.wavelengthDesc, prometheus.GaugeValue, port.wavelength, stdLabelValues...)
+	sendConstMetric(ch, n.transferDistanceDesc, prometheus.GaugeValue, port.transferDistance, stdLabelValues...)
+	for laneIdx, rxPowerValue := range port.rxPower {
+		laneValue := []string{strconv.Itoa(laneIdx + 1)}
+		sendConstMetric(ch, n.rxPowerDesc, prometheus.GaugeValue, rxPowerValue, append(laneValue, stdLabelValues...)...)
+	}
+	for laneIdx, txPowerValue := range port.txPower {
+		laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
+		sendConstMetric(ch, n.txPowerDesc, prometheus.GaugeValue, txPowerValue, append(laneLabelValues, stdLabelValues...)...)
+	}
+	for laneIdx, snrMediaValue := range port.snrMedia {
+		laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
+		sendConstMetric(ch, n.snrMediaDesc, prometheus.GaugeValue, snrMediaValue, append(laneLabelValues, stdLabelValues...)...)
+	}
+	for laneIdx, snrHostValue := range port.snrHost {
+		laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
+		sendConstMetric(ch, n.snrHostDesc, prometheus.GaugeValue, snrHostValue, append(laneLabelValues, stdLabelValues...)...)
+	}
+	for speedValue, attenuationValue := range port.attenuation {
+		speedLabelValues := []string{speedValue}
+		sendConstMetric(ch, n.attenuationDesc, prometheus.GaugeValue, attenuationValue, append(speedLabelValues, stdLabelValues...)...)
+	}
+	// The module EEPROM carries no physical counters
+	if port.source != sourceEthtool {
+		sendConstMetric(ch, n.effectiveBerDesc, prometheus.GaugeValue, port.effectiveBer, stdLabelValues...)
+		sendConstMetric(ch, n.effectiveErrorsDesc, prometheus.CounterValue, port.effectiveErrors, stdLabelValues...)
+		sendConstMetric(ch, n.rawBerDesc, prometheus.GaugeValue, port.rawBer, stdLabelValues...)
 		for laneIdx, rawErrors := range port.rawErrors {
 			laneValue := []string{strconv.Itoa(laneIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.rawErrorsDesc, prometheus.CounterValue, rawErrors, append(laneValue, stdLabelValues...)...)
+			sendConstMetric(ch, n.rawErrorsDesc, prometheus.CounterValue, rawErrors, append(laneValue, stdLabelValues...)...)
 		}
 
 		for binIdx, fecErrors := range port.fecErrors {
 			binValue := []string{strconv.Itoa(binIdx + 1)}
-			ch <- prometheus.MustNewConstMetric(n.fecErrorsDesc, prometheus.CounterValue, fecErrors, append(binValue, stdLabelValues...)...)
+			sendConstMetric(ch, n.fecErrorsDesc, prometheus.CounterValue, fecErrors, append(binValue, stdLabelValues...)...)
 		}
 		if port.mode == "infiniband" {
-			ch <- prometheus.MustNewConstMetric(n.symbolBerDesc, prometheus.GaugeValue, port.symbolBer, stdLabelValues...)
-			ch <- prometheus.MustNewConstMetric(n.symbolErrorsDesc, prometheus.CounterValue, port.symbolErrors, stdLabelValues...)
-			ch <- prometheus.MustNewConstMetric(n.linkDownDesc, prometheus.CounterValue, port.linkDown, stdLabelValues...)
-			ch <- prometheus.MustNewConstMetric(n.linkRecoveryDesc, prometheus.CounterValue, port.linkRecovery, stdLabelValues...)
+			sendConstMetric(ch, n.symbolBerDesc, prometheus.GaugeValue, port.symbolBer, stdLabelValues...)
+			sendConstMetric(ch, n.symbolErrorsDesc, prometheus.CounterValue, port.symbolErrors, stdLabelValues...)
+			sendConstMetric(ch, n.linkDownDesc, prometheus.CounterValue, port.linkDown, stdLabelValues...)
+			sendConstMetric(ch, n.linkRecoveryDesc, prometheus.CounterValue, port.linkRecovery, stdLabelValues...)
+		}
+		sendConstMetric(ch, n.lastClearTimeDesc, prometheus.GaugeValue, port.lastClearTime, stdLabelValues...)
+	}
+	for _, measurement := range laneMeasurements {
+		stats, ok := computeLaneStats(measurement.values(port))
+		if !ok {
+			continue
+		}
+		measurementLabelValues := append([]string{measurement.name}, stdLabelValues...)
+		sendConstMetric(ch, n.laneMinDesc, prometheus.GaugeValue, stats.min, measurementLabelValues...)
+		sendConstMetric(ch, n.laneMaxDesc, prometheus.GaugeValue, stats.max, measurementLabelValues...)
+		sendConstMetric(ch, n.laneSpreadDesc, prometheus.GaugeValue, stats.spread, measurementLabelValues...)
+		for laneIdx, deviation := range stats.deviations {
+			laneLabelValues := []string{strconv.Itoa(laneIdx + 1)}
+			sendConstMetric(ch, n.laneDeviationDesc, prometheus.GaugeValue, deviation, append(laneLabelValues, measurementLabelValues...)...)
 		}
-		ch <- prometheus.MustNewConstMetric(n.lastClearTimeDesc, prometheus.GaugeValue, port.lastClearTime, stdLabelValues...)
 	}
+	for _, trend := range port.trends {
+		trendLabelValues := append([]string{strconv.Itoa(trend.lane), trend.measurement}, stdLabelValues...)
+		sendConstMetric(ch, n.trendSlopeDesc, prometheus.GaugeValue, trend.slope, trendLabelValues...)
+		if trend.bound != "" {
+			boundLabelValues := append([]string{strconv.Itoa(trend.lane), trend.measurement, trend.bound}, stdLabelValues...)
+			sendConstMetric(ch, n.secondsToLimitDesc, prometheus.GaugeValue, trend.secondsToThreshold, boundLabelValues...)
+		}
+	}
+}
+
+// Devices returns the NICs found by the last UpdateMetrics, keyed by PCI
+// address, so that other collectors do not have to discover them again.
+func (n *NicModuleCollector) Devices() map[string]DeviceInfo {
+	return n.getCachedMetrics().devices
+}
+
+// Ports returns the ports read by the last UpdateMetrics.
+func (n *NicModuleCollector) Ports() []PortMetrics {
+	return n.getCachedMetrics().ports
 }
 
-func (n *NicModuleCollector) getCachedMetrics() []PortMetrics {
+// Slots returns the PCIe slots read by the last UpdateMetrics, so that other
+// collectors do not have to read SMBIOS and sysfs again on every scrape.
+func (n *NicModuleCollector) Slots() Slots {
+	return n.getCachedMetrics().slots
+}
+
+// Events returns the broker on which port and module changes detected by
+// UpdateMetrics are published.
+func (n *NicModuleCollector) Events() *EventBroker {
+	return n.events
+}
+
+func (n *NicModuleCollector) getCachedMetrics() metricsSnapshot {
 	request := readCachedMetricsRequest{
-		resp: make(chan []PortMetrics),
+		resp: make(chan metricsSnapshot),
 	}
 	n.cachedMetricsReads <- request
 	cachedMetrics := <-request.resp
 	return cachedMetrics
 }
 
-func (n *NicModuleCollector) cacheMetrics(metrics []PortMetrics) {
+func (n *NicModuleCollector) cacheMetrics(metrics metricsSnapshot) {
 	n.cachedMetricsWrites <- metrics
 }
 
 func (n *NicModuleCollector) manageCachedMetricsAccess() {
-	var cachedMetrics []PortMetrics
+	var cachedMetrics metricsSnapshot
 	for {
 		select {
 		case request := <-n.cachedMetricsReads:
@@ -573,12 +943,38 @@ func (n *NicModuleCollector) manageCachedMetricsAccess() {
 	}
 }
 
+// Rediscover makes a pending Wait return, so that the next UpdateMetrics
+// picks up added or removed NICs straight away, and makes the module
+// collectors that support it retry the modules they could not read.
+func (n *NicModuleCollector) Rediscover() {
+	for _, moduleCollector := range n.options.ModuleCollectors {
+		if rediscoverer, ok := moduleCollector.(interface{ Rediscover() }); ok {
+			rediscoverer.Rediscover()
+		}
+	}
+	select {
+	case n.rediscover <- struct{}{}:
+	default:
+	}
+}
+
+// Wait blocks until the interval has passed or Rediscover is called.
+func (n *NicModuleCollector) Wait(interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-n.rediscover:
+	}
+}
+
 func (n *NicModuleCollector) UpdateMetrics() {
+	defer recoverCollect("nic_module", "")
 	devices, _ := discoverMellanoxDevices()
 	pciAddress2PhysicalDeviceInfo := getPciAddress2DeviceInfo()
 	hostname := getHostName()
-	systemserial := getSystemSerial()
-	slots := getSlots()
+	systemserial := n.system.systemSerial()
+	slots := n.system.slots()
 
 	responses := make(chan runMlxlinkResponse)
 	for _, device := range devices {
@@ -600,7 +996,40 @@ func (n *NicModuleCollector) UpdateMetrics() {
 			metrics = append(metrics, response.result)
 		}
 	}
-	n.cacheMetrics(metrics)
+	for _, moduleCollector := range n.options.ModuleCollectors {
+		metrics = append(metrics, moduleCollector.CollectModules(hostname, systemserial, slots)...)
+	}
+	n.countUnrecognizedSpeeds(metrics)
+	now := time.Now()
+	historyChanged := false
+	if n.trends != nil {
+		historyChanged = n.trends.observe(metrics, now)
+	}
+	previous := n.getCachedMetrics()
+	snapshot := metricsSnapshot{ports: metrics, time: now, devices: pciAddress2PhysicalDeviceInfo, slots: slots}
+	n.cacheMetrics(snapshot)
+	if !previous.time.IsZero() {
+		for _, event := range detectEvents(previous.ports, metrics, n.options.RawBerThreshold, now) {
+			n.events.Publish(event)
+		}
+	}
+	n.saveState(snapshot, historyChanged)
+}
+
+// countUnrecognizedSpeeds counts the ports whose speed parseLinkSpeed does
+// not understand, logging each speed the first time it is seen.
+func (n *NicModuleCollector) countUnrecognizedSpeeds(ports []PortMetrics) {
+	n.unrecognizedSpeedsMu.Lock()
+	defer n.unrecognizedSpeedsMu.Unlock()
+	for _, port := range ports {
+		if port.unrecognizedSpeed == "" {
+			continue
+		}
+		if n.unrecognizedSpeeds[port.unrecognizedSpeed] == 0 {
+			log.Warnf("Unrecognized link speed %q on %s, exporting a speed of 0", port.unrecognizedSpeed, port.caname)
+		}
+		n.unrecognizedSpeeds[port.unrecognizedSpeed]++
+	}
 }
 
 func getFunction(s string) (int, bool) {
@@ -614,13 +1043,6 @@ func getFunction(s string) (int, bool) {
 	return 0, false
 }
 
-func getSystemSerial() string {
-	cmd := exec.Command("dmidecode", "-s", "system-serial-number")
-	out, _ := cmd.Output()
-	systemserial := strings.TrimSpace(string(out))
-	return systemserial
-}
-
 func getHostName() string {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -677,9 +1099,13 @@ func discoverMellanoxDevices() ([]DeviceInfo, error) {
 	mellanoxDevicePattern := regexp.MustCompile(`(Infiniband|Ethernet).*Mellanox`)
 	for _, line := range lines {
 		if mellanoxDevicePattern.MatchString(line) {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
 			deviceInfo := DeviceInfo{}
-			deviceInfo.pciAddress = strings.Fields(line)[0]
-			deviceInfo.mode = strings.ToLower((strings.Fields(line)[1]))
+			deviceInfo.pciAddress = fields[0]
+			deviceInfo.mode = strings.ToLower(fields[1])
 			mellanoxDevices = append(mellanoxDevices, deviceInfo)
 		}
 	}
@@ -705,8 +1131,13 @@ func (n *NicModuleCollector) runMlxlink(hostname, systemserial, slot, port strin
 		}
 	}
 
+	var metrics PortMetrics
+	if valid_output {
+		valid_output = collectDevice("nic_module", portName(device.caName, device.netDev), func() {
+			metrics = parseOutput(mlxout, hostname, systemserial, slot, port, device)
+		})
+	}
 	if valid_output {
-		metrics := parseOutput(mlxout, hostname, systemserial, slot, port, device)
 		resp <- runMlxlinkResponse{metrics, false}
 	} else {
 		resp <- runMlxlinkResponse{PortMetrics{}, true}
@@ -726,24 +1157,44 @@ func getNetDevice2IbDevice() map[string]string {
 	for _, line := range lines {
 		parts := strings.Split(line, " ==> ")
 		if len(parts) > 1 {
-			ibDevice := strings.Fields(parts[0])[0]
-			netDevice := strings.TrimSpace(strings.Fields(parts[1])[0])
-			result[netDevice] = ibDevice
+			ibFields, netFields := strings.Fields(parts[0]), strings.Fields(parts[1])
+			if len(ibFields) == 0 || len(netFields) == 0 {
+				continue
+			}
+			result[netFields[0]] = ibFields[0]
 		}
 	}
 	return result
 
 }
 
-func getSlots() Slots {
-	cmd := exec.Command("dmidecode", "-t", "slot")
-	output, err := cmd.Output()
+// getKernelSlots reads the slots registered by the hotplug drivers from
+// /sys/bus/pci/slots/<name>/address, which holds the address of the device
+// in the slot without the function, eg. "0000:1b:00".
+func getKernelSlots(basePath string) Slots {
+	slotsPath := path.Join(basePath, "bus/pci/slots")
+	entries, err := os.ReadDir(slotsPath)
 	if err != nil {
-		log.Errorf("Error executing dmidecode: %s", err)
-		return Slots{}
+		return nil
 	}
-	return parseSlots(string(output))
-
+	var slots Slots
+	for _, entry := range entries {
+		address, err := SysReadFile(path.Join(slotsPath, entry.Name(), "address"))
+		// Empty slots have no address
+		if err != nil || address == "" {
+			continue
+		}
+		slotNumber := entry.Name()
+		if match := slotNumberPattern.FindString(slotNumber); match != "" {
+			slotNumber = match
+		}
+		slots = append(slots, SlotInfo{
+			Designation: entry.Name(),
+			BusAddress:  address + ".0",
+			SlotNumber:  slotNumber,
+		})
+	}
+	return slots
 }
 
 func parseSlots(output string) Slots {
@@ -752,7 +1203,6 @@ func parseSlots(output string) Slots {
 	var slot SlotInfo
 	designationPattern := regexp.MustCompile(`Designation:\s+(.+)`)
 	busAddressPattern := regexp.MustCompile(`Bus Address:\s+(.+)`)
-	slotNumberPattern := regexp.MustCompile(`\d+`) // Pattern to capture the slot number
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -790,8 +1240,12 @@ func parseSlots(output string) Slots {
 
 // Get a map of device to pci address from /sys/class/{className}/{device}/device links
 func getDevice2PciAddress(className string) map[string]string {
+	return readDevice2PciAddress("/sys", className)
+}
+
+func readDevice2PciAddress(sysPath, className string) map[string]string {
 	result := make(map[string]string)
-	basePath := filepath.Join("/sys/class", className)
+	basePath := filepath.Join(sysPath, "class", className)
 	files, err := os.ReadDir(basePath)
 	if err != nil {
 		log.Errorf("Error listing %s devices: %s\n", className, err)
@@ -889,6 +1343,7 @@ func lookupKey(lookupMap map[string]string, lookupValue string) (string, bool) {
 func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string, device DeviceInfo) PortMetrics {
 	var metrics PortMetrics
 
+	metrics.source = sourceMlxlink
 	metrics.mode = device.mode
 	metrics.caname = device.caName
 	metrics.netdev = device.netDev
@@ -898,45 +1353,65 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 	metrics.slot = slot
 	metrics.port = port
 
+	schema, mftVersion := selectMlxlinkSchema(mlxout)
+	metrics.mlxlinkSchema = schema.name
+	metrics.mftVersion = mftVersion
+
 	valuesRegex := regexp.MustCompile(`([\d\.,\-]+)`)
+	rangeRegex := regexp.MustCompile(`\[(-?\d+(?:\.\d+)?)\.\.(-?\d+(?:\.\d+)?)\]`)
 	speedsRegex := regexp.MustCompile(`Attenuation \((.*)\) \[dB\]`)
 
-	state := mlxout.Get("result.output.Operational Info.State").String()
+	state := schema.get(mlxout, schema.state).String()
+	metrics.stateName = state
 	if stateValue, stateOK := stateValues[state]; stateOK {
 		metrics.state = stateValue
 	}
 
-	physicalState := mlxout.Get("result.output.Operational Info.Physical state").String()
+	physicalState := schema.get(mlxout, schema.physicalState).String()
+	metrics.physicalStateName = physicalState
 	if physicalStateValue, physicalStateOK := physicalStateValues[physicalState]; physicalStateOK {
 		metrics.physicalState = physicalStateValue
 	}
 
-	speed := mlxout.Get("result.output.Operational Info.Speed").String()
-	if speedValue, speedOK := speed2bps[speed]; speedOK {
-		metrics.speed = speedValue
-	}
-
-	formattedWidth := mlxout.Get("result.output.Operational Info.Width").String()
+	formattedWidth := schema.get(mlxout, schema.width).String()
 	width := strings.TrimSuffix(formattedWidth, "x")
 	if widthValue, err := strconv.ParseFloat(width, 64); err == nil {
 		metrics.width = widthValue
 	}
 
-	metrics.serial = mlxout.Get("result.output.Module Info.Vendor Serial Number").String()
+	speed := schema.get(mlxout, schema.speed).String()
+	if linkSpeed, ok := parseLinkSpeed(speed, int(metrics.width)); ok {
+		metrics.speed = linkSpeed.bps
+		metrics.laneSpeed = linkSpeed.laneBps
+	} else {
+		metrics.unrecognizedSpeed = speed
+	}
+
+	metrics.fecMode = schema.get(mlxout, schema.fec).String()
+
+	for _, speed := range schema.get(mlxout, schema.enabledSpeeds).Array() {
+		metrics.enabledSpeeds = append(metrics.enabledSpeeds, speed.String())
+	}
+	for _, speed := range schema.get(mlxout, schema.supportedCableSpeeds).Array() {
+		metrics.supportedCableSpeeds = append(metrics.supportedCableSpeeds, speed.String())
+	}
+
+	metrics.serial = schema.get(mlxout, schema.serial).String()
 	if !utf8.ValidString(metrics.serial) {
 		metrics.serial = "unknown"
 	}
 
-	metrics.vendor = mlxout.Get("result.output.Module Info.Vendor Name").String()
-	metrics.partNumber = mlxout.Get("result.output.Module Info.Vendor Part Number").String()
+	metrics.vendor = schema.get(mlxout, schema.vendor).String()
+	metrics.partNumber = schema.get(mlxout, schema.partNumber).String()
 
-	moduleState := mlxout.Get("result.output.Module Info.Module State").String()
+	moduleState := schema.get(mlxout, schema.moduleState).String()
+	metrics.moduleStateName = moduleState
 	if moduleStateValue, moduleStateOK := moduleStateValues[moduleState]; moduleStateOK {
 		metrics.moduleState = moduleStateValue
 	}
 
 	var cableType string
-	cableTypeValue := mlxout.Get("result.output.Module Info.Cable Type").String()
+	cableTypeValue := schema.get(mlxout, schema.cableType).String()
 	if strings.Contains(cableTypeValue, "Optic") {
 		cableType = "optical"
 	} else if strings.Contains(cableTypeValue, "Copper") || strings.Contains(cableTypeValue, "copper") {
@@ -950,13 +1425,14 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 	metrics.snrHost = []float64{}
 	metrics.fecErrors = []float64{}
 	metrics.biasCurrent = []float64{}
+	metrics.rxLos = []bool{}
 
-	temperature := mlxout.Get("result.output.Module Info.Temperature [C]").String()
+	temperature := schema.get(mlxout, schema.temperature).String()
 	if matches := valuesRegex.FindStringSubmatch(temperature); matches != nil {
 		metrics.temperature, _ = strconv.ParseFloat(matches[1], 64)
 	}
 
-	snrMediaPerLane := mlxout.Get("result.output.Module Info.SNR Media Lanes [dB].values").Array()
+	snrMediaPerLane := schema.get(mlxout, schema.snrMedia).Array()
 	if len(snrMediaPerLane) != 1 || snrMediaPerLane[0].String() != "N/A" {
 		metrics.snrMedia = make([]float64, len(snrMediaPerLane))
 		for i, snrMedia := range snrMediaPerLane {
@@ -964,7 +1440,7 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 		}
 	}
 
-	snrHostPerLane := mlxout.Get("result.output.Module Info.SNR Host Lanes [dB].values").Array()
+	snrHostPerLane := schema.get(mlxout, schema.snrHost).Array()
 	if len(snrHostPerLane) != 1 || snrHostPerLane[0].String() != "N/A" {
 		metrics.snrHost = make([]float64, len(snrHostPerLane))
 		for i, snrHost := range snrHostPerLane {
@@ -972,47 +1448,58 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 		}
 	}
 
+	rxLosPerLane := schema.get(mlxout, schema.rxLos).Array()
+	for _, rxLos := range rxLosPerLane {
+		metrics.rxLos = append(metrics.rxLos, rxLos.String() == "1")
+	}
+
 	if cableType == "optical" {
 		// Parse DataPath state
-		dataPathStatePerLane := mlxout.Get("result.output.Module Info.DataPath state [per lane].values").Array()
+		dataPathStatePerLane := schema.get(mlxout, schema.dataPathState).Array()
 		metrics.dataPathState = make([]float64, len(dataPathStatePerLane))
+		metrics.dataPathStateNames = make([]string, len(dataPathStatePerLane))
 		for i, dataPathState := range dataPathStatePerLane {
 			metrics.dataPathState[i] = dataPathStateValues[dataPathState.String()]
+			metrics.dataPathStateNames[i] = dataPathState.String()
 		}
 		// Parse RX power
-		rxPowerCurrent := mlxout.Get("result.output.Module Info.Rx Power Current [dBm]").String()
+		rxPowerCurrent := schema.get(mlxout, schema.rxPower).String()
 		if matches := valuesRegex.FindStringSubmatch(rxPowerCurrent); matches != nil {
 			metrics.rxPower, _ = parseFloats(matches[1])
 		}
+		metrics.rxPowerRange = parseRange(rangeRegex, rxPowerCurrent)
 		// Parse TX power
-		txPowerCurrent := mlxout.Get("result.output.Module Info.Tx Power Current [dBm]").String()
+		txPowerCurrent := schema.get(mlxout, schema.txPower).String()
 		if matches := valuesRegex.FindStringSubmatch(txPowerCurrent); matches != nil {
 			metrics.txPower, _ = parseFloats(matches[1])
 		}
+		metrics.txPowerRange = parseRange(rangeRegex, txPowerCurrent)
 		// Parse bias current
-		biasCurrent := mlxout.Get("result.output.Module Info.Bias Current [mA]").String()
+		biasCurrent := schema.get(mlxout, schema.biasCurrent).String()
 		if matches := valuesRegex.FindStringSubmatch(biasCurrent); matches != nil {
 			metrics.biasCurrent, _ = parseFloats(matches[1])
 		}
+		metrics.biasCurrentRange = parseRange(rangeRegex, biasCurrent)
 		// Parse voltage
-		voltage := mlxout.Get("result.output.Module Info.Voltage [mV]").String()
+		voltage := schema.get(mlxout, schema.voltage).String()
 		if matches := valuesRegex.FindStringSubmatch(voltage); matches != nil {
 			metrics.voltage, _ = strconv.ParseFloat(matches[1], 64)
 		}
 		// Parse wavelength
-		wavelength := mlxout.Get("result.output.Module Info.Wavelength [nm]").String()
+		wavelength := schema.get(mlxout, schema.wavelength).String()
 		if matches := valuesRegex.FindStringSubmatch(wavelength); matches != nil {
 			metrics.wavelength, _ = strconv.ParseFloat(matches[1], 64)
 		}
 	} else if cableType == "copper" {
 		// Parse attenuation for copper
-		module_keys := mlxout.Get("result.output.Module Info.@keys").Array()
+		moduleInfo := schema.get(mlxout, schema.moduleInfo)
+		module_keys := moduleInfo.Get("@keys").Array()
 		for _, key := range module_keys {
 			if !strings.HasPrefix(key.Str, "Attenuation") {
 				continue
 			}
 			attenuation_key := key.Str
-			attenuation := mlxout.Get("result.output.Module Info." + attenuation_key)
+			attenuation := moduleInfo.Get(attenuation_key)
 			attenuationValues, _ := parseFloats(attenuation.Str)
 			attenuationSpeeds := []string{}
 			if matches := speedsRegex.FindStringSubmatch(attenuation_key); matches != nil {
@@ -1026,24 +1513,30 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 		}
 	}
 
-	metrics.effectiveBer = mlxout.Get("result.output.Physical Counters and BER Info.Effective Physical BER").Float()
-	metrics.effectiveErrors = mlxout.Get("result.output.Physical Counters and BER Info.Effective Physical Errors").Float()
-	metrics.rawBer = mlxout.Get("result.output.Physical Counters and BER Info.Raw Physical BER").Float()
+	metrics.effectiveBer = schema.get(mlxout, schema.effectiveBer).Float()
+	metrics.effectiveErrors = schema.get(mlxout, schema.effectiveErrors).Float()
+	metrics.rawBer = schema.get(mlxout, schema.rawBer).Float()
 
-	rawErrorsPerLane := mlxout.Get("result.output.Physical Counters and BER Info.Raw Physical Errors Per Lane.values").Array()
+	rawErrorsPerLane := schema.get(mlxout, schema.rawErrors).Array()
 	metrics.rawErrors = make([]float64, len(rawErrorsPerLane))
 	for i, rawErrors := range rawErrorsPerLane {
 		metrics.rawErrors[i] = rawErrors.Float()
 	}
 
-	if mlxout.Get("result.output.Histogram of FEC Errors").Exists() {
-		fecErrorBins := mlxout.Get("result.output.Histogram of FEC Errors").Map()
-		numBins := len(fecErrorBins) - 1
+	if schema.get(mlxout, schema.fecHistogram).Exists() {
+		fecErrorBins := schema.get(mlxout, schema.fecHistogram).Map()
+		numBins := max(len(fecErrorBins)-1, 0)
 		metrics.fecErrors = make([]float64, numBins)
 		if numBins > 0 {
 			for i := range numBins {
 				binJson := fecErrorBins["Bin "+strconv.Itoa(i)]
-				binValue, err := strconv.ParseFloat(binJson.Get("values").Array()[1].String(), 64)
+				// values is the bin's range followed by its count
+				values := binJson.Get("values").Array()
+				if len(values) < 2 {
+					metrics.fecErrors[i] = -1
+					continue
+				}
+				binValue, err := strconv.ParseFloat(values[1].String(), 64)
 				if err != nil {
 					metrics.fecErrors[i] = -1
 				} else {
@@ -1054,13 +1547,13 @@ func parseOutput(mlxout gjson.Result, hostname, systemserial, slot, port string,
 	}
 
 	if metrics.mode == "infiniband" {
-		metrics.symbolBer = mlxout.Get("result.output.Physical Counters and BER Info.Symbol BER").Float()
-		metrics.symbolErrors = mlxout.Get("result.output.Physical Counters and BER Info.Symbol Errors").Float()
-		metrics.linkDown = mlxout.Get("result.output.Physical Counters and BER Info.Link Down Counter").Float()
-		metrics.linkRecovery = mlxout.Get("result.output.Physical Counters and BER Info.Link Error Recovery Counter").Float()
+		metrics.symbolBer = schema.get(mlxout, schema.symbolBer).Float()
+		metrics.symbolErrors = schema.get(mlxout, schema.symbolErrors).Float()
+		metrics.linkDown = schema.get(mlxout, schema.linkDown).Float()
+		metrics.linkRecovery = schema.get(mlxout, schema.linkRecovery).Float()
 	}
 
-	metrics.lastClearTime = mlxout.Get("result.output.Physical Counters and BER Info.Time Since Last Clear [Min]").Float() * 60
+	metrics.lastClearTime = schema.get(mlxout, schema.lastClearTime).Float() * 60
 
 	return metrics
 }
@@ -1078,6 +1571,21 @@ func parseFloats(s string) ([]float64, bool) {
 	return values, true
 }
 
+// parseRange extracts the vendor range printed in brackets after a module
+// reading, eg. "-3,-2,-1,0 [-10..4]".
+func parseRange(rangeRegex *regexp.Regexp, s string) *valueRange {
+	matches := rangeRegex.FindStringSubmatch(s)
+	if matches == nil {
+		return nil
+	}
+	low, lowErr := strconv.ParseFloat(matches[1], 64)
+	high, highErr := strconv.ParseFloat(matches[2], 64)
+	if lowErr != nil || highErr != nil {
+		return nil
+	}
+	return &valueRange{low: low, high: high}
+}
+
 func parseSpeeds(s string) []string {
 	return strings.Split(s, ",")
 }
@@ -2,8 +2,12 @@ package collector
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/tidwall/gjson"
 )
@@ -20,42 +24,57 @@ func TestActiveEthernetNoFecHistogram(t *testing.T) {
 	}
 	result := parseOutput(gjson.Parse(testMlxlinkOutput), "hostname", "systemserial", "slot", "1", deviceInfo)
 	expected := PortMetrics{
-		hostname:         "hostname",
-		systemserial:     "systemserial",
-		slot:             "slot",
-		port:             "1",
-		mode:             "ethernet",
-		caname:           "mlx5_0",
-		netdev:           "ib0",
-		state:            3,
-		physicalState:    10,
-		speed:            200000000000,
-		width:            4,
-		serial:           "5C2410312895",
-		vendor:           "Firmus",
-		partNumber:       "QSFP200I-SR4-5M",
-		moduleState:      3,
-		dataPathState:    []float64{4, 4, 4, 4},
-		biasCurrent:      []float64{7.640, 8.100, 7.740, 8.180},
-		temperature:      41,
-		voltage:          3248.7,
-		wavelength:       850,
-		transferDistance: 0.0,
-		rxPower:          []float64{-3, -2, -1, 0},
-		txPower:          []float64{1, 2, 3, 4},
-		snrMedia:         []float64{},
-		snrHost:          []float64{},
-		attenuation:      map[string]float64{},
-		effectiveBer:     15e-255,
-		effectiveErrors:  5,
-		rawBer:           8e-13,
-		symbolBer:        0,
-		symbolErrors:     0,
-		linkDown:         0,
-		linkRecovery:     0,
-		lastClearTime:    6863.7 * 60,
-		rawErrors:        []float64{54126, 3782, 1578, 5277},
-		fecErrors:        []float64{},
+		source:               "mlxlink",
+		hostname:             "hostname",
+		systemserial:         "systemserial",
+		slot:                 "slot",
+		port:                 "1",
+		mode:                 "ethernet",
+		caname:               "mlx5_0",
+		netdev:               "ib0",
+		stateName:            "Active",
+		physicalStateName:    "ETH_AN_FSM_ENABLE",
+		moduleStateName:      "Ready state",
+		fecMode:              "Standard_RS-FEC - (544,514)",
+		mlxlinkSchema:        "mft-4.22",
+		mftVersion:           "mft 4.22.1-307",
+		enabledSpeeds:        []string{"200G_2X", "200G_4X", "100G_1X", "100G_2X", "100G_4X", "50G_1X", "50G_2X", "40G", "25G", "10G", "1G"},
+		supportedCableSpeeds: []string{"200G_4X"},
+		state:                3,
+		physicalState:        10,
+		speed:                200000000000,
+		laneSpeed:            50000000000,
+		width:                4,
+		serial:               "5C2410312895",
+		vendor:               "Firmus",
+		partNumber:           "QSFP200I-SR4-5M",
+		moduleState:          3,
+		rxLos:                []bool{false, false, false, false},
+		dataPathState:        []float64{4, 4, 4, 4},
+		dataPathStateNames:   []string{"DPActivated", "DPActivated", "DPActivated", "DPActivated"},
+		biasCurrent:          []float64{7.640, 8.100, 7.740, 8.180},
+		temperature:          41,
+		voltage:              3248.7,
+		wavelength:           850,
+		transferDistance:     0.0,
+		rxPower:              []float64{-3, -2, -1, 0},
+		rxPowerRange:         &valueRange{-10, 4},
+		txPower:              []float64{1, 2, 3, 4},
+		txPowerRange:         &valueRange{-8, 4},
+		biasCurrentRange:     &valueRange{3, 15},
+		snrMedia:             []float64{},
+		snrHost:              []float64{},
+		attenuation:          map[string]float64{},
+		effectiveBer:         15e-255,
+		effectiveErrors:      5,
+		rawBer:               8e-13,
+		symbolBer:            0,
+		symbolErrors:         0,
+		linkDown:             0,
+		linkRecovery:         0,
+		lastClearTime:        6863.7 * 60,
+		rawErrors:            []float64{54126, 3782, 1578, 5277},
+		fecErrors:            []float64{},
 	}
 	assert.Equal(t, expected, result)
 }
@@ -74,42 +93,57 @@ func TestActiveEthernet(t *testing.T) {
 	result := parseOutput(gjson.Parse(testMlxlinkOutput), "hostname", "systemserial", "slot", "1", deviceInfo)
 
 	expected := PortMetrics{
-		hostname:         "hostname",
-		systemserial:     "systemserial",
-		slot:             "slot",
-		port:             "1",
-		mode:             "ethernet",
-		caname:           "mlx5_0",
-		netdev:           "ib0",
-		state:            3,
-		physicalState:    10,
-		speed:            200000000000,
-		width:            4,
-		serial:           "5C2410312895",
-		vendor:           "Firmus",
-		partNumber:       "QSFP200I-SR4-5M",
-		moduleState:      3,
-		dataPathState:    []float64{4, 4, 4, 4},
-		biasCurrent:      []float64{7.640, 8.100, 7.740, 8.180},
-		temperature:      41,
-		voltage:          3248.7,
-		wavelength:       850,
-		transferDistance: 0.0,
-		rxPower:          []float64{-3, -2, -1, 0},
-		txPower:          []float64{1, 2, 3, 4},
-		snrMedia:         []float64{},
-		snrHost:          []float64{},
-		attenuation:      map[string]float64{},
-		effectiveBer:     15e-255,
-		effectiveErrors:  5,
-		rawBer:           8e-13,
-		symbolBer:        0,
-		symbolErrors:     0,
-		linkDown:         0,
-		linkRecovery:     0,
-		lastClearTime:    6863.7 * 60,
-		rawErrors:        []float64{54126, 3782, 1578, 5277},
-		fecErrors:        []float64{157550930963675, 5638939059, 47990561, 5667737, 1568982, 5217, 2786, 58},
+		source:               "mlxlink",
+		hostname:             "hostname",
+		systemserial:         "systemserial",
+		slot:                 "slot",
+		port:                 "1",
+		mode:                 "ethernet",
+		caname:               "mlx5_0",
+		netdev:               "ib0",
+		stateName:            "Active",
+		physicalStateName:    "ETH_AN_FSM_ENABLE",
+		moduleStateName:      "Ready state",
+		fecMode:              "Standard_RS-FEC - (544,514)",
+		mlxlinkSchema:        "mft-4.22",
+		mftVersion:           "mft 4.22.1-307",
+		enabledSpeeds:        []string{"200G_2X", "200G_4X", "100G_1X", "100G_2X", "100G_4X", "50G_1X", "50G_2X", "40G", "25G", "10G", "1G"},
+		supportedCableSpeeds: []string{"200G_4X"},
+		state:                3,
+		physicalState:        10,
+		speed:                200000000000,
+		laneSpeed:            50000000000,
+		width:                4,
+		serial:               "5C2410312895",
+		vendor:               "Firmus",
+		partNumber:           "QSFP200I-SR4-5M",
+		moduleState:          3,
+		rxLos:                []bool{false, false, false, false},
+		dataPathState:        []float64{4, 4, 4, 4},
+		dataPathStateNames:   []string{"DPActivated", "DPActivated", "DPActivated", "DPActivated"},
+		biasCurrent:          []float64{7.640, 8.100, 7.740, 8.180},
+		temperature:          41,
+		voltage:              3248.7,
+		wavelength:           850,
+		transferDistance:     0.0,
+		rxPower:              []float64{-3, -2, -1, 0},
+		rxPowerRange:         &valueRange{-10, 4},
+		txPower:              []float64{1, 2, 3, 4},
+		txPowerRange:         &valueRange{-8, 4},
+		biasCurrentRange:     &valueRange{3, 15},
+		snrMedia:             []float64{},
+		snrHost:              []float64{},
+		attenuation:          map[string]float64{},
+		effectiveBer:         15e-255,
+		effectiveErrors:      5,
+		rawBer:               8e-13,
+		symbolBer:            0,
+		symbolErrors:         0,
+		linkDown:             0,
+		linkRecovery:         0,
+		lastClearTime:        6863.7 * 60,
+		rawErrors:            []float64{54126, 3782, 1578, 5277},
+		fecErrors:            []float64{157550930963675, 5638939059, 47990561, 5667737, 1568982, 5217, 2786, 58},
 	}
 	assert.Equal(t, expected, result)
 }
@@ -128,42 +162,57 @@ func TestActiveInfiniband(t *testing.T) {
 	result := parseOutput(gjson.Parse(testMlxlinkOutput), "hostname", "systemserial", "slot", "1", deviceInfo)
 
 	expected := PortMetrics{
-		hostname:         "hostname",
-		systemserial:     "systemserial",
-		slot:             "slot",
-		port:             "1",
-		mode:             "infiniband",
-		caname:           "mlx5_0",
-		netdev:           "ib0",
-		state:            3,
-		physicalState:    7,
-		speed:            400000000000,
-		width:            4,
-		serial:           "5C2410312316",
-		vendor:           "Firmus",
-		partNumber:       "OSFP400I-SR4-5M",
-		moduleState:      3,
-		dataPathState:    []float64{4, 4, 4, 4},
-		biasCurrent:      []float64{8.540, 8.600, 8.630, 8.660},
-		temperature:      45,
-		voltage:          3258.9,
-		wavelength:       858,
-		transferDistance: 0.0,
-		rxPower:          []float64{-3, -2, -1, 0},
-		txPower:          []float64{1, 2, 3, 4},
-		snrMedia:         []float64{4, 3, 2, 1},
-		snrHost:          []float64{8, 7, 6, 5},
-		attenuation:      map[string]float64{},
-		effectiveBer:     15e-255,
-		effectiveErrors:  1,
-		rawBer:           2e-10,
-		symbolBer:        15e-255,
-		symbolErrors:     4,
-		linkDown:         2,
-		linkRecovery:     3,
-		lastClearTime:    6750.4 * 60,
-		rawErrors:        []float64{6045465, 14059590, 18460013, 5651086},
-		fecErrors:        []float64{157550930963675, 5638939059, 47990561, 5667737, 1568982, 5217, 2786, 58},
+		source:               "mlxlink",
+		hostname:             "hostname",
+		systemserial:         "systemserial",
+		slot:                 "slot",
+		port:                 "1",
+		mode:                 "infiniband",
+		caname:               "mlx5_0",
+		netdev:               "ib0",
+		stateName:            "Active",
+		physicalStateName:    "LinkUp",
+		moduleStateName:      "Ready state",
+		fecMode:              "Ethernet_Consortium_LL_50G_RS_FEC_PLR -(272,257+1)",
+		mlxlinkSchema:        "mft-4.22",
+		mftVersion:           "mft 4.22.1-307",
+		enabledSpeeds:        []string{"NDR", "HDR", "EDR"},
+		supportedCableSpeeds: []string{"NDR", "HDR", "EDR"},
+		state:                3,
+		physicalState:        7,
+		speed:                400000000000,
+		laneSpeed:            100000000000,
+		width:                4,
+		serial:               "5C2410312316",
+		vendor:               "Firmus",
+		partNumber:           "OSFP400I-SR4-5M",
+		moduleState:          3,
+		rxLos:                []bool{false, false, false, false},
+		dataPathState:        []float64{4, 4, 4, 4},
+		dataPathStateNames:   []string{"DPActivated", "DPActivated", "DPActivated", "DPActivated"},
+		biasCurrent:          []float64{8.540, 8.600, 8.630, 8.660},
+		temperature:          45,
+		voltage:              3258.9,
+		wavelength:           858,
+		transferDistance:     0.0,
+		rxPower:              []float64{-3, -2, -1, 0},
+		rxPowerRange:         &valueRange{-8, 4},
+		txPower:              []float64{1, 2, 3, 4},
+		txPowerRange:         &valueRange{-6, 4},
+		biasCurrentRange:     &valueRange{6.5, 9.5},
+		snrMedia:             []float64{4, 3, 2, 1},
+		snrHost:              []float64{8, 7, 6, 5},
+		attenuation:          map[string]float64{},
+		effectiveBer:         15e-255,
+		effectiveErrors:      1,
+		rawBer:               2e-10,
+		symbolBer:            15e-255,
+		symbolErrors:         4,
+		linkDown:             2,
+		linkRecovery:         3,
+		lastClearTime:        6750.4 * 60,
+		rawErrors:            []float64{6045465, 14059590, 18460013, 5651086},
+		fecErrors:            []float64{157550930963675, 5638939059, 47990561, 5667737, 1568982, 5217, 2786, 58},
 	}
 	assert.Equal(t, expected, result)
 }
@@ -180,42 +229,56 @@ func TestInactiveInfiniband(t *testing.T) {
 	}
 	result := parseOutput(gjson.Parse(testMlxlinkOutput), "hostname", "systemserial", "slot", "1", deviceInfo)
 	expected := PortMetrics{
-		hostname:         "hostname",
-		systemserial:     "systemserial",
-		slot:             "slot",
-		port:             "1",
-		mode:             "infiniband",
-		caname:           "mlx5_0",
-		netdev:           "ib0",
-		state:            2,
-		physicalState:    10,
-		speed:            0,
-		width:            0,
-		serial:           "5C2410311681",
-		vendor:           "Firmus",
-		partNumber:       "OSFP400I-SR4",
-		moduleState:      3,
-		dataPathState:    []float64{4, 4, 4, 4},
-		biasCurrent:      []float64{8.63, 8.66, 8.54, 8.6},
-		temperature:      37,
-		voltage:          3259.4,
-		wavelength:       858,
-		transferDistance: 0.0,
-		rxPower:          []float64{-4.191, -4.377, -4.067, -4.056},
-		txPower:          []float64{1.867, 1.94, 1.861, 1.706},
-		snrMedia:         []float64{},
-		snrHost:          []float64{},
-		attenuation:      map[string]float64{},
-		effectiveBer:     0,
-		effectiveErrors:  0,
-		rawBer:           0,
-		symbolBer:        0,
-		symbolErrors:     0,
-		linkDown:         0,
-		linkRecovery:     0,
-		lastClearTime:    0,
-		rawErrors:        []float64{0},
-		fecErrors:        []float64{},
+		source:               "mlxlink",
+		hostname:             "hostname",
+		systemserial:         "systemserial",
+		slot:                 "slot",
+		port:                 "1",
+		mode:                 "infiniband",
+		caname:               "mlx5_0",
+		netdev:               "ib0",
+		stateName:            "Polling",
+		physicalStateName:    "ETH_AN_FSM_ENABLE",
+		moduleStateName:      "Ready state",
+		fecMode:              "N/A",
+		mlxlinkSchema:        "mft-4.22",
+		mftVersion:           "mft 4.26.1-3",
+		enabledSpeeds:        []string{"NDR"},
+		supportedCableSpeeds: []string{"NDR", "HDR", "EDR"},
+		state:                2,
+		physicalState:        10,
+		speed:                0,
+		width:                0,
+		serial:               "5C2410311681",
+		vendor:               "Firmus",
+		partNumber:           "OSFP400I-SR4",
+		moduleState:          3,
+		rxLos:                []bool{false, false, false, false},
+		dataPathState:        []float64{4, 4, 4, 4},
+		dataPathStateNames:   []string{"DPActivated", "DPActivated", "DPActivated", "DPActivated"},
+		biasCurrent:          []float64{8.63, 8.66, 8.54, 8.6},
+		temperature:          37,
+		voltage:              3259.4,
+		wavelength:           858,
+		transferDistance:     0.0,
+		rxPower:              []float64{-4.191, -4.377, -4.067, -4.056},
+		rxPowerRange:         &valueRange{-7.423, 5},
+		txPower:              []float64{1.867, 1.94, 1.861, 1.706},
+		txPowerRange:         &valueRange{-5.607, 5},
+		biasCurrentRange:     &valueRange{6.5, 9.5},
+		snrMedia:             []float64{},
+		snrHost:              []float64{},
+		attenuation:          map[string]float64{},
+		effectiveBer:         0,
+		effectiveErrors:      0,
+		rawBer:               0,
+		symbolBer:            0,
+		symbolErrors:         0,
+		linkDown:             0,
+		linkRecovery:         0,
+		lastClearTime:        0,
+		rawErrors:            []float64{0},
+		fecErrors:            []float64{},
 	}
 	assert.Equal(t, expected, result)
 }
@@ -389,3 +452,128 @@ func TestGetSlotInfoPort1(t *testing.T) {
 	assert.Equal(t, "40", result)
 
 }
+
+func TestRediscoverEndsWait(t *testing.T) {
+	nm := NewNicModuleCollector("smc_nic_module", NicModuleOptions{})
+	nm.Rediscover()
+	// A second request while one is pending is coalesced
+	nm.Rediscover()
+	start := time.Now()
+	nm.Wait(time.Minute)
+	assert.Less(t, time.Since(start), time.Second)
+
+	start = time.Now()
+	nm.Wait(10 * time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestGetSlotBehindSwitch(t *testing.T) {
+	// A NIC behind an on-card switch, SMBIOS records the upstream port
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:17", "0000:17:01.0", "0x060400", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:18:00.0", "0x060400", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0", "0000:19:00.0", "0x060400", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0/0000:19:00.0", "0000:1b:00.0", "0x020000", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0/0000:19:00.0", "0000:1b:00.1", "0x020000", nil),
+	)
+	defer func(original string) { slotSysPath = original }(slotSysPath)
+	slotSysPath = root
+
+	slots := Slots{SlotInfo{"PCIe Slot 7", "0000:18:00.0", "7"}}
+	assert.Equal(t, "7", slots.getSlot("0000:1b:00.0"))
+	assert.Equal(t, "7", slots.getSlot("0000:1b:00.1"))
+	assert.Equal(t, "7", slots.getSlot("0000:18:00.0"))
+	// The root port is above the slot
+	assert.Equal(t, "", slots.getSlot("0000:17:01.0"))
+
+	// The device's own slot wins over the one of a bridge
+	slots = append(Slots{SlotInfo{"PCIe Slot 40", "0000:1b:00.0", "40"}}, slots...)
+	assert.Equal(t, "40", slots.getSlot("0000:1b:00.1"))
+}
+
+func TestGetKernelSlots(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"bus/pci/slots/38/address":          "0000:1a:00",
+		"bus/pci/slots/40-1/address":        "0000:1b:00",
+		"bus/pci/slots/empty/power":         "0",
+		"bus/pci/slots/ocp/address":         "0000:2b:00",
+		"bus/pci/slots/39/attention_status": "0",
+	})
+	slots := getKernelSlots(root)
+	assert.Equal(t, Slots{
+		SlotInfo{"38", "0000:1a:00.0", "38"},
+		SlotInfo{"40-1", "0000:1b:00.0", "40"},
+		SlotInfo{"ocp", "0000:2b:00.0", "ocp"},
+	}, slots)
+	assert.Equal(t, "40", slots.getSlot("0000:1b:00.1"))
+	assert.Nil(t, getKernelSlots(t.TempDir()))
+}
+
+func TestParseOutputMalformedFecHistogram(t *testing.T) {
+	deviceInfo := DeviceInfo{mode: "ethernet"}
+	result := parseOutput(gjson.Parse(`{"result":{"output":{"Histogram of FEC Errors":{}}}}`), "hostname", "systemserial", "slot", "1", deviceInfo)
+	assert.Empty(t, result.fecErrors)
+
+	// A bin without its count is reported as -1 like one that is not a number
+	result = parseOutput(gjson.Parse(`{"result":{"output":{"Histogram of FEC Errors":{"Bin 0":{"values":["[0]"]},"Bin 1":{"values":["[1]","7"]},"Header":{}}}}}`), "hostname", "systemserial", "slot", "1", deviceInfo)
+	assert.Equal(t, []float64{-1, 7}, result.fecErrors)
+}
+
+// FuzzParseOutput checks that parseOutput and the export of its result do
+// not panic on any mlxlink output.
+func FuzzParseOutput(f *testing.F) {
+	fixtures, _ := filepath.Glob("testdata/mlxlink_*.json")
+	for _, fixture := range fixtures {
+		output, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(output, strings.Contains(fixture, "infiniband"))
+	}
+	f.Add([]byte(`{"result":{"output":{"Histogram of FEC Errors":{}}}}`), false)
+	f.Add([]byte(`{"result":{"output":{"Histogram of FEC Errors":{"Bin 0":{"values":[]},"Bin 1":{}}}}}`), false)
+
+	collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{})
+	f.Fuzz(func(t *testing.T, output []byte, infiniband bool) {
+		device := DeviceInfo{pciAddress: "0000:1a:00.0", mode: "ethernet", caName: "mlx5_0", netDev: "eth0"}
+		if infiniband {
+			device.mode = "infiniband"
+		}
+		port := parseOutput(gjson.ParseBytes(output), "hostname", "systemserial", "38", "1", device)
+
+		ch := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+			}
+			close(done)
+		}()
+		collector.collectPort(ch, port)
+		close(ch)
+		<-done
+	})
+}
+
+// FuzzParseSlots checks that parseSlots does not panic on any dmidecode
+// output and only returns slots with a designation.
+func FuzzParseSlots(f *testing.F) {
+	output, err := os.ReadFile("testdata/dmidecode_output.txt")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(output))
+	f.Add("System Slot Information\n\tDesignation: \n\tBus Address: 0000:1b:00.0\n")
+	f.Fuzz(func(t *testing.T, output string) {
+		for _, slot := range parseSlots(output) {
+			if slot.Designation == "" {
+				t.Errorf("slot without designation: %+v", slot)
+			}
+		}
+	})
+}
+
+func TestPortName(t *testing.T) {
+	assert.Equal(t, "mlx5_0", portName("mlx5_0", "eth0"))
+	assert.Equal(t, "eth0", portName("", "eth0"))
+}
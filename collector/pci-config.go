@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"os"
+	"path"
+	"smc-exporter/collector/pciconfig"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pciConfigDescs are the metrics decoded from the configuration space of
+// PCI devices.
+type pciConfigDescs struct {
+	maxPayloadDesc          *prometheus.Desc
+	maxPayloadSupportedDesc *prometheus.Desc
+	maxReadRequestDesc      *prometheus.Desc
+	relaxedOrderingDesc     *prometheus.Desc
+	deviceErrorDesc         *prometheus.Desc
+	linkTrainingDesc        *prometheus.Desc
+	linkDLLActiveDesc       *prometheus.Desc
+	aspmDesc                *prometheus.Desc
+	acsDesc                 *prometheus.Desc
+	slotHotPlugDesc         *prometheus.Desc
+	slotPowerLimitDesc      *prometheus.Desc
+	slotNumberDesc          *prometheus.Desc
+}
+
+func newPciConfigDescs(namespace string) pciConfigDescs {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, name),
+			help,
+			append(pcideviceLabelNames, labels...),
+			nil,
+		)
+	}
+	return pciConfigDescs{
+		maxPayloadDesc:          desc("pcie_max_payload_bytes", "Max Payload Size set in the Device Control register."),
+		maxPayloadSupportedDesc: desc("pcie_max_payload_supported_bytes", "Max Payload Size the device supports."),
+		maxReadRequestDesc:      desc("pcie_max_read_request_bytes", "Max Read Request Size set in the Device Control register."),
+		relaxedOrderingDesc:     desc("pcie_relaxed_ordering_enabled", "1 if Relaxed Ordering is enabled, 0 otherwise."),
+		deviceErrorDesc:         desc("pcie_device_error_detected", "1 if the Device Status register reports an error of the type, 0 otherwise.", "error"),
+		linkTrainingDesc:        desc("pcie_link_training", "1 if the link is training, 0 otherwise."),
+		linkDLLActiveDesc:       desc("pcie_link_dll_active", "1 if the Data Link Layer of the link is active, 0 otherwise. Only ports reporting it have the series."),
+		aspmDesc:                desc("pcie_aspm_enabled", "1 if the ASPM state is enabled on the link, 0 otherwise.", "state"),
+		acsDesc:                 desc("pcie_acs_enabled", "1 if the ACS control is enabled, 0 otherwise. Only implemented controls have a series.", "control"),
+		slotHotPlugDesc:         desc("pcie_slot_hot_plug_capable", "1 if the slot of the port supports hot plug, 0 otherwise."),
+		slotPowerLimitDesc:      desc("pcie_slot_power_limit_watts", "Power limit of the slot of the port."),
+		slotNumberDesc:          desc("pcie_slot_number", "Physical Slot Number of the slot of the port."),
+	}
+}
+
+func (d pciConfigDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.maxPayloadDesc
+	ch <- d.maxPayloadSupportedDesc
+	ch <- d.maxReadRequestDesc
+	ch <- d.relaxedOrderingDesc
+	ch <- d.deviceErrorDesc
+	ch <- d.linkTrainingDesc
+	ch <- d.linkDLLActiveDesc
+	ch <- d.aspmDesc
+	ch <- d.acsDesc
+	ch <- d.slotHotPlugDesc
+	ch <- d.slotPowerLimitDesc
+	ch <- d.slotNumberDesc
+}
+
+// collect exports the decoded configuration space of the device.
+func (d pciConfigDescs) collect(ch chan<- prometheus.Metric, device PciDevice) {
+	if device.Config == nil {
+		return
+	}
+	labels := device.Location.Strings()
+	gauge := func(desc *prometheus.Desc, value float64, extra ...string) {
+		sendConstMetric(ch, desc, prometheus.GaugeValue, value, append(labels, extra...)...)
+	}
+
+	if pcie := device.Config.PCIe; pcie != nil {
+		gauge(d.maxPayloadDesc, float64(pcie.MaxPayload))
+		gauge(d.maxPayloadSupportedDesc, float64(pcie.MaxPayloadSupported))
+		gauge(d.maxReadRequestDesc, float64(pcie.MaxReadRequest))
+		gauge(d.relaxedOrderingDesc, boolToFloat(pcie.RelaxedOrdering))
+		gauge(d.deviceErrorDesc, boolToFloat(pcie.DeviceStatus.CorrectableError), "correctable")
+		gauge(d.deviceErrorDesc, boolToFloat(pcie.DeviceStatus.NonFatalError), "nonfatal")
+		gauge(d.deviceErrorDesc, boolToFloat(pcie.DeviceStatus.FatalError), "fatal")
+		gauge(d.deviceErrorDesc, boolToFloat(pcie.DeviceStatus.UnsupportedRequest), "unsupported_request")
+		gauge(d.linkTrainingDesc, boolToFloat(pcie.LinkStatus.Training))
+		if pcie.DLLActiveReporting {
+			gauge(d.linkDLLActiveDesc, boolToFloat(pcie.LinkStatus.DLLActive))
+		}
+		gauge(d.aspmDesc, boolToFloat(pcie.ASPM.L0s), "L0s")
+		gauge(d.aspmDesc, boolToFloat(pcie.ASPM.L1), "L1")
+		if slot := pcie.Slot; slot != nil {
+			gauge(d.slotHotPlugDesc, boolToFloat(slot.HotPlugCapable))
+			gauge(d.slotPowerLimitDesc, slot.PowerLimitWatts)
+			gauge(d.slotNumberDesc, float64(slot.PhysicalSlot))
+		}
+	}
+	if acs := device.Config.ACS; acs != nil {
+		for _, control := range pciconfig.ACSControls {
+			if acs.Capabilities&control != 0 {
+				gauge(d.acsDesc, boolToFloat(acs.Enabled(control)), control.String())
+			}
+		}
+	}
+}
+
+// readPciDeviceConfig decodes /sys/bus/pci/devices/<Location>/config. It
+// returns nil when the file cannot be read or decoded, and for devices that
+// are runtime suspended, as reading the config space would resume them.
+func readPciDeviceConfig(devicePath string) *pciconfig.Config {
+	if status, err := SysReadFile(path.Join(devicePath, "power/runtime_status")); err == nil && status != "active" {
+		return nil
+	}
+	content, err := os.ReadFile(path.Join(devicePath, "config"))
+	if err != nil {
+		return nil
+	}
+	config, err := pciconfig.Decode(content)
+	if err != nil {
+		return nil
+	}
+	return config
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
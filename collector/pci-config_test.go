@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"smc-exporter/collector/pciconfig"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPciDeviceConfig(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, readPciDeviceConfig(dir))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config"), make([]byte, 16), 0o644))
+	assert.Nil(t, readPciDeviceConfig(dir))
+
+	header := make([]byte, pciconfig.HeaderSize)
+	header[0], header[1] = 0xb3, 0x15
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config"), header, 0o644))
+	config := readPciDeviceConfig(dir)
+	require.NotNil(t, config)
+	assert.Equal(t, uint16(0x15b3), config.VendorID)
+	assert.Nil(t, config.PCIe)
+
+	// A runtime suspended device is not woken up
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "power"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "power/runtime_status"), []byte("suspended\n"), 0o644))
+	assert.Nil(t, readPciDeviceConfig(dir))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "power/runtime_status"), []byte("active\n"), 0o644))
+	assert.NotNil(t, readPciDeviceConfig(dir))
+}
+
+func TestPCIDeviceCollectConfig(t *testing.T) {
+	device := PciDevice{
+		Location: PciDeviceLocation{Segment: 0, Bus: 0x18, Device: 0, Function: 0},
+		Config: &pciconfig.Config{
+			PCIe: &pciconfig.PCIExpress{
+				PortType:            pciconfig.PortTypeDownstreamPort,
+				MaxPayloadSupported: 512,
+				MaxPayload:          128,
+				MaxReadRequest:      512,
+				RelaxedOrdering:     true,
+				DeviceStatus:        pciconfig.DeviceStatus{CorrectableError: true},
+				DLLActiveReporting:  true,
+				ASPM:                pciconfig.ASPM{L1: true},
+				LinkStatus:          pciconfig.LinkStatus{Speed: 32, Width: 16, DLLActive: true},
+				Slot:                &pciconfig.SlotCapabilities{HotPlugCapable: true, PowerLimitWatts: 75, PhysicalSlot: 7},
+			},
+			ACS: &pciconfig.ACS{
+				Capabilities: pciconfig.ACSSourceValidation | pciconfig.ACSP2PRequestRedirect,
+				Control:      pciconfig.ACSP2PRequestRedirect,
+			},
+		},
+	}
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.configDescs.collect(ch, device)
+	})
+	expected := `
+# HELP smc_pcidevice_pcie_acs_enabled 1 if the ACS control is enabled, 0 otherwise. Only implemented controls have a series.
+# TYPE smc_pcidevice_pcie_acs_enabled gauge
+smc_pcidevice_pcie_acs_enabled{bus="18",control="p2p_request_redirect",device="00",function="0",segment="0000"} 1
+smc_pcidevice_pcie_acs_enabled{bus="18",control="source_validation",device="00",function="0",segment="0000"} 0
+# HELP smc_pcidevice_pcie_aspm_enabled 1 if the ASPM state is enabled on the link, 0 otherwise.
+# TYPE smc_pcidevice_pcie_aspm_enabled gauge
+smc_pcidevice_pcie_aspm_enabled{bus="18",device="00",function="0",segment="0000",state="L0s"} 0
+smc_pcidevice_pcie_aspm_enabled{bus="18",device="00",function="0",segment="0000",state="L1"} 1
+# HELP smc_pcidevice_pcie_device_error_detected 1 if the Device Status register reports an error of the type, 0 otherwise.
+# TYPE smc_pcidevice_pcie_device_error_detected gauge
+smc_pcidevice_pcie_device_error_detected{bus="18",device="00",error="correctable",function="0",segment="0000"} 1
+smc_pcidevice_pcie_device_error_detected{bus="18",device="00",error="fatal",function="0",segment="0000"} 0
+smc_pcidevice_pcie_device_error_detected{bus="18",device="00",error="nonfatal",function="0",segment="0000"} 0
+smc_pcidevice_pcie_device_error_detected{bus="18",device="00",error="unsupported_request",function="0",segment="0000"} 0
+# HELP smc_pcidevice_pcie_link_dll_active 1 if the Data Link Layer of the link is active, 0 otherwise. Only ports reporting it have the series.
+# TYPE smc_pcidevice_pcie_link_dll_active gauge
+smc_pcidevice_pcie_link_dll_active{bus="18",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_pcie_link_training 1 if the link is training, 0 otherwise.
+# TYPE smc_pcidevice_pcie_link_training gauge
+smc_pcidevice_pcie_link_training{bus="18",device="00",function="0",segment="0000"} 0
+# HELP smc_pcidevice_pcie_max_payload_bytes Max Payload Size set in the Device Control register.
+# TYPE smc_pcidevice_pcie_max_payload_bytes gauge
+smc_pcidevice_pcie_max_payload_bytes{bus="18",device="00",function="0",segment="0000"} 128
+# HELP smc_pcidevice_pcie_max_payload_supported_bytes Max Payload Size the device supports.
+# TYPE smc_pcidevice_pcie_max_payload_supported_bytes gauge
+smc_pcidevice_pcie_max_payload_supported_bytes{bus="18",device="00",function="0",segment="0000"} 512
+# HELP smc_pcidevice_pcie_max_read_request_bytes Max Read Request Size set in the Device Control register.
+# TYPE smc_pcidevice_pcie_max_read_request_bytes gauge
+smc_pcidevice_pcie_max_read_request_bytes{bus="18",device="00",function="0",segment="0000"} 512
+# HELP smc_pcidevice_pcie_relaxed_ordering_enabled 1 if Relaxed Ordering is enabled, 0 otherwise.
+# TYPE smc_pcidevice_pcie_relaxed_ordering_enabled gauge
+smc_pcidevice_pcie_relaxed_ordering_enabled{bus="18",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_pcie_slot_hot_plug_capable 1 if the slot of the port supports hot plug, 0 otherwise.
+# TYPE smc_pcidevice_pcie_slot_hot_plug_capable gauge
+smc_pcidevice_pcie_slot_hot_plug_capable{bus="18",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_pcie_slot_number Physical Slot Number of the slot of the port.
+# TYPE smc_pcidevice_pcie_slot_number gauge
+smc_pcidevice_pcie_slot_number{bus="18",device="00",function="0",segment="0000"} 7
+# HELP smc_pcidevice_pcie_slot_power_limit_watts Power limit of the slot of the port.
+# TYPE smc_pcidevice_pcie_slot_power_limit_watts gauge
+smc_pcidevice_pcie_slot_power_limit_watts{bus="18",device="00",function="0",segment="0000"} 75
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path"
+	"slices"
+	"smc-exporter/collector/pciconfig"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,28 +26,135 @@ const (
 )
 
 var (
-	pciIdsPaths = []string{
-		"/usr/share/misc/pci.ids",
-		"/usr/share/hwdata/pci.ids",
-	}
-
 	pcideviceLabelNames = []string{"segment", "bus", "device", "function"}
 )
 
 // PCIDeviceCollector implements prometheus.Collector
 type PCIDeviceCollector struct {
-	pciDeviceInfoDesc *prometheus.Desc
-	pciVendors        map[string]string
-	pciDevices        map[string]map[string]string
-	pciSubsystems     map[string]map[string]string
-	pciClasses        map[string]string
-	pciSubclasses     map[string]string
-	pciProgIfs        map[string]string
+	pciDeviceInfoDesc    *prometheus.Desc
+	maxLinkSpeedDesc     *prometheus.Desc
+	maxLinkWidthDesc     *prometheus.Desc
+	currentLinkSpeedDesc *prometheus.Desc
+	currentLinkWidthDesc *prometheus.Desc
+	linkDowntrainedDesc  *prometheus.Desc
+	aerErrorsDesc        *prometheus.Desc
+	aerTotalErrorsDesc   *prometheus.Desc
+	aerRootPortDesc      *prometheus.Desc
+	attributesInfoDesc   *prometheus.Desc
+	numaNodeDesc         *prometheus.Desc
+	enabledDesc          *prometheus.Desc
+	irqDesc              *prometheus.Desc
+	msiVectorsDesc       *prometheus.Desc
+	sriovTotalVFsDesc    *prometheus.Desc
+	sriovNumVFsDesc      *prometheus.Desc
+	sriovAutoprobeDesc   *prometheus.Desc
+	sriovVFsDesc         *prometheus.Desc
+	sriovVFInfoDesc      *prometheus.Desc
+	gpuNicAffinityDesc   *prometheus.Desc
+	options              PCIDeviceOptions
+	pciIdsInfoDesc       *prometheus.Desc
+	pciIds               atomic.Pointer[pciIds]
+	pciIdsSource         pciIdsSource
+	configDescs          pciConfigDescs
+	inventoryMu          sync.Mutex
+	inventory            *pciInventory
+}
+
+// PCIDeviceOptions holds the optional behaviour of a PCIDeviceCollector.
+type PCIDeviceOptions struct {
+	// Attributes exports the NUMA node, local CPUs, driver, IOMMU group,
+	// power state, enable, IRQ and MSI vector count of every device. They
+	// add a series per device and a label per value, so they are opt-in.
+	Attributes bool
+	// ExcludeVFs drops every series of SR-IOV virtual functions. The
+	// physical functions still report how many VFs they have.
+	ExcludeVFs bool
+	// PCIIdsPath is the pci.ids file used for names. When empty the usual
+	// system paths are tried. The embedded copy is used when no file is
+	// readable.
+	PCIIdsPath string
+	// Filter selects the devices that are exported.
+	Filter PCIDeviceFilter
+	// ResyncInterval is how long the device inventory is cached. It is
+	// also read again when Invalidate is called. Zero reads it on every
+	// scrape.
+	ResyncInterval time.Duration
+}
+
+// PCIDeviceFilter selects PCI devices by class, vendor and address. A device
+// is exported when it matches every non-empty include list and no exclude
+// list.
+type PCIDeviceFilter struct {
+	// Classes are prefixes of the class ID, eg. "0x02" for network
+	// controllers or "0x0302" for 3D controllers.
+	IncludeClasses []string
+	ExcludeClasses []string
+	// Vendors are vendor IDs, eg. "0x15b3".
+	IncludeVendors []string
+	ExcludeVendors []string
+	// Addresses are path.Match patterns of the device address, eg.
+	// "0000:1b:00.*".
+	IncludeAddresses []string
+	ExcludeAddresses []string
+}
+
+// Validate checks the address patterns.
+func (f PCIDeviceFilter) Validate() error {
+	for _, pattern := range slices.Concat(f.IncludeAddresses, f.ExcludeAddresses) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid PCI address pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the device passes the filter.
+func (f PCIDeviceFilter) Match(device PciDevice) bool {
+	classID := fmt.Sprintf("0x%06x", device.Class)
+	vendorID := fmt.Sprintf("0x%04x", device.Vendor)
+	address := device.Location.Address()
+
+	matchClass := func(prefix string) bool {
+		return strings.HasPrefix(classID, normalizePciID(prefix))
+	}
+	matchVendor := func(vendor string) bool {
+		return vendorID == normalizePciID(vendor)
+	}
+	matchAddress := func(pattern string) bool {
+		matched, _ := path.Match(pattern, address)
+		return matched
+	}
+
+	if len(f.IncludeClasses) > 0 && !slices.ContainsFunc(f.IncludeClasses, matchClass) {
+		return false
+	}
+	if len(f.IncludeVendors) > 0 && !slices.ContainsFunc(f.IncludeVendors, matchVendor) {
+		return false
+	}
+	if len(f.IncludeAddresses) > 0 && !slices.ContainsFunc(f.IncludeAddresses, matchAddress) {
+		return false
+	}
+	return !slices.ContainsFunc(f.ExcludeClasses, matchClass) &&
+		!slices.ContainsFunc(f.ExcludeVendors, matchVendor) &&
+		!slices.ContainsFunc(f.ExcludeAddresses, matchAddress)
+}
+
+// normalizePciID lowercases an ID and adds the 0x prefix if it is missing.
+func normalizePciID(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !strings.HasPrefix(id, "0x") {
+		id = "0x" + id
+	}
+	return id
 }
 
 // NewPCIDeviceExporter creates a new exporter with metric descriptions
-func NewPCIDeviceCollector(namespace string) (*PCIDeviceCollector, error) {
-	c := &PCIDeviceCollector{}
+func NewPCIDeviceCollector(namespace string, options PCIDeviceOptions) (*PCIDeviceCollector, error) {
+	if err := options.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	c := &PCIDeviceCollector{options: options}
+	c.pciIdsSource.path = options.PCIIdsPath
 
 	// Build label names based on whether name resolution is enabled
 	labelNames := append(pcideviceLabelNames,
@@ -55,8 +168,130 @@ func NewPCIDeviceCollector(namespace string) (*PCIDeviceCollector, error) {
 		labelNames,
 		nil,
 	)
+	c.maxLinkSpeedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "max_link_transfers_per_second"),
+		"Value of maximum link's transfers per second (T/s)",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.maxLinkWidthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "max_link_width"),
+		"Value of maximum link's width (number of lanes)",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.currentLinkSpeedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "current_link_transfers_per_second"),
+		"Value of current link's transfers per second (T/s)",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.currentLinkWidthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "current_link_width"),
+		"Value of current link's width (number of lanes)",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.linkDowntrainedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "link_downtrained"),
+		"1 if the link trained below its maximum speed or width, 0 otherwise. GPUs lower their link speed while idle.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.aerErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "aer_errors_total"),
+		"AER errors reported by the device, from /sys/bus/pci/devices/<location>/aer_dev_<severity>.",
+		append(pcideviceLabelNames, "severity", "type"),
+		nil,
+	)
+	c.aerTotalErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "aer_device_errors_total"),
+		"AER error messages reported by the device.",
+		append(pcideviceLabelNames, "severity"),
+		nil,
+	)
+	c.aerRootPortDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "aer_rootport_errors_total"),
+		"AER error messages received by the root port from the devices below it.",
+		append(pcideviceLabelNames, "severity"),
+		nil,
+	)
+	c.attributesInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "attributes_info"),
+		"Driver, IOMMU group, power state and local CPUs of the device, value is always 1.",
+		append(pcideviceLabelNames, "driver", "iommu_group", "power_state", "local_cpulist"),
+		nil,
+	)
+	c.numaNodeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "numa_node"),
+		"NUMA node the device is attached to, -1 if unknown.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.enabledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "enabled"),
+		"1 if the device is enabled, 0 otherwise.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.irqDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "irq"),
+		"Legacy interrupt line of the device, 0 if none.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.msiVectorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "msi_vectors"),
+		"Number of MSI/MSI-X vectors allocated to the device.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.sriovTotalVFsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_total_vfs"),
+		"Maximum number of SR-IOV virtual functions the physical function supports.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.sriovNumVFsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_num_vfs"),
+		"Number of SR-IOV virtual functions enabled on the physical function.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.sriovAutoprobeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_drivers_autoprobe"),
+		"1 if drivers are probed for new virtual functions, 0 otherwise.",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.sriovVFsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_vfs"),
+		"Number of virtual functions linked to the physical function (virtfn*).",
+		pcideviceLabelNames,
+		nil,
+	)
+	c.sriovVFInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_vf_info"),
+		"Physical function of an SR-IOV virtual function (physfn), value is always 1.",
+		append(pcideviceLabelNames, "pf_segment", "pf_bus", "pf_device", "pf_function"),
+		nil,
+	)
+	c.gpuNicAffinityDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "gpu_nic_affinity"),
+		"PCIe path between a GPU and a NIC: switch, root_complex, numa_node or cross_socket, value is always 1.",
+		[]string{"gpu_segment", "gpu_bus", "gpu_device", "gpu_function", "nic_segment", "nic_bus", "nic_device", "nic_function", "path"},
+		nil,
+	)
+	c.pciIdsInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, pcideviceSubsystem, "ids_database_info"),
+		"pci.ids database used for the names, source is its path or embedded, value is always 1.",
+		[]string{"source", "version", "date"},
+		nil,
+	)
 
-	c.loadPCIIds()
+	c.configDescs = newPciConfigDescs(namespace)
+
+	c.reloadPCIIds()
 
 	return c, nil
 }
@@ -64,57 +299,267 @@ func NewPCIDeviceCollector(namespace string) (*PCIDeviceCollector, error) {
 // Describe sends the metric descriptions to Prometheus
 func (e *PCIDeviceCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.pciDeviceInfoDesc
+	ch <- e.maxLinkSpeedDesc
+	ch <- e.maxLinkWidthDesc
+	ch <- e.currentLinkSpeedDesc
+	ch <- e.currentLinkWidthDesc
+	ch <- e.linkDowntrainedDesc
+	ch <- e.aerErrorsDesc
+	ch <- e.aerTotalErrorsDesc
+	ch <- e.aerRootPortDesc
+	ch <- e.sriovTotalVFsDesc
+	ch <- e.sriovNumVFsDesc
+	ch <- e.sriovAutoprobeDesc
+	ch <- e.sriovVFsDesc
+	ch <- e.sriovVFInfoDesc
+	ch <- e.gpuNicAffinityDesc
+	ch <- e.pciIdsInfoDesc
+	e.configDescs.describe(ch)
+	if e.options.Attributes {
+		ch <- e.attributesInfoDesc
+		ch <- e.numaNodeDesc
+		ch <- e.enabledDesc
+		ch <- e.irqDesc
+		ch <- e.msiVectorsDesc
+	}
 }
 
 // Collect runs on every /metrics scrape
 func (e *PCIDeviceCollector) Collect(ch chan<- prometheus.Metric) {
+	defer recoverCollect("pcidevice", "")
 	start := time.Now()
 
+	e.reloadPCIIds()
+	ids := e.pciIds.Load()
+	sendConstMetric(ch, e.pciIdsInfoDesc, prometheus.GaugeValue, 1.0, ids.source, ids.version, ids.date)
+
 	// Collect device info
 
-	devices, err := GetPciDevices("/sys")
+	inventory, err := e.getInventory("/sys")
 	if err != nil {
 		log.Errorf("Error reading device information: %s", err)
+		return
 	}
 
+	for _, device := range inventory.devices {
+		collectDevice("pcidevice", device.Location.Address(), func() {
+			// The inventory is cached, the link, AER and power state are not
+			devicePath := path.Join("/sys", pciDevicesPath, device.Location.Address())
+			if err := parsePciDeviceState(devicePath, &device); err != nil {
+				log.Errorf("Error reading state of PCI device %s: %s", device.Location.Address(), err)
+			}
+
+			// Send the metrics
+			sendConstMetric(ch, e.pciDeviceInfoDesc, prometheus.GaugeValue, 1.0, inventory.infoValues[device.Name()]...)
+			e.collectLink(ch, device)
+			e.collectAER(ch, device)
+			e.collectSriov(ch, device)
+			e.configDescs.collect(ch, device)
+			if e.options.Attributes {
+				e.collectAttributes(ch, device)
+			}
+		})
+	}
+	e.collectAffinity(ch, inventory.devices)
+
 	duration := time.Since(start).Seconds()
+	log.Printf("Scraped metrics: latency=%.3fs", duration)
+}
+
+// pciInventory is the cached list of exported devices with the label values
+// of their info series.
+type pciInventory struct {
+	devices    PciDevices
+	infoValues map[string][]string
+	ids        *pciIds
+	built      time.Time
+}
 
-	for _, device := range devices {
-		// The device location is represented in separated format.
-		values := device.Location.Strings()
-		if device.ParentLocation != nil {
-			values = append(values, device.ParentLocation.Strings()...)
-		} else {
-			values = append(values, []string{"*", "*", "*", "*"}...)
+// Invalidate makes the next scrape read the PCI inventory again. It is
+// called when a uevent reports a PCI device added, removed, bound or
+// unbound.
+func (e *PCIDeviceCollector) Invalidate() {
+	e.inventoryMu.Lock()
+	defer e.inventoryMu.Unlock()
+	e.inventory = nil
+}
+
+// getInventory returns the cached inventory, reading it again when it was
+// invalidated, is older than the resync interval or the pci.ids database
+// changed.
+func (e *PCIDeviceCollector) getInventory(basePath string) (*pciInventory, error) {
+	ids := e.pciIds.Load()
+
+	e.inventoryMu.Lock()
+	defer e.inventoryMu.Unlock()
+	if inventory := e.inventory; inventory != nil && inventory.ids == ids && time.Since(inventory.built) < e.options.ResyncInterval {
+		return inventory, nil
+	}
+
+	devices, err := GetPciDevices(basePath)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &pciInventory{
+		devices:    make(PciDevices, len(devices)),
+		infoValues: make(map[string][]string, len(devices)),
+		ids:        ids,
+		built:      time.Now(),
+	}
+	for name, device := range devices {
+		if e.options.ExcludeVFs && device.IsVirtualFunction() {
+			continue
+		}
+		if !e.options.Filter.Match(device) {
+			continue
 		}
+		inventory.devices[name] = device
+		inventory.infoValues[name] = e.pciDeviceInfoValues(device)
+	}
+	e.inventory = inventory
+	return inventory, nil
+}
 
-		// Add basic device information
-		classID := fmt.Sprintf("0x%06x", device.Class)
-		vendorID := fmt.Sprintf("0x%04x", device.Vendor)
-		deviceID := fmt.Sprintf("0x%04x", device.Device)
-		subsysVendorID := fmt.Sprintf("0x%04x", device.SubsystemVendor)
-		subsysDeviceID := fmt.Sprintf("0x%04x", device.SubsystemDevice)
+// pciDeviceInfoValues returns the label values of the info series of a
+// device.
+func (e *PCIDeviceCollector) pciDeviceInfoValues(device PciDevice) []string {
+	// The device location is represented in separated format.
+	values := device.Location.Strings()
+	if device.ParentLocation != nil {
+		values = append(values, device.ParentLocation.Strings()...)
+	} else {
+		values = append(values, []string{"*", "*", "*", "*"}...)
+	}
+
+	// Add basic device information
+	classID := fmt.Sprintf("0x%06x", device.Class)
+	vendorID := fmt.Sprintf("0x%04x", device.Vendor)
+	deviceID := fmt.Sprintf("0x%04x", device.Device)
+	subsysVendorID := fmt.Sprintf("0x%04x", device.SubsystemVendor)
+	subsysDeviceID := fmt.Sprintf("0x%04x", device.SubsystemDevice)
 
-		values = append(values, classID, vendorID, deviceID, subsysVendorID, subsysDeviceID, fmt.Sprintf("0x%02x", device.Revision))
+	values = append(values, classID, vendorID, deviceID, subsysVendorID, subsysDeviceID, fmt.Sprintf("0x%02x", device.Revision))
 
-		// Add name values if name resolution is enabled
-		vendorName := e.getPCIVendorName(vendorID)
-		deviceName := e.getPCIDeviceName(vendorID, deviceID)
-		subsysVendorName := e.getPCIVendorName(subsysVendorID)
-		subsysDeviceName := e.getPCISubsystemName(vendorID, deviceID, subsysVendorID, subsysDeviceID)
-		className := e.getPCIClassName(classID)
+	// Add name values if name resolution is enabled
+	vendorName := e.getPCIVendorName(vendorID)
+	deviceName := e.getPCIDeviceName(vendorID, deviceID)
+	subsysVendorName := e.getPCIVendorName(subsysVendorID)
+	subsysDeviceName := e.getPCISubsystemName(vendorID, deviceID, subsysVendorID, subsysDeviceID)
+	className := e.getPCIClassName(classID)
 
-		values = append(values, vendorName, deviceName, subsysVendorName, subsysDeviceName, className)
+	return append(values, vendorName, deviceName, subsysVendorName, subsysDeviceName, className)
+}
 
-		// Send the metrics
-		ch <- prometheus.MustNewConstMetric(e.pciDeviceInfoDesc, prometheus.GaugeValue, 1.0, values...)
+// collectLink exports the PCIe link attributes the device has. Devices
+// that are not PCIe, such as host bridges, have none.
+func (e *PCIDeviceCollector) collectLink(ch chan<- prometheus.Metric, device PciDevice) {
+	labels := device.Location.Strings()
+	if device.MaxLinkSpeed != nil {
+		sendConstMetric(ch, e.maxLinkSpeedDesc, prometheus.GaugeValue, *device.MaxLinkSpeed*1e9, labels...)
+	}
+	if device.MaxLinkWidth != nil {
+		sendConstMetric(ch, e.maxLinkWidthDesc, prometheus.GaugeValue, *device.MaxLinkWidth, labels...)
 	}
+	if device.CurrentLinkSpeed != nil {
+		sendConstMetric(ch, e.currentLinkSpeedDesc, prometheus.GaugeValue, *device.CurrentLinkSpeed*1e9, labels...)
+	}
+	if device.CurrentLinkWidth != nil {
+		sendConstMetric(ch, e.currentLinkWidthDesc, prometheus.GaugeValue, *device.CurrentLinkWidth, labels...)
+	}
+	if downtrained, ok := device.LinkDowntrained(); ok {
+		value := 0.0
+		if downtrained {
+			value = 1
+		}
+		sendConstMetric(ch, e.linkDowntrainedDesc, prometheus.GaugeValue, value, labels...)
+	}
+}
 
-	log.Printf("Scraped metrics: latency=%.3fs", duration)
+// collectAER exports the AER counters of devices with AER reporting.
+func (e *PCIDeviceCollector) collectAER(ch chan<- prometheus.Metric, device PciDevice) {
+	if device.AER == nil {
+		return
+	}
+	labels := device.Location.Strings()
+	for _, severity := range aerSeverities {
+		counters := device.AER.Device[severity]
+		for errorType, value := range counters {
+			if errorType == aerTotalPrefix+aerTotalSuffixes[severity] {
+				sendConstMetric(ch, e.aerTotalErrorsDesc, prometheus.CounterValue, float64(value), append(labels, severity)...)
+				continue
+			}
+			sendConstMetric(ch, e.aerErrorsDesc, prometheus.CounterValue, float64(value), append(labels, severity, errorType)...)
+		}
+		if value, ok := device.AER.RootPort[severity]; ok {
+			sendConstMetric(ch, e.aerRootPortDesc, prometheus.CounterValue, float64(value), append(labels, severity)...)
+		}
+	}
+}
+
+// collectSriov exports the SR-IOV capabilities of physical functions and
+// the physical function of virtual functions.
+func (e *PCIDeviceCollector) collectSriov(ch chan<- prometheus.Metric, device PciDevice) {
+	labels := device.Location.Strings()
+	if device.PhysFn != nil {
+		sendConstMetric(ch, e.sriovVFInfoDesc, prometheus.GaugeValue, 1.0, append(labels, device.PhysFn.Strings()...)...)
+	}
+	if device.SriovTotalVFs == nil {
+		return
+	}
+	sendConstMetric(ch, e.sriovTotalVFsDesc, prometheus.GaugeValue, float64(*device.SriovTotalVFs), labels...)
+	if device.SriovNumVFs != nil {
+		sendConstMetric(ch, e.sriovNumVFsDesc, prometheus.GaugeValue, float64(*device.SriovNumVFs), labels...)
+	}
+	if device.SriovDriversAutoprobe != nil {
+		value := 0.0
+		if *device.SriovDriversAutoprobe {
+			value = 1
+		}
+		sendConstMetric(ch, e.sriovAutoprobeDesc, prometheus.GaugeValue, value, labels...)
+	}
+	sendConstMetric(ch, e.sriovVFsDesc, prometheus.GaugeValue, float64(len(device.VirtFns)), labels...)
+}
+
+// collectAttributes exports the NUMA, driver, IOMMU, power and interrupt
+// attributes of the device.
+func (e *PCIDeviceCollector) collectAttributes(ch chan<- prometheus.Metric, device PciDevice) {
+	labels := device.Location.Strings()
+	iommuGroup := ""
+	if device.IommuGroup != nil {
+		iommuGroup = strconv.Itoa(*device.IommuGroup)
+	}
+	sendConstMetric(ch, e.attributesInfoDesc, prometheus.GaugeValue, 1.0,
+		append(labels, device.Driver, iommuGroup, device.PowerState, device.LocalCPUList)...)
+	if device.NumaNode != nil {
+		sendConstMetric(ch, e.numaNodeDesc, prometheus.GaugeValue, float64(*device.NumaNode), labels...)
+	}
+	if device.Enabled != nil {
+		value := 0.0
+		if *device.Enabled {
+			value = 1
+		}
+		sendConstMetric(ch, e.enabledDesc, prometheus.GaugeValue, value, labels...)
+	}
+	if device.IRQ != nil {
+		sendConstMetric(ch, e.irqDesc, prometheus.GaugeValue, float64(*device.IRQ), labels...)
+	}
+	sendConstMetric(ch, e.msiVectorsDesc, prometheus.GaugeValue, float64(device.MSIVectors), labels...)
 }
 
 const pciDevicesPath = "bus/pci/devices"
 
+// AER severities, as used in the names of the aer_dev_* files
+var aerSeverities = []string{"correctable", "nonfatal", "fatal"}
+
+// The last line of an aer_dev_* file is the total, eg. TOTAL_ERR_COR
+const aerTotalPrefix = "TOTAL_ERR_"
+
+var aerTotalSuffixes = map[string]string{
+	"correctable": "COR",
+	"nonfatal":    "NONFATAL",
+	"fatal":       "FATAL",
+}
+
 // PciDeviceLocation represents the location of the device attached.
 // "0000:00:00.0" represents Segment:Bus:Device.Function .
 type PciDeviceLocation struct {
@@ -128,6 +573,12 @@ func (pdl PciDeviceLocation) String() string {
 	return fmt.Sprintf("%04x:%02x:%02x:%x", pdl.Segment, pdl.Bus, pdl.Device, pdl.Function)
 }
 
+// Address returns the location in the form used by sysfs and lspci,
+// "0000:00:00.0".
+func (pdl PciDeviceLocation) Address() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", pdl.Segment, pdl.Bus, pdl.Device, pdl.Function)
+}
+
 func (pdl PciDeviceLocation) Strings() []string {
 	return []string{
 		fmt.Sprintf("%04x", pdl.Segment),
@@ -149,12 +600,68 @@ type PciDevice struct {
 	SubsystemVendor uint32 // /sys/bus/pci/devices/<Location>/subsystem_vendor
 	SubsystemDevice uint32 // /sys/bus/pci/devices/<Location>/subsystem_device
 	Revision        uint32 // /sys/bus/pci/devices/<Location>/revision
+
+	MaxLinkSpeed     *float64 // /sys/bus/pci/devices/<Location>/max_link_speed, GT/s
+	MaxLinkWidth     *float64 // /sys/bus/pci/devices/<Location>/max_link_width
+	CurrentLinkSpeed *float64 // /sys/bus/pci/devices/<Location>/current_link_speed, GT/s
+	CurrentLinkWidth *float64 // /sys/bus/pci/devices/<Location>/current_link_width
+
+	AER *PciDeviceAER // nil when the kernel does not report AER for the device
+
+	NumaNode     *int   // /sys/bus/pci/devices/<Location>/numa_node, -1 on single node systems
+	LocalCPUList string // /sys/bus/pci/devices/<Location>/local_cpulist
+	Driver       string // /sys/bus/pci/devices/<Location>/driver, empty when unbound
+	IommuGroup   *int   // /sys/bus/pci/devices/<Location>/iommu_group, nil without an IOMMU
+	PowerState   string // /sys/bus/pci/devices/<Location>/power_state, eg. D0 or D3hot
+	Enabled      *bool  // /sys/bus/pci/devices/<Location>/enable
+	IRQ          *int   // /sys/bus/pci/devices/<Location>/irq
+	MSIVectors   int    // number of entries in /sys/bus/pci/devices/<Location>/msi_irqs
+
+	SriovTotalVFs         *int                // /sys/bus/pci/devices/<Location>/sriov_totalvfs, nil if not a PF
+	SriovNumVFs           *int                // /sys/bus/pci/devices/<Location>/sriov_numvfs
+	SriovDriversAutoprobe *bool               // /sys/bus/pci/devices/<Location>/sriov_drivers_autoprobe
+	PhysFn                *PciDeviceLocation  // /sys/bus/pci/devices/<Location>/physfn, nil if not a VF
+	VirtFns               []PciDeviceLocation // /sys/bus/pci/devices/<Location>/virtfn*, ordered by VF index
+
+	RootComplex string              // host bridge the device is below, eg. "pci0000:17"
+	Ancestors   []PciDeviceLocation // upstream bridges, from the parent up to the root port
+
+	Config *pciconfig.Config // /sys/bus/pci/devices/<Location>/config, nil if unreadable
+}
+
+// PciDeviceAER holds the AER counters of a device, keyed by severity
+// (correctable, nonfatal or fatal).
+type PciDeviceAER struct {
+	// Device holds /sys/bus/pci/devices/<Location>/aer_dev_<severity>,
+	// keyed by error type.
+	Device map[string]map[string]uint64
+	// RootPort holds /sys/bus/pci/devices/<Location>/aer_rootport_total_err_*
+	// and is only set for root ports.
+	RootPort map[string]uint64
 }
 
 func (pd PciDevice) Name() string {
 	return pd.Location.String()
 }
 
+// IsVirtualFunction reports whether the device is an SR-IOV virtual function.
+func (pd PciDevice) IsVirtualFunction() bool {
+	return pd.PhysFn != nil
+}
+
+// LinkDowntrained reports whether the link trained below the maximum speed
+// or width of the device. ok is false when the device has no PCIe link
+// attributes or the link is down.
+func (pd PciDevice) LinkDowntrained() (downtrained bool, ok bool) {
+	if pd.MaxLinkSpeed == nil || pd.MaxLinkWidth == nil || pd.CurrentLinkSpeed == nil || pd.CurrentLinkWidth == nil {
+		return false, false
+	}
+	if *pd.CurrentLinkWidth == 0 {
+		return false, false
+	}
+	return *pd.CurrentLinkSpeed < *pd.MaxLinkSpeed || *pd.CurrentLinkWidth < *pd.MaxLinkWidth, true
+}
+
 // PciDevices is a collection of every PCI device in
 // /sys/bus/pci/devices .
 //
@@ -162,7 +669,8 @@ func (pd PciDevice) Name() string {
 type PciDevices map[string]PciDevice
 
 // PciDevices returns info for all PCI devices read from
-// /sys/bus/pci/devices .
+// /sys/bus/pci/devices . A device that cannot be read is logged, counted as
+// a collect error and left out, so that it does not hide the others.
 func GetPciDevices(basePath string) (PciDevices, error) {
 	path := path.Join(basePath, pciDevicesPath)
 
@@ -173,9 +681,15 @@ func GetPciDevices(basePath string) (PciDevices, error) {
 
 	pciDevs := make(PciDevices, len(dirs))
 	for _, d := range dirs {
-		device, err := parsePciDevice(basePath, d.Name())
-		if err != nil {
-			return nil, err
+		var device *PciDevice
+		collectDevice("pcidevice", d.Name(), func() {
+			if device, err = parsePciDevice(basePath, d.Name()); err != nil {
+				log.Errorf("Error reading PCI device %s: %s", d.Name(), err)
+				countCollectError("pcidevice", d.Name())
+			}
+		})
+		if device == nil {
+			continue
 		}
 
 		pciDevs[device.Name()] = *device
@@ -201,7 +715,7 @@ func parsePciDevice(basePath, name string) (*PciDevice, error) {
 
 	deviceLoc, err := parsePciDeviceLocation(deviceLocStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse device location:%q %w", deviceLoc, err)
+		return nil, fmt.Errorf("failed to parse device location %q: %w", deviceLocStr, err)
 	}
 
 	// the parent device may have "pci" prefix.
@@ -220,6 +734,7 @@ func parsePciDevice(basePath, name string) (*PciDevice, error) {
 		Location:       *deviceLoc,
 		ParentLocation: parentDeviceLoc,
 	}
+	device.RootComplex, device.Ancestors = parsePciDeviceChain(realPath)
 
 	// These files must exist in a device directory.
 	for _, f := range [...]string{"class", "vendor", "device", "subsystem_vendor", "subsystem_device", "revision"} {
@@ -251,9 +766,266 @@ func parsePciDevice(basePath, name string) (*PciDevice, error) {
 		}
 	}
 
+	parsePciDeviceAttributes(devicePath, device)
+
+	if err := parsePciDeviceSriov(devicePath, device); err != nil {
+		return nil, err
+	}
+
+	// The config space is only read with the inventory, as reading it
+	// wakes up runtime suspended devices
+	device.Config = readPciDeviceConfig(devicePath)
+
+	if err := parsePciDeviceState(devicePath, device); err != nil {
+		return nil, err
+	}
+
 	return device, nil
 }
 
+// parsePciDeviceState reads the attributes of a device that change without
+// a uevent: the link, AER counters, power state, enable and the SR-IOV
+// settings. The fields are reset first so that a cached device can be
+// refreshed.
+func parsePciDeviceState(devicePath string, device *PciDevice) error {
+	device.MaxLinkSpeed, device.MaxLinkWidth = nil, nil
+	device.CurrentLinkSpeed, device.CurrentLinkWidth = nil, nil
+	device.AER = nil
+	device.PowerState, device.Enabled = "", nil
+	device.SriovNumVFs, device.SriovDriversAutoprobe = nil, nil
+
+	// These files are only present for PCIe devices.
+	for _, f := range [...]string{"max_link_speed", "max_link_width", "current_link_speed", "current_link_width"} {
+		name := path.Join(devicePath, f)
+		valueStr, err := SysReadFile(name)
+		if err != nil {
+			continue
+		}
+		// Speeds look like "16.0 GT/s PCIe", "Unknown" when the link is down
+		number, _, _ := strings.Cut(valueStr, " ")
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			continue
+		}
+
+		switch f {
+		case "max_link_speed":
+			device.MaxLinkSpeed = &value
+		case "max_link_width":
+			device.MaxLinkWidth = &value
+		case "current_link_speed":
+			device.CurrentLinkSpeed = &value
+		case "current_link_width":
+			device.CurrentLinkWidth = &value
+		}
+	}
+
+	// AER files that cannot be read leave out the AER metrics, not the device
+	aer, err := parsePciDeviceAER(devicePath)
+	if err != nil {
+		log.Errorf("Error reading AER statistics of PCI device %s: %s", path.Base(devicePath), err)
+	}
+	device.AER = aer
+
+	if valueStr, err := SysReadFile(path.Join(devicePath, "enable")); err == nil {
+		enabled := valueStr != "0"
+		device.Enabled = &enabled
+	}
+	if valueStr, err := SysReadFile(path.Join(devicePath, "power_state")); err == nil {
+		device.PowerState = valueStr
+	}
+
+	for _, f := range [...]string{"sriov_numvfs", "sriov_drivers_autoprobe"} {
+		valueStr, err := SysReadFile(path.Join(devicePath, f))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return fmt.Errorf("failed to parse %q: %w", valueStr, err)
+		}
+		switch f {
+		case "sriov_numvfs":
+			device.SriovNumVFs = &value
+		case "sriov_drivers_autoprobe":
+			autoprobe := value != 0
+			device.SriovDriversAutoprobe = &autoprobe
+		}
+	}
+
+	return nil
+}
+
+// parsePciDeviceChain returns the host bridge and the upstream bridges of a
+// device from its resolved sysfs path, like
+// "../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0".
+// The bridges are ordered from the parent up to the root port.
+func parsePciDeviceChain(realPath string) (rootComplex string, ancestors []PciDeviceLocation) {
+	elements := strings.Split(path.Dir(realPath), "/")
+	for _, element := range elements {
+		// Devices behind a VMD controller have a second "pci" host bridge
+		if strings.HasPrefix(element, "pci") {
+			rootComplex = element
+			continue
+		}
+		if loc, err := parsePciDeviceLocation(element); err == nil {
+			ancestors = append(ancestors, *loc)
+		}
+	}
+	slices.Reverse(ancestors)
+	return rootComplex, ancestors
+}
+
+// parsePciDeviceSriov reads the number of VFs a physical function supports
+// and the links between physical and virtual functions.
+func parsePciDeviceSriov(devicePath string, device *PciDevice) error {
+	if valueStr, err := SysReadFile(path.Join(devicePath, "sriov_totalvfs")); err == nil {
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return fmt.Errorf("failed to parse %q: %w", valueStr, err)
+		}
+		device.SriovTotalVFs = &value
+	}
+
+	if physfn, err := os.Readlink(path.Join(devicePath, "physfn")); err == nil {
+		loc, err := parsePciDeviceLocation(path.Base(physfn))
+		if err != nil {
+			return fmt.Errorf("failed to parse physfn location %q: %w", physfn, err)
+		}
+		device.PhysFn = loc
+	}
+
+	entries, err := os.ReadDir(devicePath)
+	if err != nil {
+		return err
+	}
+	virtfns := make(map[int]PciDeviceLocation)
+	for _, entry := range entries {
+		index, ok := strings.CutPrefix(entry.Name(), "virtfn")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			continue
+		}
+		virtfn, err := os.Readlink(path.Join(devicePath, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to readlink: %w", err)
+		}
+		loc, err := parsePciDeviceLocation(path.Base(virtfn))
+		if err != nil {
+			return fmt.Errorf("failed to parse virtfn location %q: %w", virtfn, err)
+		}
+		virtfns[n] = *loc
+	}
+	for _, n := range slices.Sorted(maps.Keys(virtfns)) {
+		device.VirtFns = append(device.VirtFns, virtfns[n])
+	}
+	return nil
+}
+
+// parsePciDeviceAttributes reads the optional NUMA, driver, IOMMU and
+// interrupt attributes of a device. Missing or unreadable files leave the
+// field unset.
+func parsePciDeviceAttributes(devicePath string, device *PciDevice) {
+	for _, f := range [...]string{"numa_node", "irq"} {
+		valueStr, err := SysReadFile(path.Join(devicePath, f))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			continue
+		}
+		switch f {
+		case "numa_node":
+			device.NumaNode = &value
+		case "irq":
+			device.IRQ = &value
+		}
+	}
+	// local_cpulist can be longer than SysReadFile reads on large systems
+	if content, err := os.ReadFile(path.Join(devicePath, "local_cpulist")); err == nil {
+		device.LocalCPUList = strings.TrimSpace(string(content))
+	}
+	if driver, err := os.Readlink(path.Join(devicePath, "driver")); err == nil {
+		device.Driver = path.Base(driver)
+	}
+	if group, err := os.Readlink(path.Join(devicePath, "iommu_group")); err == nil {
+		if value, err := strconv.Atoi(path.Base(group)); err == nil {
+			device.IommuGroup = &value
+		}
+	}
+	if irqs, err := os.ReadDir(path.Join(devicePath, "msi_irqs")); err == nil {
+		device.MSIVectors = len(irqs)
+	}
+}
+
+// parsePciDeviceAER reads the AER statistics files of a device. It returns
+// nil when the device has none.
+func parsePciDeviceAER(devicePath string) (*PciDeviceAER, error) {
+	var aer *PciDeviceAER
+	rootPortFiles := map[string]string{
+		"correctable": "aer_rootport_total_err_cor",
+		"nonfatal":    "aer_rootport_total_err_nonfatal",
+		"fatal":       "aer_rootport_total_err_fatal",
+	}
+	for _, severity := range aerSeverities {
+		name := path.Join(devicePath, "aer_dev_"+severity)
+		content, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %q: %w", name, err)
+		}
+		counters, err := parseAERCounters(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", name, err)
+		}
+		if aer == nil {
+			aer = &PciDeviceAER{Device: map[string]map[string]uint64{}}
+		}
+		aer.Device[severity] = counters
+
+		valueStr, err := SysReadFile(path.Join(devicePath, rootPortFiles[severity]))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(valueStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", valueStr, err)
+		}
+		if aer.RootPort == nil {
+			aer.RootPort = map[string]uint64{}
+		}
+		aer.RootPort[severity] = value
+	}
+	return aer, nil
+}
+
+// parseAERCounters parses the "<type> <count>" lines of an aer_dev_* file.
+func parseAERCounters(content string) (map[string]uint64, error) {
+	counters := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counters[fields[0]] = value
+	}
+	return counters, scanner.Err()
+}
+
 func parsePciDeviceLocation(loc string) (*PciDeviceLocation, error) {
 	locs := strings.Split(loc, ":")
 	if len(locs) != 3 {
@@ -315,40 +1087,33 @@ func SysReadFile(file string) (string, error) {
 	return string(bytes.TrimSpace(b[:n])), nil
 }
 
-// loadPCIIds loads PCI device information from pci.ids file
-func (c *PCIDeviceCollector) loadPCIIds() {
-	var file *os.File
-	var err error
-
-	c.pciVendors = make(map[string]string)
-	c.pciDevices = make(map[string]map[string]string)
-	c.pciSubsystems = make(map[string]map[string]string)
-	c.pciClasses = make(map[string]string)
-	c.pciSubclasses = make(map[string]string)
-	c.pciProgIfs = make(map[string]string)
-
-	// Try each possible default path
-	for _, path := range pciIdsPaths {
-		file, err = os.Open(path)
-		if err == nil {
-			log.Debug("Loading PCI IDs from default path", "path", path)
-			break
-		}
+// loadPCIIds loads PCI device information in the pci.ids format
+func loadPCIIds(r io.Reader) *pciIds {
+	ids := &pciIds{
+		pciVendors:    make(map[string]string),
+		pciDevices:    make(map[string]map[string]string),
+		pciSubsystems: make(map[string]map[string]string),
+		pciClasses:    make(map[string]string),
+		pciSubclasses: make(map[string]string),
+		pciProgIfs:    make(map[string]string),
 	}
-	if err != nil {
-		log.Debug("Failed to open any default PCI IDs file", "error", err)
-		return
-	}
-
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	var currentVendor, currentDevice, currentBaseClass, currentSubclass string
 	var inClassContext bool
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		if line == "" || strings.HasPrefix(line, "#") {
+		// The header carries the version and date of the database
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			if version, ok := strings.CutPrefix(strings.TrimSpace(comment), "Version:"); ok {
+				ids.version = strings.TrimSpace(version)
+			} else if date, ok := strings.CutPrefix(strings.TrimSpace(comment), "Date:"); ok {
+				ids.date = strings.TrimSpace(date)
+			}
+			continue
+		}
+		if line == "" {
 			continue
 		}
 
@@ -358,7 +1123,7 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 			if len(parts) >= 2 {
 				classID := strings.TrimSpace(parts[0][1:]) // Remove 'C' prefix
 				className := strings.TrimSpace(parts[1])
-				c.pciClasses[classID] = className
+				ids.pciClasses[classID] = className
 				currentBaseClass = classID
 				inClassContext = true
 			}
@@ -374,7 +1139,7 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 				subclassName := strings.TrimSpace(parts[1])
 				// Store as base class + subclass (e.g., "0100" for SCSI storage controller)
 				fullClassID := currentBaseClass + subclassID
-				c.pciSubclasses[fullClassID] = subclassName
+				ids.pciSubclasses[fullClassID] = subclassName
 				currentSubclass = fullClassID
 			}
 			continue
@@ -389,7 +1154,7 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 				progIfName := strings.TrimSpace(parts[1])
 				// Store as base class + subclass + programming interface (e.g., "010802" for NVM Express)
 				fullClassID := currentSubclass + progIfID
-				c.pciProgIfs[fullClassID] = progIfName
+				ids.pciProgIfs[fullClassID] = progIfName
 			}
 			continue
 		}
@@ -399,7 +1164,7 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 			parts := strings.SplitN(line, "  ", 2)
 			if len(parts) >= 2 {
 				currentVendor = strings.TrimSpace(parts[0])
-				c.pciVendors[currentVendor] = strings.TrimSpace(parts[1])
+				ids.pciVendors[currentVendor] = strings.TrimSpace(parts[1])
 				currentDevice = ""
 				inClassContext = false
 			}
@@ -412,10 +1177,10 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 			parts := strings.SplitN(line, "  ", 2)
 			if len(parts) >= 2 && currentVendor != "" {
 				currentDevice = strings.TrimSpace(parts[0])
-				if c.pciDevices[currentVendor] == nil {
-					c.pciDevices[currentVendor] = make(map[string]string)
+				if ids.pciDevices[currentVendor] == nil {
+					ids.pciDevices[currentVendor] = make(map[string]string)
 				}
-				c.pciDevices[currentVendor][currentDevice] = strings.TrimSpace(parts[1])
+				ids.pciDevices[currentVendor][currentDevice] = strings.TrimSpace(parts[1])
 			}
 			continue
 		}
@@ -428,14 +1193,14 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 				subsysID := strings.TrimSpace(parts[0])
 				subsysName := strings.TrimSpace(parts[1])
 				key := fmt.Sprintf("%s:%s", currentVendor, currentDevice)
-				if c.pciSubsystems[key] == nil {
-					c.pciSubsystems[key] = make(map[string]string)
+				if ids.pciSubsystems[key] == nil {
+					ids.pciSubsystems[key] = make(map[string]string)
 				}
 				// Convert subsystem ID from "vendor device" format to "vendor:device" format
 				subsysParts := strings.Fields(subsysID)
 				if len(subsysParts) == 2 {
 					subsysKey := fmt.Sprintf("%s:%s", subsysParts[0], subsysParts[1])
-					c.pciSubsystems[key][subsysKey] = subsysName
+					ids.pciSubsystems[key][subsysKey] = subsysName
 				}
 			}
 		}
@@ -443,31 +1208,35 @@ func (c *PCIDeviceCollector) loadPCIIds() {
 
 	// Debug summary
 	totalDevices := 0
-	for _, devices := range c.pciDevices {
+	for _, devices := range ids.pciDevices {
 		totalDevices += len(devices)
 	}
 	totalSubsystems := 0
-	for _, subsystems := range c.pciSubsystems {
+	for _, subsystems := range ids.pciSubsystems {
 		totalSubsystems += len(subsystems)
 	}
 
 	log.Debug("Loaded PCI device data",
-		"vendors", len(c.pciVendors),
+		"vendors", len(ids.pciVendors),
 		"devices", totalDevices,
 		"subsystems", totalSubsystems,
-		"classes", len(c.pciClasses),
-		"subclasses", len(c.pciSubclasses),
-		"progIfs", len(c.pciProgIfs),
+		"classes", len(ids.pciClasses),
+		"subclasses", len(ids.pciSubclasses),
+		"progIfs", len(ids.pciProgIfs),
 	)
+
+	return ids
 }
 
 // getPCIVendorName converts PCI vendor ID to human-readable string using pci.ids
 func (c *PCIDeviceCollector) getPCIVendorName(vendorID string) string {
+	ids := c.pciIds.Load()
+
 	// Remove "0x" prefix if present
 	vendorID = strings.TrimPrefix(vendorID, "0x")
 	vendorID = strings.ToLower(vendorID)
 
-	if name, ok := c.pciVendors[vendorID]; ok {
+	if name, ok := ids.pciVendors[vendorID]; ok {
 		return name
 	}
 	return vendorID // Return ID if name not found
@@ -475,13 +1244,15 @@ func (c *PCIDeviceCollector) getPCIVendorName(vendorID string) string {
 
 // getPCIDeviceName converts PCI device ID to human-readable string using pci.ids
 func (c *PCIDeviceCollector) getPCIDeviceName(vendorID, deviceID string) string {
+	ids := c.pciIds.Load()
+
 	// Remove "0x" prefix if present
 	vendorID = strings.TrimPrefix(vendorID, "0x")
 	deviceID = strings.TrimPrefix(deviceID, "0x")
 	vendorID = strings.ToLower(vendorID)
 	deviceID = strings.ToLower(deviceID)
 
-	if devices, ok := c.pciDevices[vendorID]; ok {
+	if devices, ok := ids.pciDevices[vendorID]; ok {
 		if name, ok := devices[deviceID]; ok {
 			return name
 		}
@@ -491,6 +1262,8 @@ func (c *PCIDeviceCollector) getPCIDeviceName(vendorID, deviceID string) string
 
 // getPCISubsystemName converts PCI subsystem ID to human-readable string using pci.ids
 func (c *PCIDeviceCollector) getPCISubsystemName(vendorID, deviceID, subsysVendorID, subsysDeviceID string) string {
+	ids := c.pciIds.Load()
+
 	// Normalize all IDs
 	vendorID = strings.TrimPrefix(vendorID, "0x")
 	deviceID = strings.TrimPrefix(deviceID, "0x")
@@ -500,7 +1273,7 @@ func (c *PCIDeviceCollector) getPCISubsystemName(vendorID, deviceID, subsysVendo
 	key := fmt.Sprintf("%s:%s", vendorID, deviceID)
 	subsysKey := fmt.Sprintf("%s:%s", subsysVendorID, subsysDeviceID)
 
-	if subsystems, ok := c.pciSubsystems[key]; ok {
+	if subsystems, ok := ids.pciSubsystems[key]; ok {
 		if name, ok := subsystems[subsysKey]; ok {
 			return name
 		}
@@ -510,6 +1283,8 @@ func (c *PCIDeviceCollector) getPCISubsystemName(vendorID, deviceID, subsysVendo
 
 // getPCIClassName converts PCI class ID to human-readable string using pci.ids
 func (c *PCIDeviceCollector) getPCIClassName(classID string) string {
+	ids := c.pciIds.Load()
+
 	// Remove "0x" prefix if present and normalize
 	classID = strings.TrimPrefix(classID, "0x")
 	classID = strings.ToLower(classID)
@@ -517,7 +1292,7 @@ func (c *PCIDeviceCollector) getPCIClassName(classID string) string {
 	// Try to find the programming interface first (6 digits: base class + subclass + programming interface)
 	if len(classID) >= 6 {
 		progIf := classID[:6]
-		if className, exists := c.pciProgIfs[progIf]; exists {
+		if className, exists := ids.pciProgIfs[progIf]; exists {
 			return className
 		}
 	}
@@ -525,7 +1300,7 @@ func (c *PCIDeviceCollector) getPCIClassName(classID string) string {
 	// Try to find the subclass (4 digits: base class + subclass)
 	if len(classID) >= 4 {
 		subclass := classID[:4]
-		if className, exists := c.pciSubclasses[subclass]; exists {
+		if className, exists := ids.pciSubclasses[subclass]; exists {
 			return className
 		}
 	}
@@ -533,7 +1308,7 @@ func (c *PCIDeviceCollector) getPCIClassName(classID string) string {
 	// If not found, try with just the base class (first 2 digits)
 	if len(classID) >= 2 {
 		baseClass := classID[:2]
-		if className, exists := c.pciClasses[baseClass]; exists {
+		if className, exists := ids.pciClasses[baseClass]; exists {
 			return className
 		}
 	}
@@ -0,0 +1,535 @@
+package collector
+
+import (
+	"path"
+	"smc-exporter/collector/pciconfig"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pciDeviceFiles returns the sysfs files of a PCI device below parent.
+func pciDeviceFiles(parent, location, class string, extra map[string]string) map[string]string {
+	dir := "devices/" + parent + "/" + location
+	files := map[string]string{
+		"bus/pci/devices/" + location: "->../../../" + dir,
+		dir + "/class":                class,
+		dir + "/vendor":               "0x15b3",
+		dir + "/device":               "0x1021",
+		dir + "/subsystem_vendor":     "0x15b3",
+		dir + "/subsystem_device":     "0x0041",
+		dir + "/revision":             "0x00",
+	}
+	for name, content := range extra {
+		files[dir+"/"+name] = content
+	}
+	return files
+}
+
+func writePciSysfs(t *testing.T, devices ...map[string]string) string {
+	root := t.TempDir()
+	for _, files := range devices {
+		writeSysfs(t, root, files)
+	}
+	return root
+}
+
+func TestGetPciDevicesLink(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:00", "0000:00:00.0", "0x060000", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"max_link_speed":     "32.0 GT/s PCIe",
+			"max_link_width":     "16",
+			"current_link_speed": "16.0 GT/s PCIe",
+			"current_link_width": "8",
+		}),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.1", "0x020000", map[string]string{
+			"max_link_speed":     "32.0 GT/s PCIe",
+			"max_link_width":     "16",
+			"current_link_speed": "32.0 GT/s PCIe",
+			"current_link_width": "16",
+		}),
+		pciDeviceFiles("pci0000:17/0000:17:02.0", "0000:2b:00.0", "0x020000", map[string]string{
+			"max_link_speed":     "16.0 GT/s PCIe",
+			"max_link_width":     "16",
+			"current_link_speed": "Unknown",
+			"current_link_width": "0",
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	require.Len(t, devices, 4)
+
+	hostBridge := devices["0000:00:00:0"]
+	assert.Nil(t, hostBridge.MaxLinkSpeed)
+	_, ok := hostBridge.LinkDowntrained()
+	assert.False(t, ok)
+
+	downtrained := devices["0000:1b:00:0"]
+	require.NotNil(t, downtrained.CurrentLinkSpeed)
+	assert.Equal(t, 32.0, *downtrained.MaxLinkSpeed)
+	assert.Equal(t, 16.0, *downtrained.MaxLinkWidth)
+	assert.Equal(t, 16.0, *downtrained.CurrentLinkSpeed)
+	assert.Equal(t, 8.0, *downtrained.CurrentLinkWidth)
+	isDowntrained, ok := downtrained.LinkDowntrained()
+	assert.True(t, ok)
+	assert.True(t, isDowntrained)
+
+	isDowntrained, ok = devices["0000:1b:00:1"].LinkDowntrained()
+	assert.True(t, ok)
+	assert.False(t, isDowntrained)
+
+	linkDown := devices["0000:2b:00:0"]
+	assert.Nil(t, linkDown.CurrentLinkSpeed)
+	_, ok = linkDown.LinkDowntrained()
+	assert.False(t, ok)
+}
+
+func TestPCIDeviceCollectLink(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"max_link_speed":     "32.0 GT/s PCIe",
+			"max_link_width":     "16",
+			"current_link_speed": "16.0 GT/s PCIe",
+			"current_link_width": "8",
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		for _, device := range devices {
+			c.collectLink(ch, device)
+		}
+	})
+	expected := `
+# HELP smc_pcidevice_current_link_transfers_per_second Value of current link's transfers per second (T/s)
+# TYPE smc_pcidevice_current_link_transfers_per_second gauge
+smc_pcidevice_current_link_transfers_per_second{bus="1b",device="00",function="0",segment="0000"} 1.6e+10
+# HELP smc_pcidevice_current_link_width Value of current link's width (number of lanes)
+# TYPE smc_pcidevice_current_link_width gauge
+smc_pcidevice_current_link_width{bus="1b",device="00",function="0",segment="0000"} 8
+# HELP smc_pcidevice_link_downtrained 1 if the link trained below its maximum speed or width, 0 otherwise. GPUs lower their link speed while idle.
+# TYPE smc_pcidevice_link_downtrained gauge
+smc_pcidevice_link_downtrained{bus="1b",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_max_link_transfers_per_second Value of maximum link's transfers per second (T/s)
+# TYPE smc_pcidevice_max_link_transfers_per_second gauge
+smc_pcidevice_max_link_transfers_per_second{bus="1b",device="00",function="0",segment="0000"} 3.2e+10
+# HELP smc_pcidevice_max_link_width Value of maximum link's width (number of lanes)
+# TYPE smc_pcidevice_max_link_width gauge
+smc_pcidevice_max_link_width{bus="1b",device="00",function="0",segment="0000"} 16
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+const aerDevCorrectable = `RxErr 0
+BadTLP 3
+BadDLLP 1
+Rollover 0
+Timeout 0
+NonFatalErr 0
+CorrIntErr 0
+HeaderOF 0
+TOTAL_ERR_COR 4
+`
+
+const aerDevFatal = `Undefined 0
+DLP 0
+SDES 0
+TLP 0
+FCP 0
+CmpltTO 0
+CmpltAbrt 0
+UnxCmplt 0
+RxOF 0
+MalfTLP 0
+ECRC 0
+UnsupReq 0
+ACSViol 0
+UncorrIntErr 0
+BlockedTLP 0
+AtomicOpBlocked 0
+TLPBlockedErr 0
+PoisonTLPBlocked 0
+TOTAL_ERR_FATAL 0
+`
+
+const aerDevNonFatal = `Undefined 0
+DLP 0
+SDES 0
+TLP 0
+FCP 0
+CmpltTO 2
+CmpltAbrt 0
+UnxCmplt 0
+RxOF 0
+MalfTLP 0
+ECRC 0
+UnsupReq 0
+ACSViol 0
+UncorrIntErr 0
+BlockedTLP 0
+AtomicOpBlocked 0
+TLPBlockedErr 0
+PoisonTLPBlocked 0
+TOTAL_ERR_NONFATAL 2
+`
+
+func TestGetPciDevicesAER(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:00", "0000:00:00.0", "0x060000", nil),
+		pciDeviceFiles("pci0000:17", "0000:17:01.0", "0x060400", map[string]string{
+			"aer_dev_correctable":             aerDevCorrectable,
+			"aer_dev_fatal":                   aerDevFatal,
+			"aer_dev_nonfatal":                aerDevNonFatal,
+			"aer_rootport_total_err_cor":      "7",
+			"aer_rootport_total_err_fatal":    "0",
+			"aer_rootport_total_err_nonfatal": "2",
+		}),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"aer_dev_correctable": aerDevCorrectable,
+			"aer_dev_fatal":       aerDevFatal,
+			"aer_dev_nonfatal":    aerDevNonFatal,
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+
+	assert.Nil(t, devices["0000:00:00:0"].AER)
+
+	rootPort := devices["0000:17:01:0"].AER
+	require.NotNil(t, rootPort)
+	assert.Equal(t, map[string]uint64{"correctable": 7, "fatal": 0, "nonfatal": 2}, rootPort.RootPort)
+
+	endpoint := devices["0000:1b:00:0"].AER
+	require.NotNil(t, endpoint)
+	assert.Nil(t, endpoint.RootPort)
+	assert.Len(t, endpoint.Device["correctable"], 9)
+	assert.Equal(t, uint64(3), endpoint.Device["correctable"]["BadTLP"])
+	assert.Equal(t, uint64(4), endpoint.Device["correctable"]["TOTAL_ERR_COR"])
+	assert.Equal(t, uint64(2), endpoint.Device["nonfatal"]["CmpltTO"])
+	assert.Len(t, endpoint.Device["fatal"], 19)
+}
+
+func TestGetPciDevicesAERInvalid(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"aer_dev_correctable": "RxErr x",
+			"current_link_width":  "16",
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	require.Contains(t, devices, "0000:1b:00:0")
+	assert.Nil(t, devices["0000:1b:00:0"].AER)
+	assert.Equal(t, 16.0, *devices["0000:1b:00:0"].CurrentLinkWidth)
+}
+
+func TestParseAERCountersInvalid(t *testing.T) {
+	_, err := parseAERCounters("RxErr\n")
+	assert.Error(t, err)
+	_, err = parseAERCounters("RxErr x\n")
+	assert.Error(t, err)
+}
+
+func TestPCIDeviceCollectAER(t *testing.T) {
+	device := PciDevice{
+		Location: PciDeviceLocation{Segment: 0, Bus: 0x17, Device: 1, Function: 0},
+		AER: &PciDeviceAER{
+			Device: map[string]map[string]uint64{
+				"correctable": {"BadTLP": 3, "TOTAL_ERR_COR": 3},
+				"fatal":       {"MalfTLP": 0, "TOTAL_ERR_FATAL": 0},
+			},
+			RootPort: map[string]uint64{"correctable": 7},
+		},
+	}
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectAER(ch, device)
+	})
+	expected := `
+# HELP smc_pcidevice_aer_device_errors_total AER error messages reported by the device.
+# TYPE smc_pcidevice_aer_device_errors_total counter
+smc_pcidevice_aer_device_errors_total{bus="17",device="01",function="0",segment="0000",severity="correctable"} 3
+smc_pcidevice_aer_device_errors_total{bus="17",device="01",function="0",segment="0000",severity="fatal"} 0
+# HELP smc_pcidevice_aer_errors_total AER errors reported by the device, from /sys/bus/pci/devices/<location>/aer_dev_<severity>.
+# TYPE smc_pcidevice_aer_errors_total counter
+smc_pcidevice_aer_errors_total{bus="17",device="01",function="0",segment="0000",severity="correctable",type="BadTLP"} 3
+smc_pcidevice_aer_errors_total{bus="17",device="01",function="0",segment="0000",severity="fatal",type="MalfTLP"} 0
+# HELP smc_pcidevice_aer_rootport_errors_total AER error messages received by the root port from the devices below it.
+# TYPE smc_pcidevice_aer_rootport_errors_total counter
+smc_pcidevice_aer_rootport_errors_total{bus="17",device="01",function="0",segment="0000",severity="correctable"} 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestGetPciDevicesAttributes(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:00", "0000:00:00.0", "0x060000", nil),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"numa_node":     "1",
+			"local_cpulist": "32-63,96-127",
+			"driver":        "->../../../../bus/pci/drivers/mlx5_core",
+			"iommu_group":   "->../../../../kernel/iommu_groups/42",
+			"power_state":   "D0",
+			"enable":        "1",
+			"irq":           "18",
+			"msi_irqs/120":  "msix",
+			"msi_irqs/121":  "msix",
+			"msi_irqs/122":  "msix",
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+
+	hostBridge := devices["0000:00:00:0"]
+	assert.Nil(t, hostBridge.NumaNode)
+	assert.Nil(t, hostBridge.IommuGroup)
+	assert.Nil(t, hostBridge.Enabled)
+	assert.Empty(t, hostBridge.Driver)
+	assert.Zero(t, hostBridge.MSIVectors)
+
+	nic := devices["0000:1b:00:0"]
+	require.NotNil(t, nic.NumaNode)
+	assert.Equal(t, 1, *nic.NumaNode)
+	assert.Equal(t, "32-63,96-127", nic.LocalCPUList)
+	assert.Equal(t, "mlx5_core", nic.Driver)
+	require.NotNil(t, nic.IommuGroup)
+	assert.Equal(t, 42, *nic.IommuGroup)
+	assert.Equal(t, "D0", nic.PowerState)
+	require.NotNil(t, nic.Enabled)
+	assert.True(t, *nic.Enabled)
+	require.NotNil(t, nic.IRQ)
+	assert.Equal(t, 18, *nic.IRQ)
+	assert.Equal(t, 3, nic.MSIVectors)
+}
+
+func TestPCIDeviceCollectAttributes(t *testing.T) {
+	numaNode, irq, iommuGroup, enabled := 1, 18, 42, true
+	device := PciDevice{
+		Location:     PciDeviceLocation{Segment: 0, Bus: 0x1b, Device: 0, Function: 0},
+		NumaNode:     &numaNode,
+		LocalCPUList: "32-63,96-127",
+		Driver:       "mlx5_core",
+		IommuGroup:   &iommuGroup,
+		PowerState:   "D0",
+		Enabled:      &enabled,
+		IRQ:          &irq,
+		MSIVectors:   3,
+	}
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{Attributes: true})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectAttributes(ch, device)
+	})
+	expected := `
+# HELP smc_pcidevice_attributes_info Driver, IOMMU group, power state and local CPUs of the device, value is always 1.
+# TYPE smc_pcidevice_attributes_info gauge
+smc_pcidevice_attributes_info{bus="1b",device="00",driver="mlx5_core",function="0",iommu_group="42",local_cpulist="32-63,96-127",power_state="D0",segment="0000"} 1
+# HELP smc_pcidevice_enabled 1 if the device is enabled, 0 otherwise.
+# TYPE smc_pcidevice_enabled gauge
+smc_pcidevice_enabled{bus="1b",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_irq Legacy interrupt line of the device, 0 if none.
+# TYPE smc_pcidevice_irq gauge
+smc_pcidevice_irq{bus="1b",device="00",function="0",segment="0000"} 18
+# HELP smc_pcidevice_msi_vectors Number of MSI/MSI-X vectors allocated to the device.
+# TYPE smc_pcidevice_msi_vectors gauge
+smc_pcidevice_msi_vectors{bus="1b",device="00",function="0",segment="0000"} 3
+# HELP smc_pcidevice_numa_node NUMA node the device is attached to, -1 if unknown.
+# TYPE smc_pcidevice_numa_node gauge
+smc_pcidevice_numa_node{bus="1b",device="00",function="0",segment="0000"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestGetPciDevicesSriov(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"sriov_totalvfs":          "127",
+			"sriov_numvfs":            "2",
+			"sriov_drivers_autoprobe": "1",
+			"virtfn0":                 "->../0000:1b:00.2",
+			"virtfn1":                 "->../0000:1b:00.3",
+		}),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.2", "0x020000", map[string]string{
+			"physfn": "->../0000:1b:00.0",
+		}),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.3", "0x020000", map[string]string{
+			"physfn": "->../0000:1b:00.0",
+		}),
+	)
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+
+	pf := devices["0000:1b:00:0"]
+	assert.False(t, pf.IsVirtualFunction())
+	require.NotNil(t, pf.SriovTotalVFs)
+	assert.Equal(t, 127, *pf.SriovTotalVFs)
+	require.NotNil(t, pf.SriovNumVFs)
+	assert.Equal(t, 2, *pf.SriovNumVFs)
+	require.NotNil(t, pf.SriovDriversAutoprobe)
+	assert.True(t, *pf.SriovDriversAutoprobe)
+	assert.Equal(t, []PciDeviceLocation{
+		{Segment: 0, Bus: 0x1b, Device: 0, Function: 2},
+		{Segment: 0, Bus: 0x1b, Device: 0, Function: 3},
+	}, pf.VirtFns)
+
+	vf := devices["0000:1b:00:2"]
+	assert.True(t, vf.IsVirtualFunction())
+	assert.Equal(t, &PciDeviceLocation{Segment: 0, Bus: 0x1b, Device: 0, Function: 0}, vf.PhysFn)
+	assert.Nil(t, vf.SriovTotalVFs)
+}
+
+func TestPCIDeviceCollectSriov(t *testing.T) {
+	totalVFs, numVFs, autoprobe := 127, 1, false
+	pf := PciDevice{
+		Location:              PciDeviceLocation{Segment: 0, Bus: 0x1b, Device: 0, Function: 0},
+		SriovTotalVFs:         &totalVFs,
+		SriovNumVFs:           &numVFs,
+		SriovDriversAutoprobe: &autoprobe,
+		VirtFns:               []PciDeviceLocation{{Segment: 0, Bus: 0x1b, Device: 0, Function: 2}},
+	}
+	vf := PciDevice{
+		Location: PciDeviceLocation{Segment: 0, Bus: 0x1b, Device: 0, Function: 2},
+		PhysFn:   &pf.Location,
+	}
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectSriov(ch, pf)
+		c.collectSriov(ch, vf)
+	})
+	expected := `
+# HELP smc_pcidevice_sriov_drivers_autoprobe 1 if drivers are probed for new virtual functions, 0 otherwise.
+# TYPE smc_pcidevice_sriov_drivers_autoprobe gauge
+smc_pcidevice_sriov_drivers_autoprobe{bus="1b",device="00",function="0",segment="0000"} 0
+# HELP smc_pcidevice_sriov_num_vfs Number of SR-IOV virtual functions enabled on the physical function.
+# TYPE smc_pcidevice_sriov_num_vfs gauge
+smc_pcidevice_sriov_num_vfs{bus="1b",device="00",function="0",segment="0000"} 1
+# HELP smc_pcidevice_sriov_total_vfs Maximum number of SR-IOV virtual functions the physical function supports.
+# TYPE smc_pcidevice_sriov_total_vfs gauge
+smc_pcidevice_sriov_total_vfs{bus="1b",device="00",function="0",segment="0000"} 127
+# HELP smc_pcidevice_sriov_vf_info Physical function of an SR-IOV virtual function (physfn), value is always 1.
+# TYPE smc_pcidevice_sriov_vf_info gauge
+smc_pcidevice_sriov_vf_info{bus="1b",device="00",function="2",pf_bus="1b",pf_device="00",pf_function="0",pf_segment="0000",segment="0000"} 1
+# HELP smc_pcidevice_sriov_vfs Number of virtual functions linked to the physical function (virtfn*).
+# TYPE smc_pcidevice_sriov_vfs gauge
+smc_pcidevice_sriov_vfs{bus="1b",device="00",function="0",segment="0000"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestPCIDeviceFilter(t *testing.T) {
+	hostBridge := PciDevice{Location: PciDeviceLocation{Bus: 0x00}, Class: 0x060000, Vendor: 0x8086}
+	nic := PciDevice{Location: PciDeviceLocation{Bus: 0x1b}, Class: 0x020700, Vendor: 0x15b3}
+	gpu := PciDevice{Location: PciDeviceLocation{Bus: 0x1a}, Class: 0x030200, Vendor: 0x10de}
+	nvme := PciDevice{Location: PciDeviceLocation{Bus: 0x5e}, Class: 0x010802, Vendor: 0x144d}
+	devices := []PciDevice{hostBridge, nic, gpu, nvme}
+
+	tests := []struct {
+		name     string
+		filter   PCIDeviceFilter
+		expected []PciDevice
+	}{
+		{"empty", PCIDeviceFilter{}, devices},
+		{"include classes", PCIDeviceFilter{IncludeClasses: []string{"0x02", "0x0302", "0x0108"}}, []PciDevice{nic, gpu, nvme}},
+		{"exclude classes", PCIDeviceFilter{ExcludeClasses: []string{"06"}}, []PciDevice{nic, gpu, nvme}},
+		{"include vendors", PCIDeviceFilter{IncludeVendors: []string{"0x15B3", "10de"}}, []PciDevice{nic, gpu}},
+		{"exclude vendors", PCIDeviceFilter{ExcludeVendors: []string{"0x8086"}}, []PciDevice{nic, gpu, nvme}},
+		{"include addresses", PCIDeviceFilter{IncludeAddresses: []string{"0000:1?:00.*"}}, []PciDevice{nic, gpu}},
+		{"exclude addresses", PCIDeviceFilter{ExcludeAddresses: []string{"0000:00:*"}}, []PciDevice{nic, gpu, nvme}},
+		{"include and exclude", PCIDeviceFilter{IncludeClasses: []string{"0x02", "0x03"}, ExcludeVendors: []string{"0x10de"}}, []PciDevice{nic}},
+		{"include lists combine", PCIDeviceFilter{IncludeClasses: []string{"0x02", "0x03"}, IncludeAddresses: []string{"0000:1a:*"}}, []PciDevice{gpu}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.filter.Validate())
+			var matched []PciDevice
+			for _, device := range devices {
+				if tt.filter.Match(device) {
+					matched = append(matched, device)
+				}
+			}
+			assert.Equal(t, tt.expected, matched)
+		})
+	}
+}
+
+func TestPCIDeviceFilterInvalidPattern(t *testing.T) {
+	_, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{Filter: PCIDeviceFilter{ExcludeAddresses: []string{"0000:[1b"}}})
+	assert.Error(t, err)
+}
+
+func TestPCIDeviceInventoryCache(t *testing.T) {
+	root := writePciSysfs(t,
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", map[string]string{
+			"current_link_width": "16",
+		}),
+	)
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{ResyncInterval: time.Hour})
+	require.NoError(t, err)
+	inventory, err := c.getInventory(root)
+	require.NoError(t, err)
+	require.Len(t, inventory.devices, 1)
+	assert.Equal(t, "0x020000", inventory.infoValues["0000:1b:00:0"][8])
+
+	// A hotplugged device only shows up once the inventory is invalidated
+	writeSysfs(t, root, pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.1", "0x020000", nil))
+	cached, err := c.getInventory(root)
+	require.NoError(t, err)
+	assert.Same(t, inventory, cached)
+
+	c.Invalidate()
+	inventory, err = c.getInventory(root)
+	require.NoError(t, err)
+	assert.Len(t, inventory.devices, 2)
+
+	// The state of a cached device is read again
+	device := inventory.devices["0000:1b:00:0"]
+	writeSysfs(t, root, map[string]string{"devices/pci0000:17/0000:17:01.0/0000:1b:00.0/current_link_width": "8"})
+	require.NoError(t, parsePciDeviceState(path.Join(root, pciDevicesPath, device.Location.Address()), &device))
+	assert.Equal(t, 8.0, *device.CurrentLinkWidth)
+	assert.Equal(t, 16.0, *inventory.devices["0000:1b:00:0"].CurrentLinkWidth)
+
+	// but not its config space, which is only read with the inventory
+	device.Config = &pciconfig.Config{VendorID: 0x15b3}
+	require.NoError(t, parsePciDeviceState(path.Join(root, pciDevicesPath, device.Location.Address()), &device))
+	assert.Equal(t, uint16(0x15b3), device.Config.VendorID)
+}
+
+func TestPCIDeviceInventoryNoCache(t *testing.T) {
+	root := writePciSysfs(t, pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:1b:00.0", "0x020000", nil))
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	inventory, err := c.getInventory(root)
+	require.NoError(t, err)
+	again, err := c.getInventory(root)
+	require.NoError(t, err)
+	assert.NotSame(t, inventory, again)
+}
+
+// FuzzParsePciDeviceLocation checks that parsePciDeviceLocation does not
+// panic and that a parsed location reads back from its address.
+func FuzzParsePciDeviceLocation(f *testing.F) {
+	for _, loc := range []string{"0000:1b:00.0", "0000:17:01.0", "10000:00:1f.7", "pci0000:17", "0000:1b:00", "::.", ""} {
+		f.Add(loc)
+	}
+	f.Fuzz(func(t *testing.T, loc string) {
+		location, err := parsePciDeviceLocation(loc)
+		if err != nil {
+			return
+		}
+		again, err := parsePciDeviceLocation(location.Address())
+		require.NoError(t, err)
+		assert.Equal(t, location, again)
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bytes"
+	_ "embed"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// embeddedPciIds is the fallback for hosts without a pci.ids file.
+// Refresh it with update-pci-ids.sh.
+//
+//go:embed pci.ids
+var embeddedPciIds []byte
+
+const pciIdsEmbedded = "embedded"
+
+var pciIdsPaths = []string{
+	"/usr/share/misc/pci.ids",
+	"/usr/share/hwdata/pci.ids",
+}
+
+// pciIds holds the vendor, device and class names of a pci.ids database.
+type pciIds struct {
+	source        string // path of the file or "embedded"
+	version       string // "Version:" line of the header
+	date          string // "Date:" line of the header
+	pciVendors    map[string]string
+	pciDevices    map[string]map[string]string
+	pciSubsystems map[string]map[string]string
+	pciClasses    map[string]string
+	pciSubclasses map[string]string
+	pciProgIfs    map[string]string
+}
+
+// pciIdsSource remembers the file the names were loaded from, so that they
+// are reloaded when it changes.
+type pciIdsSource struct {
+	mu      sync.Mutex
+	path    string // --pci.ids-path, empty to search pciIdsPaths
+	loaded  string
+	modTime time.Time
+	size    int64
+}
+
+// reloadPCIIds loads the configured or first default pci.ids file, falling
+// back to the embedded copy. It does nothing when the file in use has not
+// changed since the last call.
+func (c *PCIDeviceCollector) reloadPCIIds() {
+	source := &c.pciIdsSource
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	paths := pciIdsPaths
+	if source.path != "" {
+		paths = []string{source.path}
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Debugf("PCI IDs file %s not usable: %s", path, err)
+			continue
+		}
+		if path == source.loaded && info.ModTime().Equal(source.modTime) && info.Size() == source.size {
+			return
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Warnf("Error reading PCI IDs file %s: %s", path, err)
+			continue
+		}
+		log.Infof("Loading PCI IDs from %s", path)
+		ids := loadPCIIds(bytes.NewReader(content))
+		ids.source = path
+		c.pciIds.Store(ids)
+		source.loaded, source.modTime, source.size = path, info.ModTime(), info.Size()
+		return
+	}
+
+	if source.loaded == pciIdsEmbedded {
+		return
+	}
+	if source.path != "" {
+		log.Warnf("PCI IDs file %s not usable, using the embedded copy", source.path)
+	} else {
+		log.Debug("No PCI IDs file found, using the embedded copy")
+	}
+	ids := loadPCIIds(bytes.NewReader(embeddedPciIds))
+	ids.source = pciIdsEmbedded
+	c.pciIds.Store(ids)
+	source.loaded, source.modTime, source.size = pciIdsEmbedded, time.Time{}, 0
+}
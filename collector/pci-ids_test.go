@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPciIds = `#
+#	List of PCI ID's
+#
+#	Version: 2024.02.02
+#	Date:    2024-02-02 03:15:01
+#
+15b3  Mellanox Technologies
+	1021  MT2910 Family [ConnectX-7]
+		15b3 0041  ConnectX-7 HHHL Adapter Card
+C 02  Network controller
+	07  Infiniband controller
+`
+
+func TestLoadPCIIds(t *testing.T) {
+	ids := loadPCIIds(strings.NewReader(testPciIds))
+	assert.Equal(t, "2024.02.02", ids.version)
+	assert.Equal(t, "2024-02-02 03:15:01", ids.date)
+
+	c := &PCIDeviceCollector{}
+	c.pciIds.Store(ids)
+	assert.Equal(t, "Mellanox Technologies", c.getPCIVendorName("0x15b3"))
+	assert.Equal(t, "MT2910 Family [ConnectX-7]", c.getPCIDeviceName("0x15b3", "0x1021"))
+	assert.Equal(t, "ConnectX-7 HHHL Adapter Card", c.getPCISubsystemName("0x15b3", "0x1021", "0x15b3", "0x0041"))
+	assert.Equal(t, "Infiniband controller", c.getPCIClassName("0x020700"))
+	assert.Equal(t, "10de", c.getPCIVendorName("0x10de"))
+}
+
+func TestPCIIdsEmbeddedFallback(t *testing.T) {
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{PCIIdsPath: filepath.Join(t.TempDir(), "pci.ids")})
+	require.NoError(t, err)
+	ids := c.pciIds.Load()
+	assert.Equal(t, pciIdsEmbedded, ids.source)
+	// The version and date are the ones of the embedded file, if it has any
+	embedded := loadPCIIds(bytes.NewReader(embeddedPciIds))
+	assert.Equal(t, embedded.version, ids.version)
+	assert.Equal(t, embedded.date, ids.date)
+	assert.Equal(t, "Mellanox Technologies", c.getPCIVendorName("0x15b3"))
+	assert.Equal(t, "3D controller", c.getPCIClassName("0x030200"))
+}
+
+// TestPCIIdsEmbeddedHeader checks that the embedded copy is the upstream
+// database written by update-pci-ids.sh, whose header has the version and
+// date reported by smc_pcidevice_ids_database_info.
+func TestPCIIdsEmbeddedHeader(t *testing.T) {
+	if bytes.Contains(embeddedPciIds, []byte("#\tSubset of the database")) {
+		t.Skip("the embedded pci.ids is the curated subset, run update-pci-ids.sh to embed the upstream database")
+	}
+	ids := loadPCIIds(bytes.NewReader(embeddedPciIds))
+	assert.NotEmpty(t, ids.version)
+	assert.NotEmpty(t, ids.date)
+}
+
+func TestPCIIdsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	require.NoError(t, os.WriteFile(path, []byte(testPciIds), 0o644))
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{PCIIdsPath: path})
+	require.NoError(t, err)
+	ids := c.pciIds.Load()
+	assert.Equal(t, path, ids.source)
+	assert.Equal(t, "2024.02.02", ids.version)
+
+	// Unchanged files are not parsed again
+	c.reloadPCIIds()
+	assert.Same(t, ids, c.pciIds.Load())
+
+	updated := strings.Replace(testPciIds, "2024.02.02", "2024.03.01", 1)
+	updated = strings.Replace(updated, "MT2910 Family [ConnectX-7]", "ConnectX-7", 1)
+	require.NoError(t, os.WriteFile(path, []byte(updated), 0o644))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	c.reloadPCIIds()
+	assert.Equal(t, "2024.03.01", c.pciIds.Load().version)
+	assert.Equal(t, "ConnectX-7", c.getPCIDeviceName("0x15b3", "0x1021"))
+
+	// A file that disappears falls back to the embedded copy
+	require.NoError(t, os.Remove(path))
+	c.reloadPCIIds()
+	assert.Equal(t, pciIdsEmbedded, c.pciIds.Load().source)
+}
+
+// FuzzLoadPCIIds checks that loadPCIIds does not panic on any pci.ids file.
+func FuzzLoadPCIIds(f *testing.F) {
+	f.Add(embeddedPciIds)
+	f.Add([]byte(testPciIds))
+	f.Add([]byte("C \n\t\n\t\t\n15b3\n\t\n\t\t\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ids := loadPCIIds(bytes.NewReader(data))
+		c := &PCIDeviceCollector{}
+		c.pciIds.Store(ids)
+		for vendor := range ids.pciVendors {
+			c.getPCIVendorName("0x" + vendor)
+		}
+		for class := range ids.pciClasses {
+			c.getPCIClassName("0x" + class)
+		}
+	})
+}
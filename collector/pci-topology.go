@@ -0,0 +1,242 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PciTree is the PCIe topology of the host, one entry per root complex.
+type PciTree struct {
+	RootComplexes []*PciTreeNode `json:"root_complexes"`
+}
+
+// PciTreeNode is a root complex or a device in the PCIe topology.
+type PciTreeNode struct {
+	// Address is the sysfs name, eg. "0000:1b:00.0" or "pci0000:17" for a
+	// root complex.
+	Address          string         `json:"address"`
+	ClassID          string         `json:"class_id,omitempty"`
+	ClassName        string         `json:"class_name,omitempty"`
+	VendorID         string         `json:"vendor_id,omitempty"`
+	VendorName       string         `json:"vendor_name,omitempty"`
+	DeviceID         string         `json:"device_id,omitempty"`
+	DeviceName       string         `json:"device_name,omitempty"`
+	Driver           string         `json:"driver,omitempty"`
+	NumaNode         *int           `json:"numa_node,omitempty"`
+	CurrentLinkSpeed *float64       `json:"current_link_speed,omitempty"`
+	CurrentLinkWidth *float64       `json:"current_link_width,omitempty"`
+	Children         []*PciTreeNode `json:"children,omitempty"`
+}
+
+// PciAffinity is the shortest PCIe path between two devices, from the best
+// for peer to peer traffic such as GPUDirect RDMA to the worst.
+type PciAffinity int
+
+const (
+	// PciAffinitySwitch is a path through a PCIe switch only.
+	PciAffinitySwitch PciAffinity = iota
+	// PciAffinityRootComplex is a path through a root port or the host
+	// bridge.
+	PciAffinityRootComplex
+	// PciAffinityNumaNode is a path between host bridges of the same NUMA node.
+	PciAffinityNumaNode
+	// PciAffinityCrossSocket is a path over the socket interconnect.
+	PciAffinityCrossSocket
+)
+
+var pciAffinityNames = []string{"switch", "root_complex", "numa_node", "cross_socket"}
+
+func (a PciAffinity) String() string {
+	return pciAffinityNames[a]
+}
+
+// GetPciAffinity returns the shortest path between two devices. Devices on
+// different host bridges are considered on different sockets when their
+// NUMA nodes differ.
+func GetPciAffinity(a, b PciDevice) PciAffinity {
+	if a.RootComplex != b.RootComplex {
+		if a.NumaNode != nil && b.NumaNode != nil && *a.NumaNode != *b.NumaNode {
+			return PciAffinityCrossSocket
+		}
+		return PciAffinityNumaNode
+	}
+	// The last ancestor is the root port, which is part of the root complex
+	for i, ancestor := range a.Ancestors {
+		if i == len(a.Ancestors)-1 {
+			break
+		}
+		if slices.Contains(b.Ancestors, ancestor) {
+			return PciAffinitySwitch
+		}
+	}
+	return PciAffinityRootComplex
+}
+
+// bmcVGAVendors are the vendors of the VGA controllers built into BMCs.
+var bmcVGAVendors = map[uint32]bool{
+	0x1a03: true, // ASPEED
+	0x102b: true, // Matrox
+}
+
+// isGPU reports whether the device is a 3D controller, or a VGA controller
+// that is not the BMC's.
+func (pd PciDevice) isGPU() bool {
+	switch pd.Class >> 8 {
+	case 0x0302:
+		return true
+	case 0x0300:
+		return !bmcVGAVendors[pd.Vendor]
+	}
+	return false
+}
+
+// isNIC reports whether the device is an Ethernet or InfiniBand controller.
+func (pd PciDevice) isNIC() bool {
+	class := pd.Class >> 8
+	return class == 0x0200 || class == 0x0207
+}
+
+// collectAffinity exports the path between every GPU and NIC physical
+// function.
+func (e *PCIDeviceCollector) collectAffinity(ch chan<- prometheus.Metric, devices PciDevices) {
+	var gpus, nics []PciDevice
+	for _, device := range devices {
+		if device.IsVirtualFunction() {
+			continue
+		}
+		if device.isGPU() {
+			gpus = append(gpus, device)
+		} else if device.isNIC() {
+			nics = append(nics, device)
+		}
+	}
+	for _, gpu := range gpus {
+		for _, nic := range nics {
+			labels := append(gpu.Location.Strings(), nic.Location.Strings()...)
+			sendConstMetric(ch, e.gpuNicAffinityDesc, prometheus.GaugeValue, 1.0,
+				append(labels, GetPciAffinity(gpu, nic).String())...)
+		}
+	}
+}
+
+// PciTree reads the PCI devices below basePath and arranges them by their
+// upstream bridges.
+func (e *PCIDeviceCollector) PciTree(basePath string) (*PciTree, error) {
+	e.reloadPCIIds()
+	devices, err := GetPciDevices(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[PciDeviceLocation]*PciTreeNode, len(devices))
+	for _, device := range devices {
+		vendorID := fmt.Sprintf("0x%04x", device.Vendor)
+		deviceID := fmt.Sprintf("0x%04x", device.Device)
+		classID := fmt.Sprintf("0x%06x", device.Class)
+		nodes[device.Location] = &PciTreeNode{
+			Address:          device.Location.Address(),
+			ClassID:          classID,
+			ClassName:        e.getPCIClassName(classID),
+			VendorID:         vendorID,
+			VendorName:       e.getPCIVendorName(vendorID),
+			DeviceID:         deviceID,
+			DeviceName:       e.getPCIDeviceName(vendorID, deviceID),
+			Driver:           device.Driver,
+			NumaNode:         device.NumaNode,
+			CurrentLinkSpeed: device.CurrentLinkSpeed,
+			CurrentLinkWidth: device.CurrentLinkWidth,
+		}
+	}
+
+	tree := &PciTree{}
+	rootComplexes := make(map[string]*PciTreeNode)
+	for _, device := range devices {
+		node := nodes[device.Location]
+		if len(device.Ancestors) > 0 {
+			if parent, ok := nodes[device.Ancestors[0]]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		rootComplex, ok := rootComplexes[device.RootComplex]
+		if !ok {
+			rootComplex = &PciTreeNode{Address: device.RootComplex}
+			rootComplexes[device.RootComplex] = rootComplex
+			tree.RootComplexes = append(tree.RootComplexes, rootComplex)
+		}
+		rootComplex.Children = append(rootComplex.Children, node)
+	}
+
+	sortPciTreeNodes(tree.RootComplexes)
+	return tree, nil
+}
+
+func sortPciTreeNodes(nodes []*PciTreeNode) {
+	slices.SortFunc(nodes, func(a, b *PciTreeNode) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	for _, node := range nodes {
+		sortPciTreeNodes(node.Children)
+	}
+}
+
+// WriteDOT writes the tree as a Graphviz digraph.
+func (t *PciTree) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph pci {\n\trankdir=LR;\n\tnode [shape=box];"); err != nil {
+		return err
+	}
+	for _, rootComplex := range t.RootComplexes {
+		if err := rootComplex.writeDOT(w); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func (n *PciTreeNode) writeDOT(w io.Writer) error {
+	label := n.Address
+	for _, line := range []string{n.ClassName, n.VendorName, n.DeviceName, n.Driver} {
+		if line != "" {
+			label += "\n" + line
+		}
+	}
+	if n.CurrentLinkSpeed != nil && n.CurrentLinkWidth != nil {
+		label += fmt.Sprintf("\n%g GT/s x%g", *n.CurrentLinkSpeed, *n.CurrentLinkWidth)
+	}
+	if _, err := fmt.Fprintf(w, "\t%q [label=%q];\n", n.Address, label); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		if _, err := fmt.Fprintf(w, "\t%q -> %q;\n", n.Address, child.Address); err != nil {
+			return err
+		}
+		if err := child.writeDOT(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePciTopologySysfs builds two sockets: a switch with a GPU and a NIC
+// plus a second NIC on another root port below pci0000:17, and a NIC below
+// pci0000:97 on the second socket.
+func writePciTopologySysfs(t *testing.T) string {
+	socket0 := map[string]string{"numa_node": "0"}
+	socket1 := map[string]string{"numa_node": "1"}
+	return writePciSysfs(t,
+		pciDeviceFiles("pci0000:17", "0000:17:01.0", "0x060400", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0", "0000:18:00.0", "0x060400", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0", "0000:19:00.0", "0x060400", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0", "0000:19:01.0", "0x060400", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0/0000:19:00.0", "0000:1a:00.0", "0x030200", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0/0000:19:01.0", "0000:1b:00.0", "0x020700", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:01.0/0000:18:00.0/0000:19:01.0", "0000:1b:00.2", "0x020000", map[string]string{
+			"numa_node": "0",
+			"physfn":    "->../0000:1b:00.0",
+		}),
+		pciDeviceFiles("pci0000:17", "0000:17:02.0", "0x060400", socket0),
+		pciDeviceFiles("pci0000:17/0000:17:02.0", "0000:2b:00.0", "0x020000", socket0),
+		pciDeviceFiles("pci0000:97", "0000:97:01.0", "0x060400", socket1),
+		pciDeviceFiles("pci0000:97/0000:97:01.0", "0000:9b:00.0", "0x020700", socket1),
+	)
+}
+
+func TestGetPciDevicesChain(t *testing.T) {
+	devices, err := GetPciDevices(writePciTopologySysfs(t))
+	require.NoError(t, err)
+
+	gpu := devices["0000:1a:00:0"]
+	assert.Equal(t, "pci0000:17", gpu.RootComplex)
+	assert.Equal(t, []PciDeviceLocation{
+		{Segment: 0, Bus: 0x19, Device: 0, Function: 0},
+		{Segment: 0, Bus: 0x18, Device: 0, Function: 0},
+		{Segment: 0, Bus: 0x17, Device: 1, Function: 0},
+	}, gpu.Ancestors)
+
+	rootPort := devices["0000:17:01:0"]
+	assert.Equal(t, "pci0000:17", rootPort.RootComplex)
+	assert.Empty(t, rootPort.Ancestors)
+}
+
+func TestGetPciAffinity(t *testing.T) {
+	devices, err := GetPciDevices(writePciTopologySysfs(t))
+	require.NoError(t, err)
+	gpu := devices["0000:1a:00:0"]
+
+	assert.Equal(t, PciAffinitySwitch, GetPciAffinity(gpu, devices["0000:1b:00:0"]))
+	assert.Equal(t, PciAffinityRootComplex, GetPciAffinity(gpu, devices["0000:2b:00:0"]))
+	assert.Equal(t, PciAffinityCrossSocket, GetPciAffinity(gpu, devices["0000:9b:00:0"]))
+
+	nic := devices["0000:9b:00:0"]
+	nic.NumaNode = nil
+	assert.Equal(t, PciAffinityNumaNode, GetPciAffinity(gpu, nic))
+}
+
+func TestGetPciAffinitySharedRootPort(t *testing.T) {
+	rootPort := PciDeviceLocation{Segment: 0, Bus: 0x17, Device: 1, Function: 0}
+	gpu := PciDevice{RootComplex: "pci0000:17", Ancestors: []PciDeviceLocation{rootPort}}
+	nic := PciDevice{RootComplex: "pci0000:17", Ancestors: []PciDeviceLocation{rootPort}}
+	assert.Equal(t, PciAffinityRootComplex, GetPciAffinity(gpu, nic))
+}
+
+func TestPciDeviceIsGPU(t *testing.T) {
+	assert.True(t, PciDevice{Class: 0x030200, Vendor: 0x10de}.isGPU())
+	assert.True(t, PciDevice{Class: 0x030000, Vendor: 0x10de}.isGPU())
+	assert.False(t, PciDevice{Class: 0x030000, Vendor: 0x1a03}.isGPU())
+	assert.False(t, PciDevice{Class: 0x030000, Vendor: 0x102b}.isGPU())
+	assert.False(t, PciDevice{Class: 0x020000, Vendor: 0x15b3}.isGPU())
+}
+
+func TestPCIDeviceCollectAffinity(t *testing.T) {
+	devices, err := GetPciDevices(writePciTopologySysfs(t))
+	require.NoError(t, err)
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectAffinity(ch, devices)
+	})
+	expected := `
+# HELP smc_pcidevice_gpu_nic_affinity PCIe path between a GPU and a NIC: switch, root_complex, numa_node or cross_socket, value is always 1.
+# TYPE smc_pcidevice_gpu_nic_affinity gauge
+smc_pcidevice_gpu_nic_affinity{gpu_bus="1a",gpu_device="00",gpu_function="0",gpu_segment="0000",nic_bus="1b",nic_device="00",nic_function="0",nic_segment="0000",path="switch"} 1
+smc_pcidevice_gpu_nic_affinity{gpu_bus="1a",gpu_device="00",gpu_function="0",gpu_segment="0000",nic_bus="2b",nic_device="00",nic_function="0",nic_segment="0000",path="root_complex"} 1
+smc_pcidevice_gpu_nic_affinity{gpu_bus="1a",gpu_device="00",gpu_function="0",gpu_segment="0000",nic_bus="9b",nic_device="00",nic_function="0",nic_segment="0000",path="cross_socket"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestPciTree(t *testing.T) {
+	c, err := NewPCIDeviceCollector("smc", PCIDeviceOptions{})
+	require.NoError(t, err)
+	tree, err := c.PciTree(writePciTopologySysfs(t))
+	require.NoError(t, err)
+
+	require.Len(t, tree.RootComplexes, 2)
+	rc := tree.RootComplexes[0]
+	assert.Equal(t, "pci0000:17", rc.Address)
+	require.Len(t, rc.Children, 2)
+	assert.Equal(t, "0000:17:01.0", rc.Children[0].Address)
+	assert.Equal(t, "0000:17:02.0", rc.Children[1].Address)
+
+	upstream := rc.Children[0].Children[0]
+	assert.Equal(t, "0000:18:00.0", upstream.Address)
+	require.Len(t, upstream.Children, 2)
+	assert.Equal(t, "0000:1a:00.0", upstream.Children[0].Children[0].Address)
+	assert.Equal(t, "0x030200", upstream.Children[0].Children[0].ClassID)
+	nics := upstream.Children[1].Children
+	require.Len(t, nics, 2)
+	assert.Equal(t, "0000:1b:00.0", nics[0].Address)
+	assert.Equal(t, "0000:1b:00.2", nics[1].Address)
+
+	assert.Equal(t, "pci0000:97", tree.RootComplexes[1].Address)
+}
+
+func TestPciTreeWriteDOT(t *testing.T) {
+	speed, width := 32.0, 16.0
+	tree := &PciTree{RootComplexes: []*PciTreeNode{{
+		Address: "pci0000:17",
+		Children: []*PciTreeNode{{
+			Address:          "0000:17:01.0",
+			ClassName:        "PCI bridge",
+			Driver:           "pcieport",
+			CurrentLinkSpeed: &speed,
+			CurrentLinkWidth: &width,
+		}},
+	}}}
+	var buf bytes.Buffer
+	require.NoError(t, tree.WriteDOT(&buf))
+	assert.Equal(t, `digraph pci {
+	rankdir=LR;
+	node [shape=box];
+	"pci0000:17" [label="pci0000:17"];
+	"pci0000:17" -> "0000:17:01.0";
+	"0000:17:01.0" [label="0000:17:01.0\nPCI bridge\npcieport\n32 GT/s x16"];
+}
+`, buf.String())
+}
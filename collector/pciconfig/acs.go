@@ -0,0 +1,87 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package pciconfig
+
+import "encoding/binary"
+
+// ACSFlags are the bits of the ACS Capability and ACS Control registers.
+type ACSFlags uint16
+
+const (
+	ACSSourceValidation      ACSFlags = 1 << 0
+	ACSTranslationBlocking   ACSFlags = 1 << 1
+	ACSP2PRequestRedirect    ACSFlags = 1 << 2
+	ACSP2PCompletionRedirect ACSFlags = 1 << 3
+	ACSUpstreamForwarding    ACSFlags = 1 << 4
+	ACSP2PEgressControl      ACSFlags = 1 << 5
+	ACSDirectTranslatedP2P   ACSFlags = 1 << 6
+)
+
+// ACSControls lists the ACS controls in register order.
+var ACSControls = []ACSFlags{
+	ACSSourceValidation,
+	ACSTranslationBlocking,
+	ACSP2PRequestRedirect,
+	ACSP2PCompletionRedirect,
+	ACSUpstreamForwarding,
+	ACSP2PEgressControl,
+	ACSDirectTranslatedP2P,
+}
+
+var acsNames = map[ACSFlags]string{
+	ACSSourceValidation:      "source_validation",
+	ACSTranslationBlocking:   "translation_blocking",
+	ACSP2PRequestRedirect:    "p2p_request_redirect",
+	ACSP2PCompletionRedirect: "p2p_completion_redirect",
+	ACSUpstreamForwarding:    "upstream_forwarding",
+	ACSP2PEgressControl:      "p2p_egress_control",
+	ACSDirectTranslatedP2P:   "direct_translated_p2p",
+}
+
+// String returns the name of a single control.
+func (f ACSFlags) String() string {
+	if name, ok := acsNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ACS is the Access Control Services extended capability. On switch ports
+// and root ports, P2P Request Redirect sends peer to peer traffic up to the
+// root complex, which cripples GPUDirect RDMA unless an IOMMU needs it.
+type ACS struct {
+	Capabilities ACSFlags // controls the port implements
+	Control      ACSFlags // controls that are enabled
+}
+
+// Enabled reports whether the control is implemented and enabled.
+func (a ACS) Enabled(flag ACSFlags) bool {
+	return a.Capabilities&a.Control&flag != 0
+}
+
+func decodeACS(config []byte, offset int) *ACS {
+	if offset+8 > len(config) {
+		return nil
+	}
+	return &ACS{
+		Capabilities: ACSFlags(binary.LittleEndian.Uint16(config[offset+4:]) & 0x7f),
+		Control:      ACSFlags(binary.LittleEndian.Uint16(config[offset+6:]) & 0x7f),
+	}
+}
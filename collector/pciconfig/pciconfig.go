@@ -0,0 +1,177 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package pciconfig decodes the configuration space of PCI devices, as read
+// from /sys/bus/pci/devices/<address>/config. It walks the capability list
+// and decodes the PCI Express capability and the ACS extended capability.
+package pciconfig
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// HeaderSize is the size of the standard configuration header. Without
+// CAP_SYS_ADMIN sysfs only returns the header.
+const HeaderSize = 64
+
+// ExtendedOffset is where the PCIe extended capabilities start.
+const ExtendedOffset = 0x100
+
+// ErrShortConfig is returned when the configuration header is truncated.
+var ErrShortConfig = errors.New("pciconfig: configuration header is short")
+
+// Capability IDs
+const (
+	CapabilityPM       = 0x01
+	CapabilityMSI      = 0x05
+	CapabilityVendor   = 0x09
+	CapabilityPCIe     = 0x10
+	CapabilityMSIX     = 0x11
+	ExtCapabilityAER   = 0x0001
+	ExtCapabilityACS   = 0x000d
+	ExtCapabilitySRIOV = 0x0010
+)
+
+// Standard header registers
+const (
+	regVendorID       = 0x00
+	regDeviceID       = 0x02
+	regStatus         = 0x06
+	regHeaderType     = 0x0e
+	regCapabilityList = 0x34
+
+	statusCapabilityList = 1 << 4
+)
+
+// PortType is the Device/Port Type of the PCI Express capability.
+type PortType uint8
+
+const (
+	PortTypeEndpoint         PortType = 0x0
+	PortTypeLegacyEndpoint   PortType = 0x1
+	PortTypeRCIEndpoint      PortType = 0x9
+	PortTypeRCEventCollector PortType = 0xa
+	PortTypeRootPort         PortType = 0x4
+	PortTypeUpstreamPort     PortType = 0x5
+	PortTypeDownstreamPort   PortType = 0x6
+	PortTypePCIeToPCIBridge  PortType = 0x7
+	PortTypePCIToPCIeBridge  PortType = 0x8
+)
+
+var portTypeNames = map[PortType]string{
+	PortTypeEndpoint:         "endpoint",
+	PortTypeLegacyEndpoint:   "legacy_endpoint",
+	PortTypeRCIEndpoint:      "rc_integrated_endpoint",
+	PortTypeRCEventCollector: "rc_event_collector",
+	PortTypeRootPort:         "root_port",
+	PortTypeUpstreamPort:     "upstream_port",
+	PortTypeDownstreamPort:   "downstream_port",
+	PortTypePCIeToPCIBridge:  "pcie_to_pci_bridge",
+	PortTypePCIToPCIeBridge:  "pci_to_pcie_bridge",
+}
+
+func (t PortType) String() string {
+	if name, ok := portTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Config is the decoded configuration space of a device.
+type Config struct {
+	VendorID   uint16
+	DeviceID   uint16
+	HeaderType uint8 // layout of the header, 0 for endpoints and 1 for bridges
+	// Capabilities are the IDs of the capabilities in list order.
+	Capabilities []uint8
+	// ExtendedCapabilities are the IDs of the extended capabilities in list
+	// order. Empty when only the first 256 bytes were read.
+	ExtendedCapabilities []uint16
+	// PCIe is nil for conventional PCI devices.
+	PCIe *PCIExpress
+	// ACS is nil when the device has no ACS capability or the extended
+	// configuration space was not read.
+	ACS *ACS
+}
+
+// Decode decodes a configuration space dump. It needs at least the 64 byte
+// header; capabilities that lie beyond the end of config are skipped.
+func Decode(config []byte) (*Config, error) {
+	if len(config) < HeaderSize {
+		return nil, ErrShortConfig
+	}
+	c := &Config{
+		VendorID:   binary.LittleEndian.Uint16(config[regVendorID:]),
+		DeviceID:   binary.LittleEndian.Uint16(config[regDeviceID:]),
+		HeaderType: config[regHeaderType] & 0x7f,
+	}
+
+	if binary.LittleEndian.Uint16(config[regStatus:])&statusCapabilityList != 0 {
+		c.walkCapabilities(config)
+	}
+	if len(config) > ExtendedOffset {
+		c.walkExtendedCapabilities(config)
+	}
+	return c, nil
+}
+
+// walkCapabilities follows the capability list starting at the
+// Capabilities Pointer.
+func (c *Config) walkCapabilities(config []byte) {
+	offset := int(config[regCapabilityList] &^ 0x3)
+	// The list fits in the first 256 bytes, 48 entries at most, which
+	// also stops loops in corrupt lists
+	for i := 0; i < 48 && offset >= HeaderSize && offset+2 <= len(config) && offset < ExtendedOffset; i++ {
+		id := config[offset]
+		c.Capabilities = append(c.Capabilities, id)
+		if id == CapabilityPCIe && c.PCIe == nil {
+			c.PCIe = decodePCIExpress(config, offset)
+		}
+		offset = int(config[offset+1] &^ 0x3)
+	}
+}
+
+// walkExtendedCapabilities follows the extended capability list at 0x100.
+func (c *Config) walkExtendedCapabilities(config []byte) {
+	offset := ExtendedOffset
+	// Each entry takes at least 4 bytes of the 3840 byte extended space
+	for i := 0; i < 960 && offset >= ExtendedOffset && offset+4 <= len(config); i++ {
+		header := binary.LittleEndian.Uint32(config[offset:])
+		if header == 0 || header == 0xffffffff {
+			return
+		}
+		id := uint16(header & 0xffff)
+		c.ExtendedCapabilities = append(c.ExtendedCapabilities, id)
+		if id == ExtCapabilityACS && c.ACS == nil {
+			c.ACS = decodeACS(config, offset)
+		}
+		offset = int(header>>20) &^ 0x3
+	}
+}
+
+// HasCapability reports whether the capability list contains id.
+func (c *Config) HasCapability(id uint8) bool {
+	for _, capability := range c.Capabilities {
+		if capability == id {
+			return true
+		}
+	}
+	return false
+}
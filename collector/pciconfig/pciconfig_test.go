@@ -0,0 +1,137 @@
+package pciconfig
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig builds a configuration space with a power management, a PCI
+// Express and an MSI-X capability, followed by AER and ACS extended
+// capabilities when size allows.
+func testConfig(size int, portType PortType) []byte {
+	config := make([]byte, size)
+	put16 := func(offset int, value uint16) { binary.LittleEndian.PutUint16(config[offset:], value) }
+	put32 := func(offset int, value uint32) { binary.LittleEndian.PutUint32(config[offset:], value) }
+
+	put16(regVendorID, 0x15b3)
+	put16(regDeviceID, 0x1021)
+	put16(regStatus, statusCapabilityList)
+	config[regHeaderType] = 0x80
+	config[regCapabilityList] = 0x40
+
+	// Power management at 0x40
+	config[0x40], config[0x41] = CapabilityPM, 0x60
+	// PCI Express at 0x60: v2, slot implemented for downstream ports
+	config[0x60], config[0x61] = CapabilityPCIe, 0xa0
+	capabilities := uint16(2) | uint16(portType)<<4
+	if portType == PortTypeDownstreamPort {
+		capabilities |= 1 << 8
+	}
+	put16(0x60+pcieCapabilities, capabilities)
+	// Max Payload Size Supported 512
+	put32(0x60+pcieDeviceCap, 0x2)
+	// MPS 256, MRRS 4096, relaxed ordering and extended tag
+	put16(0x60+pcieDeviceControl, 1<<5|5<<12|1<<4|1<<8)
+	// Correctable error and unsupported request detected
+	put16(0x60+pcieDeviceStatus, 1<<0|1<<3)
+	// 32 GT/s x16, L1 supported, DLL active reporting
+	put32(0x60+pcieLinkCap, 5|16<<4|1<<11|1<<20)
+	// L1 enabled
+	put16(0x60+pcieLinkControl, 1<<1)
+	// 16 GT/s x8, slot clock, DLL active
+	put16(0x60+pcieLinkStatus, 4|8<<4|1<<12|1<<13)
+	// Hot plug capable, 75 W, physical slot 7
+	put32(0x60+pcieSlotCap, 1<<6|75<<7|7<<19)
+	// MSI-X at 0xa0, end of list
+	config[0xa0], config[0xa1] = CapabilityMSIX, 0x00
+
+	if size > ExtendedOffset {
+		// AER at 0x100, ACS at 0x148
+		put32(0x100, ExtCapabilityAER|1<<16|0x148<<20)
+		put32(0x148, ExtCapabilityACS|1<<16)
+		put16(0x148+4, uint16(ACSSourceValidation|ACSP2PRequestRedirect|ACSUpstreamForwarding|ACSDirectTranslatedP2P))
+		put16(0x148+6, uint16(ACSSourceValidation|ACSP2PRequestRedirect|ACSP2PCompletionRedirect|ACSUpstreamForwarding))
+	}
+	return config
+}
+
+func TestDecodeEndpoint(t *testing.T) {
+	c, err := Decode(testConfig(4096, PortTypeEndpoint))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x15b3), c.VendorID)
+	assert.Equal(t, uint16(0x1021), c.DeviceID)
+	assert.Equal(t, uint8(0), c.HeaderType)
+	assert.Equal(t, []uint8{CapabilityPM, CapabilityPCIe, CapabilityMSIX}, c.Capabilities)
+	assert.Equal(t, []uint16{ExtCapabilityAER, ExtCapabilityACS}, c.ExtendedCapabilities)
+	assert.True(t, c.HasCapability(CapabilityMSIX))
+	assert.False(t, c.HasCapability(CapabilityMSI))
+
+	require.NotNil(t, c.PCIe)
+	pcie := c.PCIe
+	assert.Equal(t, uint8(2), pcie.Version)
+	assert.Equal(t, "endpoint", pcie.PortType.String())
+	assert.Equal(t, 512, pcie.MaxPayloadSupported)
+	assert.Equal(t, 256, pcie.MaxPayload)
+	assert.Equal(t, 4096, pcie.MaxReadRequest)
+	assert.True(t, pcie.RelaxedOrdering)
+	assert.True(t, pcie.ExtendedTag)
+	assert.False(t, pcie.NoSnoop)
+	assert.Equal(t, DeviceStatus{CorrectableError: true, UnsupportedRequest: true}, pcie.DeviceStatus)
+	assert.Equal(t, 32.0, pcie.MaxLinkSpeed)
+	assert.Equal(t, 16, pcie.MaxLinkWidth)
+	assert.True(t, pcie.DLLActiveReporting)
+	assert.Equal(t, ASPM{L1: true}, pcie.ASPMSupport)
+	assert.Equal(t, ASPM{L1: true}, pcie.ASPM)
+	assert.Equal(t, LinkStatus{Speed: 16, Width: 8, SlotClock: true, DLLActive: true}, pcie.LinkStatus)
+	assert.Nil(t, pcie.Slot)
+
+	require.NotNil(t, c.ACS)
+	assert.True(t, c.ACS.Enabled(ACSSourceValidation))
+	assert.True(t, c.ACS.Enabled(ACSP2PRequestRedirect))
+	assert.False(t, c.ACS.Enabled(ACSTranslationBlocking))
+	// Enabled but not implemented
+	assert.False(t, c.ACS.Enabled(ACSP2PCompletionRedirect))
+	assert.Equal(t, "p2p_request_redirect", ACSP2PRequestRedirect.String())
+}
+
+func TestDecodeDownstreamPortSlot(t *testing.T) {
+	c, err := Decode(testConfig(4096, PortTypeDownstreamPort))
+	require.NoError(t, err)
+	require.NotNil(t, c.PCIe)
+	assert.Equal(t, "downstream_port", c.PCIe.PortType.String())
+	assert.Equal(t, &SlotCapabilities{HotPlugCapable: true, PowerLimitWatts: 75, PhysicalSlot: 7}, c.PCIe.Slot)
+}
+
+func TestDecodeWithoutExtendedSpace(t *testing.T) {
+	// Only the first 256 bytes are readable on conventional PCI devices
+	c, err := Decode(testConfig(256, PortTypeEndpoint))
+	require.NoError(t, err)
+	require.NotNil(t, c.PCIe)
+	assert.Empty(t, c.ExtendedCapabilities)
+	assert.Nil(t, c.ACS)
+}
+
+func TestDecodeHeaderOnly(t *testing.T) {
+	// Without CAP_SYS_ADMIN sysfs only returns the header
+	c, err := Decode(testConfig(256, PortTypeEndpoint)[:HeaderSize])
+	require.NoError(t, err)
+	assert.Empty(t, c.Capabilities)
+	assert.Nil(t, c.PCIe)
+}
+
+func TestDecodeShort(t *testing.T) {
+	_, err := Decode(make([]byte, 32))
+	assert.ErrorIs(t, err, ErrShortConfig)
+}
+
+func TestDecodeCapabilityLoop(t *testing.T) {
+	config := testConfig(256, PortTypeEndpoint)
+	// MSI-X points back to power management
+	config[0xa1] = 0x40
+	c, err := Decode(config)
+	require.NoError(t, err)
+	assert.Len(t, c.Capabilities, 48)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package pciconfig
+
+import "encoding/binary"
+
+// PCI Express capability registers, relative to the capability
+const (
+	pcieCapabilities  = 0x02
+	pcieDeviceCap     = 0x04
+	pcieDeviceControl = 0x08
+	pcieDeviceStatus  = 0x0a
+	pcieLinkCap       = 0x0c
+	pcieLinkControl   = 0x10
+	pcieLinkStatus    = 0x12
+	pcieSlotCap       = 0x14
+	pcieSlotCapEnd    = 0x18
+)
+
+// linkSpeeds maps the Supported/Current Link Speed encoding to GT/s.
+var linkSpeeds = map[uint32]float64{
+	1: 2.5,
+	2: 5,
+	3: 8,
+	4: 16,
+	5: 32,
+	6: 64,
+}
+
+// PCIExpress is the decoded PCI Express capability.
+type PCIExpress struct {
+	Version         uint8
+	PortType        PortType
+	SlotImplemented bool
+
+	// Sizes in bytes
+	MaxPayloadSupported int
+	MaxPayload          int
+	MaxReadRequest      int
+
+	RelaxedOrdering bool
+	NoSnoop         bool
+	ExtendedTag     bool
+
+	DeviceStatus DeviceStatus
+
+	MaxLinkSpeed float64 // GT/s, 0 if unknown
+	MaxLinkWidth int
+	// DLLActiveReporting is set when LinkStatus.DLLActive is meaningful.
+	DLLActiveReporting bool
+	ASPMSupport        ASPM
+	ASPM               ASPM // enabled ASPM states
+	LinkStatus         LinkStatus
+
+	// Slot is nil unless the port implements a slot.
+	Slot *SlotCapabilities
+}
+
+// DeviceStatus holds the error bits of the Device Status register. They are
+// set when the device detects an error and cleared by software.
+type DeviceStatus struct {
+	CorrectableError    bool
+	NonFatalError       bool
+	FatalError          bool
+	UnsupportedRequest  bool
+	AuxPower            bool
+	TransactionsPending bool
+}
+
+// ASPM is a set of Active State Power Management states.
+type ASPM struct {
+	L0s bool
+	L1  bool
+}
+
+// LinkStatus is the Link Status register.
+type LinkStatus struct {
+	Speed               float64 // GT/s, 0 if unknown
+	Width               int
+	Training            bool
+	SlotClock           bool
+	DLLActive           bool
+	BandwidthManagement bool
+	AutonomousBandwidth bool
+}
+
+// SlotCapabilities is the Slot Capabilities register of a downstream or
+// root port.
+type SlotCapabilities struct {
+	AttentionButton    bool
+	PowerController    bool
+	MRLSensor          bool
+	AttentionIndicator bool
+	PowerIndicator     bool
+	HotPlugSurprise    bool
+	HotPlugCapable     bool
+	PowerLimitWatts    float64
+	PhysicalSlot       int
+}
+
+func decodePCIExpress(config []byte, offset int) *PCIExpress {
+	// Registers past the end of the dump read as zero
+	reg16 := func(reg int) uint16 {
+		if offset+reg+2 > len(config) {
+			return 0
+		}
+		return binary.LittleEndian.Uint16(config[offset+reg:])
+	}
+	reg32 := func(reg int) uint32 {
+		if offset+reg+4 > len(config) {
+			return 0
+		}
+		return binary.LittleEndian.Uint32(config[offset+reg:])
+	}
+
+	capabilities := reg16(pcieCapabilities)
+	deviceCap := reg32(pcieDeviceCap)
+	deviceControl := reg16(pcieDeviceControl)
+	deviceStatus := reg16(pcieDeviceStatus)
+	linkCap := reg32(pcieLinkCap)
+	linkControl := reg16(pcieLinkControl)
+	linkStatus := reg16(pcieLinkStatus)
+
+	pcie := &PCIExpress{
+		Version:         uint8(capabilities & 0xf),
+		PortType:        PortType((capabilities >> 4) & 0xf),
+		SlotImplemented: capabilities&(1<<8) != 0,
+
+		MaxPayloadSupported: 128 << (deviceCap & 0x7),
+		MaxPayload:          128 << ((deviceControl >> 5) & 0x7),
+		MaxReadRequest:      128 << ((deviceControl >> 12) & 0x7),
+
+		RelaxedOrdering: deviceControl&(1<<4) != 0,
+		ExtendedTag:     deviceControl&(1<<8) != 0,
+		NoSnoop:         deviceControl&(1<<11) != 0,
+
+		DeviceStatus: DeviceStatus{
+			CorrectableError:    deviceStatus&(1<<0) != 0,
+			NonFatalError:       deviceStatus&(1<<1) != 0,
+			FatalError:          deviceStatus&(1<<2) != 0,
+			UnsupportedRequest:  deviceStatus&(1<<3) != 0,
+			AuxPower:            deviceStatus&(1<<4) != 0,
+			TransactionsPending: deviceStatus&(1<<5) != 0,
+		},
+
+		MaxLinkSpeed:       linkSpeeds[linkCap&0xf],
+		MaxLinkWidth:       int((linkCap >> 4) & 0x3f),
+		DLLActiveReporting: linkCap&(1<<20) != 0,
+		ASPMSupport: ASPM{
+			L0s: linkCap&(1<<10) != 0,
+			L1:  linkCap&(1<<11) != 0,
+		},
+		ASPM: ASPM{
+			L0s: linkControl&(1<<0) != 0,
+			L1:  linkControl&(1<<1) != 0,
+		},
+		LinkStatus: LinkStatus{
+			Speed:               linkSpeeds[uint32(linkStatus&0xf)],
+			Width:               int((linkStatus >> 4) & 0x3f),
+			Training:            linkStatus&(1<<11) != 0,
+			SlotClock:           linkStatus&(1<<12) != 0,
+			DLLActive:           linkStatus&(1<<13) != 0,
+			BandwidthManagement: linkStatus&(1<<14) != 0,
+			AutonomousBandwidth: linkStatus&(1<<15) != 0,
+		},
+	}
+
+	if pcie.SlotImplemented && offset+pcieSlotCapEnd <= len(config) {
+		slotCap := reg32(pcieSlotCap)
+		// The power limit value is scaled by 1, 0.1, 0.01 or 0.001
+		scale := [...]float64{1, 0.1, 0.01, 0.001}[(slotCap>>15)&0x3]
+		pcie.Slot = &SlotCapabilities{
+			AttentionButton:    slotCap&(1<<0) != 0,
+			PowerController:    slotCap&(1<<1) != 0,
+			MRLSensor:          slotCap&(1<<2) != 0,
+			AttentionIndicator: slotCap&(1<<3) != 0,
+			PowerIndicator:     slotCap&(1<<4) != 0,
+			HotPlugSurprise:    slotCap&(1<<5) != 0,
+			HotPlugCapable:     slotCap&(1<<6) != 0,
+			PowerLimitWatts:    float64((slotCap>>7)&0xff) * scale,
+			PhysicalSlot:       int(slotCap >> 19),
+		}
+	}
+	return pcie
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// collectErrorKey identifies what failed: the collector and the device it
+// was working on, empty when the whole collection failed.
+type collectErrorKey struct {
+	collector string
+	device    string
+}
+
+var (
+	collectErrorsMu sync.Mutex
+	collectErrors   = map[collectErrorKey]float64{}
+)
+
+// countCollectError counts a failure of collector on device.
+func countCollectError(collector, device string) {
+	collectErrorsMu.Lock()
+	defer collectErrorsMu.Unlock()
+	collectErrors[collectErrorKey{collector, device}]++
+}
+
+// recoverCollect recovers from a panic of collector while working on device,
+// logs it with its stack and counts it, so that one malformed input does not
+// take the exporter down. It must be deferred directly.
+func recoverCollect(collector, device string) {
+	if r := recover(); r != nil {
+		log.Errorf("Recovered from panic in %s collector for device %q: %v\n%s", collector, device, r, debug.Stack())
+		countCollectError(collector, device)
+	}
+}
+
+// collectDevice runs f for one device of collector and reports whether it
+// completed. A panic in f is recovered and counted for that device, so the
+// collector carries on with the others.
+func collectDevice(collector, device string, f func()) (ok bool) {
+	defer recoverCollect(collector, device)
+	f()
+	return true
+}
+
+// sendConstMetric sends a const metric to ch. A metric that cannot be
+// created, eg. because of a label value that is not valid UTF-8, is logged
+// and skipped instead of panicking or failing the whole scrape.
+func sendConstMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	metric, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	if err != nil {
+		log.Errorf("Error creating metric %s: %s", desc, err)
+		return
+	}
+	ch <- metric
+}
+
+// CollectErrorsCollector exports the failures the other collectors recovered
+// from.
+type CollectErrorsCollector struct {
+	errorsDesc *prometheus.Desc
+}
+
+func NewCollectErrorsCollector(namespace string) *CollectErrorsCollector {
+	return &CollectErrorsCollector{
+		errorsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "collect_errors_total"),
+			"Failures recovered from while collecting, by collector and device. The device is empty when the whole collection failed.",
+			[]string{"collector", "device"}, nil,
+		),
+	}
+}
+
+func (c *CollectErrorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.errorsDesc
+}
+
+func (c *CollectErrorsCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrorsMu.Lock()
+	defer collectErrorsMu.Unlock()
+	for key, count := range collectErrors {
+		sendConstMetric(ch, c.errorsDesc, prometheus.CounterValue, count, key.collector, key.device)
+	}
+}
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetCollectErrors clears the collect errors for the test.
+func resetCollectErrors(t *testing.T) {
+	collectErrorsMu.Lock()
+	defer collectErrorsMu.Unlock()
+	collectErrors = map[collectErrorKey]float64{}
+	t.Cleanup(func() {
+		collectErrorsMu.Lock()
+		defer collectErrorsMu.Unlock()
+		collectErrors = map[collectErrorKey]float64{}
+	})
+}
+
+func TestCollectDeviceRecovers(t *testing.T) {
+	resetCollectErrors(t)
+	desc := prometheus.NewDesc("smc_test", "Test.", []string{"device"}, nil)
+	ch := make(chan prometheus.Metric, 3)
+	var collected []string
+	for _, device := range []string{"mlx5_0", "mlx5_1", "mlx5_2"} {
+		ok := collectDevice("test", device, func() {
+			if device == "mlx5_1" {
+				var values []float64
+				sendConstMetric(ch, desc, prometheus.GaugeValue, values[1], device)
+			}
+			sendConstMetric(ch, desc, prometheus.GaugeValue, 1, device)
+		})
+		if ok {
+			collected = append(collected, device)
+		}
+	}
+	assert.Equal(t, []string{"mlx5_0", "mlx5_2"}, collected)
+	assert.Len(t, ch, 2)
+
+	expected := `
+# HELP smc_collect_errors_total Failures recovered from while collecting, by collector and device. The device is empty when the whole collection failed.
+# TYPE smc_collect_errors_total counter
+smc_collect_errors_total{collector="test",device="mlx5_1"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewCollectErrorsCollector("smc"), strings.NewReader(expected)))
+}
+
+func TestSendConstMetricInvalid(t *testing.T) {
+	desc := prometheus.NewDesc("smc_test", "Test.", []string{"device"}, nil)
+	ch := make(chan prometheus.Metric, 2)
+	sendConstMetric(ch, desc, prometheus.GaugeValue, 1)
+	sendConstMetric(ch, desc, prometheus.GaugeValue, 1, "\xff")
+	assert.Empty(t, ch)
+}
+
+func TestGetPciDevicesSkipsBrokenDevice(t *testing.T) {
+	resetCollectErrors(t)
+	root := writePciTopologySysfs(t)
+	require.NoError(t, os.Symlink("../../../devices/pci0000:17/garbage", filepath.Join(root, pciDevicesPath, "garbage")))
+
+	devices, err := GetPciDevices(root)
+	require.NoError(t, err)
+	assert.Contains(t, devices, "0000:1b:00:0")
+	assert.NotContains(t, devices, "garbage")
+	assert.Equal(t, float64(1), collectErrors[collectErrorKey{"pcidevice", "garbage"}])
+}
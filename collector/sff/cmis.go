@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sff
+
+// CMIS lower page
+const (
+	cmisModuleState    = 3
+	cmisModuleFlags    = 9
+	cmisTemperature    = 14
+	cmisVoltage        = 16
+	cmisAppDescriptor  = 86
+	cmisMaxLanes       = 8
+	cmisModuleStateBit = 1
+)
+
+// CMIS upper page 00h
+const (
+	cmisVendorName      = 129
+	cmisVendorOUI       = 145
+	cmisPartNumber      = 148
+	cmisRevision        = 164
+	cmisSerialNumber    = 166
+	cmisDateCode        = 182
+	cmisMediaTechnology = 212
+
+	// Media interface technology values of copper cables start at 0x0a
+	cmisCopperMedia = 0x0a
+)
+
+// CMIS upper page 01h
+const (
+	cmisWavelength  = 138
+	cmisBiasScaling = 160
+)
+
+// CMIS upper page 02h
+const (
+	cmisTempThresholds    = 128
+	cmisVoltThresholds    = 136
+	cmisTxPowerThresholds = 176
+	cmisBiasThresholds    = 184
+	cmisRxPowerThresholds = 192
+)
+
+// CMIS upper page 11h, bank 0
+const (
+	cmisDataPathState = 128
+	cmisTxFault       = 135
+	cmisTxLOS         = 136
+	cmisTxLOL         = 137
+	cmisTxPowerFlags  = 139
+	cmisBiasFlags     = 143
+	cmisRxLOS         = 147
+	cmisRxLOL         = 148
+	cmisRxPowerFlags  = 149
+	cmisTxPower       = 154
+	cmisBias          = 170
+	cmisRxPower       = 186
+)
+
+func decodeCMIS(m *Memory) *Module {
+	lower := m.Lower
+	module := &Module{
+		Identifier:  Identifier(lower[0]),
+		ModuleState: ModuleState(lower[cmisModuleState] >> cmisModuleStateBit & 0x7),
+		Lanes:       cmisLanes(m),
+	}
+	// Temperature flags are in bits 3-0 and voltage flags in bits 7-4,
+	// each from high alarm in the lowest bit to low warning.
+	module.TemperatureFlags = cmisFlags(lower[cmisModuleFlags])
+	module.VoltageFlags = cmisFlags(lower[cmisModuleFlags] >> 4)
+
+	page0 := m.page(0x00)
+	if page0 != nil {
+		module.Vendor = readString(page0[cmisVendorName : cmisVendorName+16])
+		module.VendorOUI = readOUI(page0[cmisVendorOUI:])
+		module.PartNumber = readString(page0[cmisPartNumber : cmisPartNumber+16])
+		module.Revision = readString(page0[cmisRevision : cmisRevision+2])
+		module.SerialNumber = readString(page0[cmisSerialNumber : cmisSerialNumber+16])
+		module.DateCode = readString(page0[cmisDateCode : cmisDateCode+8])
+	}
+
+	biasScale := 1.0
+	if page1 := m.page(0x01); page1 != nil {
+		if page0 == nil || page0[cmisMediaTechnology] < cmisCopperMedia {
+			module.Wavelength = float64(readUint16(page1, cmisWavelength)) / 20
+		}
+		// 0: x1, 1: x2, 2: x4
+		biasScale = float64(int(1) << (page1[cmisBiasScaling] >> 3 & 0x3))
+	}
+	readScaledBias := func(b []byte, offset int) float64 {
+		return readBias(b, offset) * biasScale
+	}
+
+	diagnostics := &Diagnostics{
+		Temperature: readTemperature(lower, cmisTemperature),
+		Voltage:     readVoltage(lower, cmisVoltage),
+	}
+	module.Diagnostics = diagnostics
+
+	if page2 := m.page(0x02); page2 != nil {
+		module.Thresholds = &Thresholds{
+			Temperature: readThreshold(page2, cmisTempThresholds, readTemperature),
+			Voltage:     readThreshold(page2, cmisVoltThresholds, readVoltage),
+			Bias:        readThreshold(page2, cmisBiasThresholds, readScaledBias),
+			TxPower:     readThreshold(page2, cmisTxPowerThresholds, readPower),
+			RxPower:     readThreshold(page2, cmisRxPowerThresholds, readPower),
+		}
+	}
+
+	if page11 := m.page(0x11); page11 != nil {
+		for lane := 0; lane < module.Lanes; lane++ {
+			state := page11[cmisDataPathState+lane/2]
+			if lane%2 == 1 {
+				state >>= 4
+			}
+			module.DataPathState = append(module.DataPathState, DataPathState(state&0xf))
+
+			diagnostics.TxPower = append(diagnostics.TxPower, readPower(page11, cmisTxPower+2*lane))
+			diagnostics.Bias = append(diagnostics.Bias, readScaledBias(page11, cmisBias+2*lane))
+			diagnostics.RxPower = append(diagnostics.RxPower, readPower(page11, cmisRxPower+2*lane))
+
+			bit := func(offset int) bool {
+				return page11[offset]&(1<<lane) != 0
+			}
+			laneFlags := func(offset int) Flags {
+				return Flags{
+					HighAlarm:   bit(offset),
+					LowAlarm:    bit(offset + 1),
+					HighWarning: bit(offset + 2),
+					LowWarning:  bit(offset + 3),
+				}
+			}
+			module.LaneFlags = append(module.LaneFlags, LaneFlags{
+				RxLOS:   bit(cmisRxLOS),
+				TxLOS:   bit(cmisTxLOS),
+				TxFault: bit(cmisTxFault),
+				RxLOL:   bit(cmisRxLOL),
+				TxLOL:   bit(cmisTxLOL),
+				Bias:    laneFlags(cmisBiasFlags),
+				TxPower: laneFlags(cmisTxPowerFlags),
+				RxPower: laneFlags(cmisRxPowerFlags),
+			})
+		}
+	}
+
+	module.VDM = decodeVDM(m)
+	diagnostics.SNRMedia = vdmPerLane(module.VDM, VDMESNRMedia, module.Lanes)
+	diagnostics.SNRHost = vdmPerLane(module.VDM, VDMESNRHost, module.Lanes)
+	return module
+}
+
+// cmisLanes returns the media lane count of the first application
+// advertised in the lower page.
+func cmisLanes(m *Memory) int {
+	lanes := int(m.Lower[cmisAppDescriptor+2] & 0xf)
+	if lanes == 0 || lanes > cmisMaxLanes {
+		return cmisMaxLanes
+	}
+	return lanes
+}
+
+func cmisFlags(b byte) Flags {
+	return Flags{
+		HighAlarm:   b&0x1 != 0,
+		LowAlarm:    b&0x2 != 0,
+		HighWarning: b&0x4 != 0,
+		LowWarning:  b&0x8 != 0,
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package sff decodes the memory map of pluggable transceivers: SFP modules
+// (SFF-8472), QSFP modules (SFF-8636 and SFF-8436) and CMIS modules such as
+// QSFP-DD, OSFP and QSFP112.
+package sff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// PageSize is the size of the lower page and of every upper page.
+const PageSize = 128
+
+// ErrShortMemory is returned when the lower page is missing or truncated.
+var ErrShortMemory = errors.New("sff: lower page is missing or short")
+
+// Identifier is the SFF-8024 module identifier in byte 0.
+type Identifier uint8
+
+const (
+	IdentifierUnknown     Identifier = 0x00
+	IdentifierSFP         Identifier = 0x03
+	IdentifierQSFP        Identifier = 0x0c
+	IdentifierQSFPPlus    Identifier = 0x0d
+	IdentifierQSFP28      Identifier = 0x11
+	IdentifierQSFPDD      Identifier = 0x18
+	IdentifierOSFP        Identifier = 0x19
+	IdentifierDSFP        Identifier = 0x1b
+	IdentifierQSFPCMIS    Identifier = 0x1e
+	IdentifierSFPDDCMIS   Identifier = 0x1f
+	IdentifierSFPPlusCMIS Identifier = 0x20
+)
+
+var identifierNames = map[Identifier]string{
+	IdentifierUnknown:     "Unknown",
+	IdentifierSFP:         "SFP",
+	IdentifierQSFP:        "QSFP",
+	IdentifierQSFPPlus:    "QSFP+",
+	IdentifierQSFP28:      "QSFP28",
+	IdentifierQSFPDD:      "QSFP-DD",
+	IdentifierOSFP:        "OSFP",
+	IdentifierDSFP:        "DSFP",
+	IdentifierQSFPCMIS:    "QSFP_CMIS",
+	IdentifierSFPDDCMIS:   "SFP-DD_CMIS",
+	IdentifierSFPPlusCMIS: "SFP+_CMIS",
+}
+
+func (i Identifier) String() string {
+	if name, ok := identifierNames[i]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(i))
+}
+
+// IsCMIS reports whether modules with this identifier use the CMIS memory
+// map.
+func (i Identifier) IsCMIS() bool {
+	switch i {
+	case IdentifierQSFPDD, IdentifierOSFP, IdentifierDSFP, IdentifierQSFPCMIS, IdentifierSFPDDCMIS, IdentifierSFPPlusCMIS:
+		return true
+	}
+	return false
+}
+
+// ModuleState is the CMIS module state. The values match the ones mlxlink
+// reports.
+type ModuleState uint8
+
+const (
+	ModuleStateUnknown ModuleState = 0
+	ModuleLowPwr       ModuleState = 1
+	ModulePwrUp        ModuleState = 2
+	ModuleReady        ModuleState = 3
+	ModulePwrDn        ModuleState = 4
+	ModuleFault        ModuleState = 5
+)
+
+var moduleStateNames = []string{"Unknown", "ModuleLowPwr", "ModulePwrUp", "ModuleReady", "ModulePwrDn", "ModuleFault"}
+
+func (s ModuleState) String() string {
+	if int(s) < len(moduleStateNames) {
+		return moduleStateNames[s]
+	}
+	return fmt.Sprintf("Reserved(%d)", uint8(s))
+}
+
+// DataPathState is the CMIS state of the data path a lane belongs to.
+type DataPathState uint8
+
+const (
+	DataPathStateUnknown DataPathState = 0
+	DPDeactivated        DataPathState = 1
+	DPInit               DataPathState = 2
+	DPDeinit             DataPathState = 3
+	DPActivated          DataPathState = 4
+	DPTxTurnOn           DataPathState = 5
+	DPTxTurnOff          DataPathState = 6
+	DPInitialized        DataPathState = 7
+)
+
+var dataPathStateNames = []string{"Unknown", "DPDeactivated", "DPInit", "DPDeinit", "DPActivated", "DPTxTurnOn", "DPTxTurnOff", "DPInitialized"}
+
+func (s DataPathState) String() string {
+	if int(s) < len(dataPathStateNames) {
+		return dataPathStateNames[s]
+	}
+	return fmt.Sprintf("Reserved(%d)", uint8(s))
+}
+
+// Threshold holds the alarm and warning levels of one measurement, in the
+// unit of the measurement.
+type Threshold struct {
+	HighAlarm   float64
+	LowAlarm    float64
+	HighWarning float64
+	LowWarning  float64
+}
+
+// Thresholds are the vendor alarm and warning levels. Power is in mW,
+// bias current in mA, temperature in degrees celsius and voltage in volts.
+type Thresholds struct {
+	Temperature Threshold
+	Voltage     Threshold
+	Bias        Threshold
+	TxPower     Threshold
+	RxPower     Threshold
+}
+
+// Flags are the latched alarm and warning flags of one measurement.
+type Flags struct {
+	HighAlarm   bool
+	LowAlarm    bool
+	HighWarning bool
+	LowWarning  bool
+}
+
+// LaneFlags are the latched flags of a single lane.
+type LaneFlags struct {
+	RxLOS   bool
+	TxLOS   bool
+	TxFault bool
+	RxLOL   bool
+	TxLOL   bool
+	Bias    Flags
+	TxPower Flags
+	RxPower Flags
+}
+
+// Diagnostics are the digital diagnostic monitoring (DDM) readings. Power
+// is in mW, bias current in mA, temperature in degrees celsius, voltage in
+// volts and SNR in dB. Per lane slices are indexed by lane - 1.
+type Diagnostics struct {
+	Temperature float64
+	Voltage     float64
+	Bias        []float64
+	TxPower     []float64
+	RxPower     []float64
+	SNRMedia    []float64
+	SNRHost     []float64
+}
+
+// Module is a decoded module memory map. Fields the module does not
+// implement are left at their zero value.
+type Module struct {
+	Identifier   Identifier
+	Vendor       string
+	VendorOUI    string
+	PartNumber   string
+	Revision     string
+	SerialNumber string
+	DateCode     string
+	// Wavelength is the nominal wavelength in nm, zero for copper.
+	Wavelength float64
+	Lanes      int
+
+	Diagnostics      *Diagnostics
+	Thresholds       *Thresholds
+	TemperatureFlags Flags
+	VoltageFlags     Flags
+	LaneFlags        []LaneFlags
+
+	// CMIS only
+	ModuleState   ModuleState
+	DataPathState []DataPathState
+	VDM           []VDMObservable
+}
+
+// Memory holds the raw pages read from a module. Upper pages are keyed by
+// page number and only bank 0 is used. For SFP modules page 00h is the
+// upper half of address A0h and Diagnostics holds the 256 bytes at A2h.
+type Memory struct {
+	Lower       []byte
+	Upper       map[uint8][]byte
+	Diagnostics []byte
+}
+
+// page returns the lower page followed by upper page n, so the offsets
+// used in the specifications index it directly. It returns nil when page n
+// was not read.
+func (m *Memory) page(n uint8) []byte {
+	upper := m.Upper[n]
+	if len(upper) < PageSize {
+		return nil
+	}
+	view := make([]byte, 0, 2*PageSize)
+	view = append(view, m.Lower[:PageSize]...)
+	return append(view, upper[:PageSize]...)
+}
+
+// Decode decodes the memory of any supported module type.
+func Decode(m *Memory) (*Module, error) {
+	if len(m.Lower) < PageSize {
+		return nil, ErrShortMemory
+	}
+	id := Identifier(m.Lower[0])
+	switch {
+	case id == IdentifierSFP:
+		return decodeSFF8472(m), nil
+	case id == IdentifierQSFP || id == IdentifierQSFPPlus || id == IdentifierQSFP28:
+		return decodeSFF8636(m), nil
+	case id.IsCMIS():
+		return decodeCMIS(m), nil
+	}
+	return nil, fmt.Errorf("sff: unsupported identifier %s", id)
+}
+
+// Module types reported by the ETHTOOL_GMODULEINFO ioctl
+const (
+	EthtoolSFF8079 = 0x1
+	EthtoolSFF8472 = 0x2
+	EthtoolSFF8636 = 0x3
+	EthtoolSFF8436 = 0x4
+)
+
+// FromEthtool splits a flat EEPROM dump returned by ETHTOOL_GMODULEEEPROM
+// into pages. SFF-8079 and SFF-8472 dumps hold A0h followed by A2h,
+// SFF-8636 and SFF-8436 dumps the lower page, page 00h and, when 640 bytes
+// long, pages 01h to 03h.
+func FromEthtool(moduleType uint32, eeprom []byte) (*Memory, error) {
+	if len(eeprom) < 2*PageSize {
+		return nil, ErrShortMemory
+	}
+	m := &Memory{Lower: eeprom[:PageSize], Upper: map[uint8][]byte{0: eeprom[PageSize : 2*PageSize]}}
+	switch moduleType {
+	case EthtoolSFF8079, EthtoolSFF8472:
+		if len(eeprom) >= 4*PageSize {
+			m.Diagnostics = eeprom[2*PageSize : 4*PageSize]
+		}
+	case EthtoolSFF8636, EthtoolSFF8436:
+		for page := uint8(1); page <= 3 && len(eeprom) >= int(page+2)*PageSize; page++ {
+			m.Upper[page] = eeprom[int(page+1)*PageSize : int(page+2)*PageSize]
+		}
+	default:
+		return nil, fmt.Errorf("sff: unsupported ethtool module type %d", moduleType)
+	}
+	return m, nil
+}
+
+// ReadPageDump parses a page dump: the lower page followed by any number of
+// upper pages, each prefixed with its page number.
+func ReadPageDump(data []byte) (*Memory, error) {
+	if len(data) < PageSize {
+		return nil, ErrShortMemory
+	}
+	m := &Memory{Lower: data[:PageSize], Upper: map[uint8][]byte{}}
+	data = data[PageSize:]
+	for len(data) > 0 {
+		if len(data) < PageSize+1 {
+			return nil, fmt.Errorf("sff: truncated page %02xh", data[0])
+		}
+		m.Upper[data[0]] = data[1 : PageSize+1]
+		data = data[PageSize+1:]
+	}
+	return m, nil
+}
+
+// MilliwattsToDbm converts optical power to dBm. Like ethtool and mlxlink
+// no light is reported as -40 dBm.
+func MilliwattsToDbm(mw float64) float64 {
+	if mw <= 0 {
+		return -40
+	}
+	return 10 * math.Log10(mw)
+}
+
+func readString(b []byte) string {
+	s := strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+	if !utf8.ValidString(s) {
+		return "unknown"
+	}
+	return s
+}
+
+func readOUI(b []byte) string {
+	return fmt.Sprintf("%02x:%02x:%02x", b[0], b[1], b[2])
+}
+
+func readUint16(b []byte, offset int) uint16 {
+	return binary.BigEndian.Uint16(b[offset : offset+2])
+}
+
+// Temperature is a signed 1/256 degree celsius value
+func readTemperature(b []byte, offset int) float64 {
+	return float64(int16(readUint16(b, offset))) / 256
+}
+
+// Voltage is in units of 100 uV
+func readVoltage(b []byte, offset int) float64 {
+	return float64(readUint16(b, offset)) / 10000
+}
+
+// Bias current is in units of 2 uA
+func readBias(b []byte, offset int) float64 {
+	return float64(readUint16(b, offset)) * 0.002
+}
+
+// Optical power is in units of 0.1 uW
+func readPower(b []byte, offset int) float64 {
+	return float64(readUint16(b, offset)) / 10000
+}
+
+// readThreshold reads the four values of a threshold in the order used by
+// every memory map: high alarm, low alarm, high warning, low warning.
+func readThreshold(b []byte, offset int, read func([]byte, int) float64) Threshold {
+	return Threshold{
+		HighAlarm:   read(b, offset),
+		LowAlarm:    read(b, offset+2),
+		HighWarning: read(b, offset+4),
+		LowWarning:  read(b, offset+6),
+	}
+}
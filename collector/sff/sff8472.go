@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sff
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// SFF-8472 address A0h
+const (
+	sff8472VendorName   = 20
+	sff8472VendorOUI    = 37
+	sff8472PartNumber   = 40
+	sff8472Revision     = 56
+	sff8472Wavelength   = 60
+	sff8472SerialNumber = 68
+	sff8472DateCode     = 84
+	sff8472DiagType     = 92
+
+	sff8472DDMImplemented     = 1 << 6
+	sff8472ExternalCalibrated = 1 << 4
+)
+
+// SFF-8472 address A2h
+const (
+	sff8472TempThresholds    = 0
+	sff8472VoltThresholds    = 8
+	sff8472BiasThresholds    = 16
+	sff8472TxPowerThresholds = 24
+	sff8472RxPowerThresholds = 32
+	sff8472RxPowerCal        = 56
+	sff8472BiasCal           = 76
+	sff8472TxPowerCal        = 80
+	sff8472TempCal           = 84
+	sff8472VoltCal           = 88
+	sff8472Temperature       = 96
+	sff8472Voltage           = 98
+	sff8472Bias              = 100
+	sff8472TxPower           = 102
+	sff8472RxPower           = 104
+	sff8472Status            = 110
+	sff8472Alarms            = 112
+	sff8472Warnings          = 116
+)
+
+func decodeSFF8472(m *Memory) *Module {
+	a0 := m.page(0)
+	module := &Module{Identifier: Identifier(m.Lower[0]), Lanes: 1}
+	if a0 == nil {
+		return module
+	}
+	module.Vendor = readString(a0[sff8472VendorName : sff8472VendorName+16])
+	module.VendorOUI = readOUI(a0[sff8472VendorOUI:])
+	module.PartNumber = readString(a0[sff8472PartNumber : sff8472PartNumber+16])
+	module.Revision = readString(a0[sff8472Revision : sff8472Revision+4])
+	module.SerialNumber = readString(a0[sff8472SerialNumber : sff8472SerialNumber+16])
+	module.DateCode = readString(a0[sff8472DateCode : sff8472DateCode+8])
+	module.Wavelength = float64(readUint16(a0, sff8472Wavelength))
+
+	a2 := m.Diagnostics
+	if a0[sff8472DiagType]&sff8472DDMImplemented == 0 || len(a2) < 2*PageSize {
+		return module
+	}
+	cal := sff8472Calibration{}
+	if a0[sff8472DiagType]&sff8472ExternalCalibrated != 0 {
+		cal = readSFF8472Calibration(a2)
+	}
+
+	module.Diagnostics = &Diagnostics{
+		Temperature: cal.temperature(a2, sff8472Temperature),
+		Voltage:     cal.voltage(a2, sff8472Voltage),
+		Bias:        []float64{cal.bias(a2, sff8472Bias)},
+		TxPower:     []float64{cal.txPower(a2, sff8472TxPower)},
+		RxPower:     []float64{cal.rxPower(a2, sff8472RxPower)},
+	}
+	module.Thresholds = &Thresholds{
+		Temperature: readThreshold(a2, sff8472TempThresholds, cal.temperature),
+		Voltage:     readThreshold(a2, sff8472VoltThresholds, cal.voltage),
+		Bias:        readThreshold(a2, sff8472BiasThresholds, cal.bias),
+		TxPower:     readThreshold(a2, sff8472TxPowerThresholds, cal.txPower),
+		RxPower:     readThreshold(a2, sff8472RxPowerThresholds, cal.rxPower),
+	}
+
+	// Alarm and warning bytes share a layout: byte 0 holds high/low pairs
+	// for temperature, voltage, bias and tx power from bit 7 down, byte 1
+	// the rx power pair in bits 7 and 6.
+	alarms, warnings := a2[sff8472Alarms:], a2[sff8472Warnings:]
+	pair := func(b []byte, index int) (bool, bool) {
+		shift := 7 - 2*(index%4)
+		return b[index/4]&(1<<shift) != 0, b[index/4]&(1<<(shift-1)) != 0
+	}
+	flags := func(index int) Flags {
+		var f Flags
+		f.HighAlarm, f.LowAlarm = pair(alarms, index)
+		f.HighWarning, f.LowWarning = pair(warnings, index)
+		return f
+	}
+	module.TemperatureFlags = flags(0)
+	module.VoltageFlags = flags(1)
+	module.LaneFlags = []LaneFlags{{
+		RxLOS:   a2[sff8472Status]&(1<<1) != 0,
+		TxFault: a2[sff8472Status]&(1<<2) != 0,
+		Bias:    flags(2),
+		TxPower: flags(3),
+		RxPower: flags(4),
+	}}
+	return module
+}
+
+// sff8472Calibration holds the constants of externally calibrated modules.
+// The zero value reads internally calibrated values unchanged.
+type sff8472Calibration struct {
+	external bool
+	// rx power polynomial, rxPowerCoefficients[i] is the coefficient of x^i
+	rxPowerCoefficients   [5]float64
+	biasSlope, biasOffset float64
+	txSlope, txOffset     float64
+	tempSlope, tempOffset float64
+	voltSlope, voltOffset float64
+}
+
+func readSFF8472Calibration(a2 []byte) sff8472Calibration {
+	float := func(offset int) float64 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(a2[offset : offset+4])))
+	}
+	// Slopes are unsigned 8.8 fixed point, offsets signed integers
+	slope := func(offset int) float64 {
+		return float64(readUint16(a2, offset)) / 256
+	}
+	offset := func(offset int) float64 {
+		return float64(int16(readUint16(a2, offset)))
+	}
+	cal := sff8472Calibration{external: true}
+	for i := range cal.rxPowerCoefficients {
+		// Stored from Rx_PWR(4) down to Rx_PWR(0)
+		cal.rxPowerCoefficients[4-i] = float(sff8472RxPowerCal + 4*i)
+	}
+	cal.biasSlope, cal.biasOffset = slope(sff8472BiasCal), offset(sff8472BiasCal+2)
+	cal.txSlope, cal.txOffset = slope(sff8472TxPowerCal), offset(sff8472TxPowerCal+2)
+	cal.tempSlope, cal.tempOffset = slope(sff8472TempCal), offset(sff8472TempCal+2)
+	cal.voltSlope, cal.voltOffset = slope(sff8472VoltCal), offset(sff8472VoltCal+2)
+	return cal
+}
+
+func (c sff8472Calibration) linear(raw, slope, offset float64) float64 {
+	if !c.external {
+		return raw
+	}
+	return raw*slope + offset
+}
+
+func (c sff8472Calibration) temperature(b []byte, offset int) float64 {
+	return c.linear(float64(int16(readUint16(b, offset))), c.tempSlope, c.tempOffset) / 256
+}
+
+func (c sff8472Calibration) voltage(b []byte, offset int) float64 {
+	return c.linear(float64(readUint16(b, offset)), c.voltSlope, c.voltOffset) / 10000
+}
+
+func (c sff8472Calibration) bias(b []byte, offset int) float64 {
+	return c.linear(float64(readUint16(b, offset)), c.biasSlope, c.biasOffset) * 0.002
+}
+
+func (c sff8472Calibration) txPower(b []byte, offset int) float64 {
+	return c.linear(float64(readUint16(b, offset)), c.txSlope, c.txOffset) / 10000
+}
+
+func (c sff8472Calibration) rxPower(b []byte, offset int) float64 {
+	raw := float64(readUint16(b, offset))
+	if !c.external {
+		return raw / 10000
+	}
+	value, power := 0.0, 1.0
+	for _, coefficient := range c.rxPowerCoefficients {
+		value += coefficient * power
+		power *= raw
+	}
+	return value / 10000
+}
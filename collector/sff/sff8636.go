@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sff
+
+// SFF-8636 lower page
+const (
+	sff8636LOS          = 3
+	sff8636Fault        = 4
+	sff8636LOL          = 5
+	sff8636TempFlags    = 6
+	sff8636VoltFlags    = 7
+	sff8636RxPowerFlags = 9
+	sff8636BiasFlags    = 11
+	sff8636TxPowerFlags = 13
+	sff8636Temperature  = 22
+	sff8636Voltage      = 26
+	sff8636RxPower      = 34
+	sff8636Bias         = 42
+	sff8636TxPower      = 50
+	sff8636Lanes        = 4
+)
+
+// SFF-8636 upper page 00h
+const (
+	sff8636Transmitter  = 147
+	sff8636VendorName   = 148
+	sff8636VendorOUI    = 165
+	sff8636PartNumber   = 168
+	sff8636Revision     = 184
+	sff8636Wavelength   = 186
+	sff8636SerialNumber = 196
+	sff8636DateCode     = 212
+
+	// Transmitter technology values of copper cables start at 0xa0
+	sff8636CopperTransmitter = 0xa0
+)
+
+// SFF-8636 upper page 03h
+const (
+	sff8636TempThresholds    = 128
+	sff8636VoltThresholds    = 144
+	sff8636RxPowerThresholds = 176
+	sff8636BiasThresholds    = 184
+	sff8636TxPowerThresholds = 192
+)
+
+func decodeSFF8636(m *Memory) *Module {
+	lower := m.Lower
+	module := &Module{Identifier: Identifier(lower[0]), Lanes: sff8636Lanes}
+	if page0 := m.page(0); page0 != nil {
+		module.Vendor = readString(page0[sff8636VendorName : sff8636VendorName+16])
+		module.VendorOUI = readOUI(page0[sff8636VendorOUI:])
+		module.PartNumber = readString(page0[sff8636PartNumber : sff8636PartNumber+16])
+		module.Revision = readString(page0[sff8636Revision : sff8636Revision+2])
+		module.SerialNumber = readString(page0[sff8636SerialNumber : sff8636SerialNumber+16])
+		module.DateCode = readString(page0[sff8636DateCode : sff8636DateCode+8])
+		// Copper cables report attenuation in the wavelength bytes
+		if page0[sff8636Transmitter]&0xf0 < sff8636CopperTransmitter {
+			module.Wavelength = float64(readUint16(page0, sff8636Wavelength)) / 20
+		}
+	}
+
+	diagnostics := &Diagnostics{
+		Temperature: readTemperature(lower, sff8636Temperature),
+		Voltage:     readVoltage(lower, sff8636Voltage),
+	}
+	for lane := 0; lane < sff8636Lanes; lane++ {
+		diagnostics.RxPower = append(diagnostics.RxPower, readPower(lower, sff8636RxPower+2*lane))
+		diagnostics.Bias = append(diagnostics.Bias, readBias(lower, sff8636Bias+2*lane))
+		diagnostics.TxPower = append(diagnostics.TxPower, readPower(lower, sff8636TxPower+2*lane))
+		module.LaneFlags = append(module.LaneFlags, LaneFlags{
+			RxLOS:   lower[sff8636LOS]&(1<<lane) != 0,
+			TxLOS:   lower[sff8636LOS]&(1<<(lane+4)) != 0,
+			TxFault: lower[sff8636Fault]&(1<<lane) != 0,
+			RxLOL:   lower[sff8636LOL]&(1<<lane) != 0,
+			TxLOL:   lower[sff8636LOL]&(1<<(lane+4)) != 0,
+			RxPower: sff8636LaneFlags(lower, sff8636RxPowerFlags, lane),
+			Bias:    sff8636LaneFlags(lower, sff8636BiasFlags, lane),
+			TxPower: sff8636LaneFlags(lower, sff8636TxPowerFlags, lane),
+		})
+	}
+	module.Diagnostics = diagnostics
+	module.TemperatureFlags = nibbleFlags(lower[sff8636TempFlags] >> 4)
+	module.VoltageFlags = nibbleFlags(lower[sff8636VoltFlags] >> 4)
+
+	if page3 := m.page(3); page3 != nil {
+		module.Thresholds = &Thresholds{
+			Temperature: readThreshold(page3, sff8636TempThresholds, readTemperature),
+			Voltage:     readThreshold(page3, sff8636VoltThresholds, readVoltage),
+			Bias:        readThreshold(page3, sff8636BiasThresholds, readBias),
+			TxPower:     readThreshold(page3, sff8636TxPowerThresholds, readPower),
+			RxPower:     readThreshold(page3, sff8636RxPowerThresholds, readPower),
+		}
+	}
+	return module
+}
+
+// Lane flags are packed two lanes per byte, the first lane in the high
+// nibble.
+func sff8636LaneFlags(lower []byte, offset, lane int) Flags {
+	b := lower[offset+lane/2]
+	if lane%2 == 0 {
+		b >>= 4
+	}
+	return nibbleFlags(b)
+}
+
+// nibbleFlags decodes high alarm, low alarm, high warning and low warning
+// from bit 3 down to bit 0.
+func nibbleFlags(b byte) Flags {
+	return Flags{
+		HighAlarm:   b&0x8 != 0,
+		LowAlarm:    b&0x4 != 0,
+		HighWarning: b&0x2 != 0,
+		LowWarning:  b&0x1 != 0,
+	}
+}
@@ -0,0 +1,215 @@
+package sff
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSFF8472(t *testing.T) {
+	eeprom, err := os.ReadFile("testdata/sff8472.bin")
+	require.NoError(t, err)
+	memory, err := FromEthtool(EthtoolSFF8472, eeprom)
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+
+	assert.Equal(t, IdentifierSFP, module.Identifier)
+	assert.Equal(t, "Intel Corp", module.Vendor)
+	assert.Equal(t, "E25GSFP28SR", module.PartNumber)
+	assert.Equal(t, "AZN0X5W", module.SerialNumber)
+	assert.Equal(t, 850.0, module.Wavelength)
+	assert.Equal(t, 1, module.Lanes)
+
+	require.NotNil(t, module.Diagnostics)
+	assert.InDelta(t, 38.55, module.Diagnostics.Temperature, 0.01)
+	assert.InDelta(t, 3.3136, module.Diagnostics.Voltage, 1e-9)
+	assert.InDeltaSlice(t, []float64{6.758}, module.Diagnostics.Bias, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.6129}, module.Diagnostics.TxPower, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.5462}, module.Diagnostics.RxPower, 1e-9)
+
+	require.NotNil(t, module.Thresholds)
+	assert.InDelta(t, 13, module.Thresholds.Bias.HighAlarm, 1e-9)
+	assert.InDelta(t, 3, module.Thresholds.Bias.LowAlarm, 1e-9)
+	assert.InDelta(t, 1.7783, module.Thresholds.TxPower.HighAlarm, 1e-9)
+	assert.InDelta(t, 0.0251, module.Thresholds.RxPower.LowAlarm, 1e-9)
+
+	assert.Equal(t, []LaneFlags{{}}, module.LaneFlags)
+}
+
+func TestDecodeSFF8472RxLOS(t *testing.T) {
+	eeprom, err := os.ReadFile("testdata/sff8472.bin")
+	require.NoError(t, err)
+	eeprom[256+sff8472Status] |= 1 << 1
+	memory, err := FromEthtool(EthtoolSFF8472, eeprom)
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+	assert.Equal(t, []LaneFlags{{RxLOS: true}}, module.LaneFlags)
+}
+
+func TestDecodeSFF8472ExternalCalibration(t *testing.T) {
+	eeprom, err := os.ReadFile("testdata/sff8472.bin")
+	require.NoError(t, err)
+	eeprom[sff8472DiagType] |= sff8472ExternalCalibrated
+	a2 := eeprom[2*PageSize:]
+	// rx power = 10 + 2 * raw, bias slope 2.0, temperature offset +1 degree
+	binary.BigEndian.PutUint32(a2[sff8472RxPowerCal+12:], math.Float32bits(2))
+	binary.BigEndian.PutUint32(a2[sff8472RxPowerCal+16:], math.Float32bits(10))
+	binary.BigEndian.PutUint16(a2[sff8472BiasCal:], 2*256)
+	binary.BigEndian.PutUint16(a2[sff8472TxPowerCal:], 256)
+	binary.BigEndian.PutUint16(a2[sff8472TempCal:], 256)
+	binary.BigEndian.PutUint16(a2[sff8472TempCal+2:], 256)
+	binary.BigEndian.PutUint16(a2[sff8472VoltCal:], 256)
+
+	memory, err := FromEthtool(EthtoolSFF8472, eeprom)
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+	assert.InDelta(t, 39.55, module.Diagnostics.Temperature, 0.01)
+	assert.InDelta(t, 3.3136, module.Diagnostics.Voltage, 1e-9)
+	assert.InDeltaSlice(t, []float64{13.516}, module.Diagnostics.Bias, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.6129}, module.Diagnostics.TxPower, 1e-9)
+	assert.InDeltaSlice(t, []float64{(10 + 2*5462.0) / 10000}, module.Diagnostics.RxPower, 1e-9)
+}
+
+func TestDecodeSFF8636(t *testing.T) {
+	eeprom, err := os.ReadFile("testdata/sff8636.bin")
+	require.NoError(t, err)
+	memory, err := FromEthtool(EthtoolSFF8636, eeprom)
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+
+	assert.Equal(t, IdentifierQSFP28, module.Identifier)
+	assert.Equal(t, "BROADCOM", module.Vendor)
+	assert.Equal(t, "00:10:18", module.VendorOUI)
+	assert.Equal(t, "AFBR-89CDDZ", module.PartNumber)
+	assert.Equal(t, "01", module.Revision)
+	assert.Equal(t, "A2011300ABC", module.SerialNumber)
+	assert.Equal(t, "200311", module.DateCode)
+	assert.Equal(t, 850.0, module.Wavelength)
+	assert.Equal(t, 4, module.Lanes)
+
+	assert.InDelta(t, 33.46, module.Diagnostics.Temperature, 0.01)
+	assert.InDelta(t, 3.2908, module.Diagnostics.Voltage, 1e-9)
+	assert.InDeltaSlice(t, []float64{6.8, 6.9, 7.0, 7.1}, module.Diagnostics.Bias, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.7943, 0.6310, 0.5012, 0.3981}, module.Diagnostics.TxPower, 1e-9)
+	assert.InDeltaSlice(t, []float64{0.6310, 0.5012, 0, 0.3981}, module.Diagnostics.RxPower, 1e-9)
+
+	require.NotNil(t, module.Thresholds)
+	assert.Equal(t, Threshold{HighAlarm: 75, LowAlarm: -5, HighWarning: 70}, module.Thresholds.Temperature)
+	assert.InDelta(t, 3.63, module.Thresholds.Voltage.HighAlarm, 1e-9)
+	assert.InDelta(t, 10, module.Thresholds.Bias.HighAlarm, 1e-9)
+	assert.InDelta(t, 2, module.Thresholds.Bias.LowAlarm, 1e-9)
+	assert.InDelta(t, 0.0891, module.Thresholds.TxPower.LowAlarm, 1e-9)
+	assert.InDelta(t, 0.0447, module.Thresholds.RxPower.LowAlarm, 1e-9)
+
+	require.Len(t, module.LaneFlags, 4)
+	assert.Equal(t, LaneFlags{RxPower: Flags{HighAlarm: true}}, module.LaneFlags[0])
+	assert.Equal(t, LaneFlags{Bias: Flags{LowWarning: true}}, module.LaneFlags[1])
+	assert.Equal(t, LaneFlags{RxLOS: true}, module.LaneFlags[2])
+	assert.Equal(t, LaneFlags{}, module.LaneFlags[3])
+}
+
+func TestDecodeSFF8636WithoutThresholds(t *testing.T) {
+	eeprom, err := os.ReadFile("testdata/sff8636.bin")
+	require.NoError(t, err)
+	memory, err := FromEthtool(EthtoolSFF8636, eeprom[:2*PageSize])
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+	assert.Nil(t, module.Thresholds)
+	assert.Len(t, module.Diagnostics.RxPower, 4)
+}
+
+func TestDecodeCMIS(t *testing.T) {
+	dump, err := os.ReadFile("testdata/cmis.bin")
+	require.NoError(t, err)
+	memory, err := ReadPageDump(dump)
+	require.NoError(t, err)
+	module, err := Decode(memory)
+	require.NoError(t, err)
+
+	assert.Equal(t, IdentifierQSFPCMIS, module.Identifier)
+	assert.Equal(t, "QSFP_CMIS", module.Identifier.String())
+	assert.Equal(t, "Firmus", module.Vendor)
+	assert.Equal(t, "QSFP200I-SR4-5M", module.PartNumber)
+	assert.Equal(t, "41", module.Revision)
+	assert.Equal(t, "5C2410312895", module.SerialNumber)
+	assert.Equal(t, "241114", module.DateCode)
+	assert.Equal(t, 850.0, module.Wavelength)
+	assert.Equal(t, 4, module.Lanes)
+	assert.Equal(t, ModuleReady, module.ModuleState)
+	assert.Equal(t, []DataPathState{DPActivated, DPActivated, DPActivated, DPActivated}, module.DataPathState)
+
+	assert.Equal(t, 41.0, module.Diagnostics.Temperature)
+	assert.InDelta(t, 3.2487, module.Diagnostics.Voltage, 1e-9)
+	assert.InDeltaSlice(t, []float64{7.64, 8.1, 7.74, 8.18}, module.Diagnostics.Bias, 1e-9)
+	assert.InDeltaSlice(t, []float64{20, 21, 22, 23}, module.Diagnostics.SNRMedia, 1e-9)
+	assert.Nil(t, module.Diagnostics.SNRHost)
+
+	require.NotNil(t, module.Thresholds)
+	assert.Equal(t, Threshold{HighAlarm: 80, LowAlarm: -10, HighWarning: 75, LowWarning: -5}, module.Thresholds.Temperature)
+	assert.InDelta(t, 15, module.Thresholds.Bias.HighAlarm, 1e-9)
+	assert.InDelta(t, 3, module.Thresholds.Bias.LowAlarm, 1e-9)
+
+	require.Len(t, module.LaneFlags, 4)
+	assert.Equal(t, LaneFlags{}, module.LaneFlags[0])
+	assert.Equal(t, LaneFlags{TxPower: Flags{HighAlarm: true}}, module.LaneFlags[3])
+
+	ber := map[int]float64{}
+	for _, observable := range module.VDM {
+		if observable.Type == VDMPreFECBERCurrentMedia {
+			ber[observable.Lane] = observable.Value
+		}
+	}
+	assert.InDelta(t, 8e-13, ber[1], 1e-25)
+	assert.InDelta(t, 1.1e-12, ber[4], 1e-25)
+	assert.Contains(t, module.VDM, VDMObservable{Type: VDMPreFECBERCurrentHost, Lane: 1, Value: 125 * math.Pow10(-10)})
+	assert.Len(t, module.VDM, 9)
+}
+
+func TestDecodeCMISBiasScaling(t *testing.T) {
+	dump, err := os.ReadFile("testdata/cmis.bin")
+	require.NoError(t, err)
+	memory, err := ReadPageDump(dump)
+	require.NoError(t, err)
+	memory.Upper[0x01][cmisBiasScaling-PageSize] = 2 << 3
+	module, err := Decode(memory)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float64{30.56, 32.4, 30.96, 32.72}, module.Diagnostics.Bias, 1e-9)
+	assert.InDelta(t, 60, module.Thresholds.Bias.HighAlarm, 1e-9)
+}
+
+func TestDecodeF16(t *testing.T) {
+	assert.Equal(t, 0.0, decodeF16(0))
+	assert.InDelta(t, 8e-13, decodeF16(9<<11|800), 1e-25)
+	assert.InDelta(t, 2047e7, decodeF16(31<<11|2047), 1)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode(&Memory{Lower: make([]byte, 10)})
+	assert.ErrorIs(t, err, ErrShortMemory)
+
+	lower := make([]byte, PageSize)
+	lower[0] = 0x7f
+	_, err = Decode(&Memory{Lower: lower})
+	assert.EqualError(t, err, "sff: unsupported identifier 0x7f")
+
+	_, err = FromEthtool(0x9, make([]byte, 256))
+	assert.Error(t, err)
+
+	_, err = ReadPageDump(make([]byte, PageSize+10))
+	assert.Error(t, err)
+}
+
+func TestMilliwattsToDbm(t *testing.T) {
+	assert.Equal(t, -40.0, MilliwattsToDbm(0))
+	assert.InDelta(t, 0, MilliwattsToDbm(1), 1e-12)
+	assert.InDelta(t, -3, MilliwattsToDbm(0.5012), 0.001)
+}
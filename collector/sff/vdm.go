@@ -0,0 +1,177 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sff
+
+import (
+	"fmt"
+	"math"
+)
+
+// CMIS versatile diagnostics monitoring (VDM) pages. Every group of 64
+// observables has a descriptor page and a sample page.
+const (
+	vdmDescriptorPage = 0x20
+	vdmSamplePage     = 0x24
+	vdmGroups         = 4
+	vdmPerGroup       = 64
+)
+
+// VDMType is the observable type ID of a VDM descriptor.
+type VDMType uint8
+
+const (
+	VDMLaserAge                  VDMType = 1
+	VDMTECCurrent                VDMType = 2
+	VDMLaserFrequencyError       VDMType = 3
+	VDMLaserTemperature          VDMType = 4
+	VDMESNRMedia                 VDMType = 5
+	VDMESNRHost                  VDMType = 6
+	VDMLTPMedia                  VDMType = 7
+	VDMLTPHost                   VDMType = 8
+	VDMPreFECBERMinMedia         VDMType = 9
+	VDMPreFECBERMinHost          VDMType = 10
+	VDMPreFECBERMaxMedia         VDMType = 11
+	VDMPreFECBERMaxHost          VDMType = 12
+	VDMPreFECBERAverageMedia     VDMType = 13
+	VDMPreFECBERAverageHost      VDMType = 14
+	VDMPreFECBERCurrentMedia     VDMType = 15
+	VDMPreFECBERCurrentHost      VDMType = 16
+	VDMErroredFramesMinMedia     VDMType = 17
+	VDMErroredFramesMinHost      VDMType = 18
+	VDMErroredFramesMaxMedia     VDMType = 19
+	VDMErroredFramesMaxHost      VDMType = 20
+	VDMErroredFramesAverageMedia VDMType = 21
+	VDMErroredFramesAverageHost  VDMType = 22
+	VDMErroredFramesCurrentMedia VDMType = 23
+	VDMErroredFramesCurrentHost  VDMType = 24
+)
+
+var vdmTypeNames = map[VDMType]string{
+	VDMLaserAge:                  "laser_age",
+	VDMTECCurrent:                "tec_current",
+	VDMLaserFrequencyError:       "laser_frequency_error",
+	VDMLaserTemperature:          "laser_temperature",
+	VDMESNRMedia:                 "esnr_media",
+	VDMESNRHost:                  "esnr_host",
+	VDMLTPMedia:                  "ltp_media",
+	VDMLTPHost:                   "ltp_host",
+	VDMPreFECBERMinMedia:         "pre_fec_ber_min_media",
+	VDMPreFECBERMinHost:          "pre_fec_ber_min_host",
+	VDMPreFECBERMaxMedia:         "pre_fec_ber_max_media",
+	VDMPreFECBERMaxHost:          "pre_fec_ber_max_host",
+	VDMPreFECBERAverageMedia:     "pre_fec_ber_average_media",
+	VDMPreFECBERAverageHost:      "pre_fec_ber_average_host",
+	VDMPreFECBERCurrentMedia:     "pre_fec_ber_current_media",
+	VDMPreFECBERCurrentHost:      "pre_fec_ber_current_host",
+	VDMErroredFramesMinMedia:     "errored_frames_min_media",
+	VDMErroredFramesMinHost:      "errored_frames_min_host",
+	VDMErroredFramesMaxMedia:     "errored_frames_max_media",
+	VDMErroredFramesMaxHost:      "errored_frames_max_host",
+	VDMErroredFramesAverageMedia: "errored_frames_average_media",
+	VDMErroredFramesAverageHost:  "errored_frames_average_host",
+	VDMErroredFramesCurrentMedia: "errored_frames_current_media",
+	VDMErroredFramesCurrentHost:  "errored_frames_current_host",
+}
+
+func (t VDMType) String() string {
+	if name, ok := vdmTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type_%d", uint8(t))
+}
+
+// VDMObservable is a single VDM sample. Lane is 1 based.
+type VDMObservable struct {
+	Type  VDMType
+	Lane  int
+	Value float64
+}
+
+// decodeVDM reads the observables of every VDM group whose descriptor
+// and sample pages were read. Unused descriptors have type 0.
+func decodeVDM(m *Memory) []VDMObservable {
+	var observables []VDMObservable
+	for group := uint8(0); group < vdmGroups; group++ {
+		descriptors := m.page(vdmDescriptorPage + group)
+		samples := m.page(vdmSamplePage + group)
+		if descriptors == nil || samples == nil {
+			break
+		}
+		for i := 0; i < vdmPerGroup; i++ {
+			offset := PageSize + 2*i
+			typ := VDMType(descriptors[offset+1])
+			if typ == 0 {
+				continue
+			}
+			observables = append(observables, VDMObservable{
+				Type:  typ,
+				Lane:  int(descriptors[offset]&0xf) + 1,
+				Value: vdmValue(typ, readUint16(samples, offset)),
+			})
+		}
+	}
+	return observables
+}
+
+func vdmValue(typ VDMType, raw uint16) float64 {
+	switch {
+	case typ == VDMLaserAge:
+		// percent
+		return float64(raw)
+	case typ == VDMTECCurrent:
+		// percent of the maximum current
+		return float64(int16(raw)) * 100 / 32767
+	case typ == VDMLaserFrequencyError:
+		// MHz, in units of 10 MHz
+		return float64(int16(raw)) * 10
+	case typ == VDMLaserTemperature:
+		return float64(int16(raw)) / 256
+	case typ >= VDMESNRMedia && typ <= VDMLTPHost:
+		// dB, in units of 1/256 dB
+		return float64(raw) / 256
+	case typ >= VDMPreFECBERMinMedia && typ <= VDMErroredFramesCurrentHost:
+		return decodeF16(raw)
+	}
+	return float64(raw)
+}
+
+// decodeF16 decodes the CMIS F16 format: a 5 bit exponent s and an 11 bit
+// mantissa m with value m * 10^(s-24).
+func decodeF16(raw uint16) float64 {
+	exponent := int(raw >> 11)
+	mantissa := float64(raw & 0x7ff)
+	return mantissa * math.Pow10(exponent-24)
+}
+
+// vdmPerLane returns the values of one observable type indexed by lane,
+// or nil when the module does not report it.
+func vdmPerLane(observables []VDMObservable, typ VDMType, lanes int) []float64 {
+	var values []float64
+	for _, observable := range observables {
+		if observable.Type != typ || observable.Lane > lanes {
+			continue
+		}
+		if values == nil {
+			values = make([]float64, lanes)
+		}
+		values[observable.Lane-1] = observable.Value
+	}
+	return values
+}
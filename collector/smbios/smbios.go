@@ -0,0 +1,238 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package smbios decodes the SMBIOS (DMI) tables the kernel exports in
+// /sys/firmware/dmi/tables. It decodes the BIOS, system, baseboard, chassis
+// and system slot structures.
+package smbios
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Paths of the entry point and the structure table relative to /sys.
+const (
+	EntryPointPath = "firmware/dmi/tables/smbios_entry_point"
+	TablePath      = "firmware/dmi/tables/DMI"
+)
+
+var (
+	// ErrEntryPoint is returned when the entry point has no known anchor or
+	// is truncated.
+	ErrEntryPoint = errors.New("smbios: invalid entry point")
+	// ErrShortTable is returned when a structure runs past the table.
+	ErrShortTable = errors.New("smbios: structure table is short")
+)
+
+// Structure types
+const (
+	TypeBIOS       = 0
+	TypeSystem     = 1
+	TypeBaseboard  = 2
+	TypeChassis    = 3
+	TypeSystemSlot = 9
+	TypeEndOfTable = 127
+)
+
+// EntryPoint holds the SMBIOS version and the table location from either the
+// 32-bit ("_SM_") or the 64-bit ("_SM3_") entry point.
+type EntryPoint struct {
+	Major        uint8
+	Minor        uint8
+	Revision     uint8
+	TableAddress uint64
+	TableLength  uint32
+}
+
+// Version returns the SMBIOS version, eg. "3.3.0".
+func (e EntryPoint) Version() string {
+	return fmt.Sprintf("%d.%d.%d", e.Major, e.Minor, e.Revision)
+}
+
+// atLeast reports whether the SMBIOS version is major.minor or later.
+func (e EntryPoint) atLeast(major, minor uint8) bool {
+	return e.Major > major || (e.Major == major && e.Minor >= minor)
+}
+
+// ParseEntryPoint decodes the 32-bit or the 64-bit entry point.
+func ParseEntryPoint(data []byte) (EntryPoint, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("_SM3_")):
+		if len(data) < 0x18 {
+			return EntryPoint{}, ErrEntryPoint
+		}
+		return EntryPoint{
+			Major:        data[0x07],
+			Minor:        data[0x08],
+			Revision:     data[0x09],
+			TableLength:  binary.LittleEndian.Uint32(data[0x0c:]),
+			TableAddress: binary.LittleEndian.Uint64(data[0x10:]),
+		}, nil
+	case bytes.HasPrefix(data, []byte("_SM_")):
+		if len(data) < 0x1f {
+			return EntryPoint{}, ErrEntryPoint
+		}
+		return EntryPoint{
+			Major:        data[0x06],
+			Minor:        data[0x07],
+			TableLength:  uint32(binary.LittleEndian.Uint16(data[0x16:])),
+			TableAddress: uint64(binary.LittleEndian.Uint32(data[0x18:])),
+		}, nil
+	}
+	return EntryPoint{}, ErrEntryPoint
+}
+
+// Structure is one structure of the table: the formatted area including the
+// 4 byte header, and the strings that follow it.
+type Structure struct {
+	Type      uint8
+	Handle    uint16
+	Formatted []byte
+	Strings   []string
+}
+
+// byte returns the byte at offset of the formatted area, or 0 when the
+// structure is too short to hold it.
+func (s Structure) byte(offset int) uint8 {
+	if offset >= len(s.Formatted) {
+		return 0
+	}
+	return s.Formatted[offset]
+}
+
+// word returns the little endian word at offset, or 0 when the structure is
+// too short to hold it.
+func (s Structure) word(offset int) uint16 {
+	if offset+2 > len(s.Formatted) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(s.Formatted[offset:])
+}
+
+// String returns the string referenced by the byte at offset. Strings are
+// numbered from 1; 0 means the string is not set.
+func (s Structure) String(offset int) string {
+	index := int(s.byte(offset))
+	if index == 0 || index > len(s.Strings) {
+		return ""
+	}
+	return s.Strings[index-1]
+}
+
+// ParseStructures splits the table into structures, up to the end of table
+// structure or the end of the data.
+func ParseStructures(table []byte) ([]Structure, error) {
+	var structures []Structure
+	for offset := 0; offset+4 <= len(table); {
+		length := int(table[offset+1])
+		if length < 4 || offset+length > len(table) {
+			return structures, ErrShortTable
+		}
+		structure := Structure{
+			Type:      table[offset],
+			Handle:    binary.LittleEndian.Uint16(table[offset+2:]),
+			Formatted: table[offset : offset+length],
+		}
+		// The string set ends with a double NUL, which is also all there is
+		// when the structure has no strings.
+		end := bytes.Index(table[offset+length:], []byte{0, 0})
+		if end < 0 {
+			return structures, ErrShortTable
+		}
+		stringSet := table[offset+length : offset+length+end]
+		if len(stringSet) > 0 {
+			for _, value := range bytes.Split(stringSet, []byte{0}) {
+				structure.Strings = append(structure.Strings, strings.TrimSpace(string(value)))
+			}
+		}
+		structures = append(structures, structure)
+		if structure.Type == TypeEndOfTable {
+			break
+		}
+		offset += length + end + 2
+	}
+	return structures, nil
+}
+
+// Tables holds the decoded structures. Structures that appear once per
+// system are nil when the table lacks them.
+type Tables struct {
+	EntryPoint EntryPoint
+	BIOS       *BIOS
+	System     *System
+	Baseboard  *Baseboard
+	Chassis    *Chassis
+	Slots      []Slot
+}
+
+// Decode decodes the entry point and the structure table.
+func Decode(entryPoint, table []byte) (*Tables, error) {
+	ep, err := ParseEntryPoint(entryPoint)
+	if err != nil {
+		return nil, err
+	}
+	structures, err := ParseStructures(table)
+	if err != nil && len(structures) == 0 {
+		return nil, err
+	}
+	tables := &Tables{EntryPoint: ep}
+	for _, structure := range structures {
+		switch structure.Type {
+		case TypeBIOS:
+			if tables.BIOS == nil {
+				tables.BIOS = decodeBIOS(structure)
+			}
+		case TypeSystem:
+			if tables.System == nil {
+				tables.System = decodeSystem(structure, ep)
+			}
+		case TypeBaseboard:
+			if tables.Baseboard == nil {
+				tables.Baseboard = decodeBaseboard(structure)
+			}
+		case TypeChassis:
+			if tables.Chassis == nil {
+				tables.Chassis = decodeChassis(structure)
+			}
+		case TypeSystemSlot:
+			tables.Slots = append(tables.Slots, decodeSlot(structure))
+		}
+	}
+	return tables, nil
+}
+
+// Read reads and decodes the tables under basePath, usually /sys. Reading
+// them requires root.
+func Read(basePath string) (*Tables, error) {
+	entryPoint, err := os.ReadFile(filepath.Join(basePath, EntryPointPath))
+	if err != nil {
+		return nil, err
+	}
+	table, err := os.ReadFile(filepath.Join(basePath, TablePath))
+	if err != nil {
+		return nil, err
+	}
+	return Decode(entryPoint, table)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package smbios
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSMBIOS3(t *testing.T) {
+	tables, err := Read("testdata/smbios3")
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.3.0", tables.EntryPoint.Version())
+	assert.Equal(t, uint64(0x6a8ed000), tables.EntryPoint.TableAddress)
+
+	assert.Equal(t, &BIOS{
+		Vendor:      "Dell Inc.",
+		Version:     "1.6.10",
+		ReleaseDate: "03/05/2024",
+		Release:     "1.6",
+	}, tables.BIOS)
+	assert.Equal(t, &System{
+		Manufacturer: "Dell Inc.",
+		ProductName:  "PowerEdge XE9680",
+		SerialNumber: "4JQ5M24",
+		UUID:         "4c4c4544-004a-3510-804b-b4c04f4d3234",
+		SKUNumber:    "SKU=0CD4;ModelName=PowerEdge XE9680",
+		Family:       "PowerEdge",
+	}, tables.System)
+	assert.Equal(t, &Baseboard{
+		Manufacturer: "Dell Inc.",
+		Product:      "0RKWCM",
+		Version:      "A02",
+		SerialNumber: ".4JQ5M24.CNFCP0041G0123.",
+	}, tables.Baseboard)
+	require.NotNil(t, tables.Chassis)
+	assert.Equal(t, "Rack Mount Chassis", tables.Chassis.Type.String())
+	assert.Equal(t, "4JQ5M24", tables.Chassis.SerialNumber)
+
+	// The slots match testdata/dmidecode_output.txt of the collector
+	require.Len(t, tables.Slots, 26)
+	assert.Equal(t, Slot{
+		Designation:  "PCIe Slot 38",
+		Type:         0xc3,
+		DataBusWidth: 0x0d,
+		CurrentUsage: SlotUsageInUse,
+		ID:           38,
+		Segment:      0x0000,
+		Bus:          0x1a,
+		DevFn:        0x00,
+	}, tables.Slots[0])
+	assert.Equal(t, "0000:1a:00.0", tables.Slots[0].BusAddress())
+	assert.Equal(t, "PCIe Slot 31", tables.Slots[6].Designation)
+	assert.Equal(t, SlotUsageAvailable, tables.Slots[6].CurrentUsage)
+	assert.Equal(t, "", tables.Slots[6].BusAddress())
+	assert.Equal(t, "PCIe SSD Slot 2 in Bay 1", tables.Slots[25].Designation)
+	assert.Equal(t, "0000:da:00.0", tables.Slots[25].BusAddress())
+}
+
+func TestReadSMBIOS2(t *testing.T) {
+	tables, err := Read("testdata/smbios2")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2.4.0", tables.EntryPoint.Version())
+	assert.Equal(t, uint64(0xf0450), tables.EntryPoint.TableAddress)
+	assert.Equal(t, uint32(202), tables.EntryPoint.TableLength)
+
+	// The BIOS release is only in longer BIOS structures
+	assert.Equal(t, "", tables.BIOS.Release)
+	assert.Equal(t, "2.1a", tables.BIOS.Version)
+	// Before SMBIOS 2.6 the UUID is in network byte order
+	assert.Equal(t, "00020003-0004-0005-0006-000700080009", tables.System.UUID)
+	assert.Equal(t, "", tables.System.SKUNumber)
+	assert.Equal(t, "1.0", tables.Baseboard.Version)
+	assert.Equal(t, "", tables.Baseboard.SerialNumber)
+	// The chassis lock bit is not part of the type
+	assert.Equal(t, "Other", tables.Chassis.Type.String())
+
+	// Slots before SMBIOS 2.6 have no bus address
+	require.Len(t, tables.Slots, 1)
+	assert.Equal(t, "PCIE1", tables.Slots[0].Designation)
+	assert.Equal(t, "", tables.Slots[0].BusAddress())
+}
+
+func TestReadMissing(t *testing.T) {
+	_, err := Read(t.TempDir())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseEntryPoint(t *testing.T) {
+	_, err := ParseEntryPoint([]byte("_XX_ not an entry point........"))
+	assert.ErrorIs(t, err, ErrEntryPoint)
+	_, err = ParseEntryPoint([]byte("_SM3_\x00\x18\x03"))
+	assert.ErrorIs(t, err, ErrEntryPoint)
+}
+
+func TestParseStructuresShort(t *testing.T) {
+	table, err := os.ReadFile(filepath.Join("testdata/smbios3", TablePath))
+	require.NoError(t, err)
+
+	// A truncated table keeps the structures before the cut
+	structures, err := ParseStructures(table[:100])
+	assert.ErrorIs(t, err, ErrShortTable)
+	require.NotEmpty(t, structures)
+	assert.Equal(t, uint8(TypeBIOS), structures[0].Type)
+
+	// A structure that claims to be shorter than its header
+	_, err = ParseStructures([]byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x00})
+	assert.ErrorIs(t, err, ErrShortTable)
+}
+
+func TestParseStructuresStrings(t *testing.T) {
+	table := []byte{
+		0x02, 0x06, 0x01, 0x00, 0x02, 0x05, 'A', 0x00, ' ', 'B', ' ', 0x00, 0x00,
+		0x7f, 0x04, 0x02, 0x00, 0x00, 0x00,
+		// Anything after the end of table is ignored
+		0xff,
+	}
+	structures, err := ParseStructures(table)
+	require.NoError(t, err)
+	require.Len(t, structures, 2)
+	assert.Equal(t, uint16(1), structures[0].Handle)
+	assert.Equal(t, []string{"A", "B"}, structures[0].Strings)
+	assert.Equal(t, "B", structures[0].String(0x04))
+	// Out of range string references are empty
+	assert.Equal(t, "", structures[0].String(0x05))
+	assert.Equal(t, "", structures[0].String(0x10))
+	assert.Equal(t, uint8(TypeEndOfTable), structures[1].Type)
+}
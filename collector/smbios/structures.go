@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package smbios
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// BIOS is the BIOS information structure (type 0).
+type BIOS struct {
+	Vendor      string
+	Version     string
+	ReleaseDate string
+	// Release is the system BIOS major and minor release, eg. "2.3", empty
+	// when the BIOS does not report it.
+	Release string
+}
+
+func decodeBIOS(s Structure) *BIOS {
+	bios := &BIOS{
+		Vendor:      s.String(0x04),
+		Version:     s.String(0x05),
+		ReleaseDate: s.String(0x08),
+	}
+	if len(s.Formatted) > 0x15 && s.byte(0x14) != 0xff {
+		bios.Release = fmt.Sprintf("%d.%d", s.byte(0x14), s.byte(0x15))
+	}
+	return bios
+}
+
+// System is the system information structure (type 1).
+type System struct {
+	Manufacturer string
+	ProductName  string
+	Version      string
+	SerialNumber string
+	UUID         string
+	SKUNumber    string
+	Family       string
+}
+
+func decodeSystem(s Structure, ep EntryPoint) *System {
+	return &System{
+		Manufacturer: s.String(0x04),
+		ProductName:  s.String(0x05),
+		Version:      s.String(0x06),
+		SerialNumber: s.String(0x07),
+		UUID:         decodeUUID(s, 0x08, ep),
+		SKUNumber:    s.String(0x19),
+		Family:       s.String(0x1a),
+	}
+}
+
+// decodeUUID formats the UUID at offset. From SMBIOS 2.6 the first three
+// fields are little endian. A UUID of all zeroes or all ones is not set.
+func decodeUUID(s Structure, offset int, ep EntryPoint) string {
+	if offset+16 > len(s.Formatted) {
+		return ""
+	}
+	uuid := s.Formatted[offset : offset+16]
+	if bytes.Equal(uuid, make([]byte, 16)) || bytes.Equal(uuid, bytes.Repeat([]byte{0xff}, 16)) {
+		return ""
+	}
+	if ep.atLeast(2, 6) {
+		return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+			binary.LittleEndian.Uint32(uuid[0:]),
+			binary.LittleEndian.Uint16(uuid[4:]),
+			binary.LittleEndian.Uint16(uuid[6:]),
+			uuid[8:10], uuid[10:16])
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// Baseboard is the baseboard information structure (type 2). Version holds
+// the board revision.
+type Baseboard struct {
+	Manufacturer string
+	Product      string
+	Version      string
+	SerialNumber string
+	AssetTag     string
+}
+
+func decodeBaseboard(s Structure) *Baseboard {
+	return &Baseboard{
+		Manufacturer: s.String(0x04),
+		Product:      s.String(0x05),
+		Version:      s.String(0x06),
+		SerialNumber: s.String(0x07),
+		AssetTag:     s.String(0x08),
+	}
+}
+
+// Chassis is the system enclosure structure (type 3).
+type Chassis struct {
+	Manufacturer string
+	Type         ChassisType
+	Version      string
+	SerialNumber string
+	AssetTag     string
+}
+
+func decodeChassis(s Structure) *Chassis {
+	return &Chassis{
+		Manufacturer: s.String(0x04),
+		// Bit 7 is the chassis lock
+		Type:         ChassisType(s.byte(0x05) & 0x7f),
+		Version:      s.String(0x06),
+		SerialNumber: s.String(0x07),
+		AssetTag:     s.String(0x08),
+	}
+}
+
+// ChassisType is the type of the system enclosure.
+type ChassisType uint8
+
+var chassisTypeNames = map[ChassisType]string{
+	0x01: "Other",
+	0x02: "Unknown",
+	0x03: "Desktop",
+	0x04: "Low Profile Desktop",
+	0x05: "Pizza Box",
+	0x06: "Mini Tower",
+	0x07: "Tower",
+	0x08: "Portable",
+	0x09: "Laptop",
+	0x0a: "Notebook",
+	0x0b: "Hand Held",
+	0x0c: "Docking Station",
+	0x0d: "All In One",
+	0x0e: "Sub Notebook",
+	0x0f: "Space-saving",
+	0x10: "Lunch Box",
+	0x11: "Main Server Chassis",
+	0x12: "Expansion Chassis",
+	0x13: "Sub Chassis",
+	0x14: "Bus Expansion Chassis",
+	0x15: "Peripheral Chassis",
+	0x16: "RAID Chassis",
+	0x17: "Rack Mount Chassis",
+	0x18: "Sealed-case PC",
+	0x19: "Multi-system",
+	0x1a: "CompactPCI",
+	0x1b: "AdvancedTCA",
+	0x1c: "Blade",
+	0x1d: "Blade Enclosure",
+	0x1e: "Tablet",
+	0x1f: "Convertible",
+	0x20: "Detachable",
+	0x21: "IoT Gateway",
+	0x22: "Embedded PC",
+	0x23: "Mini PC",
+	0x24: "Stick PC",
+}
+
+func (t ChassisType) String() string {
+	if name, ok := chassisTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(t))
+}
+
+// SlotUsage is the current usage of a system slot.
+type SlotUsage uint8
+
+const (
+	SlotUsageOther       SlotUsage = 0x01
+	SlotUsageUnknown     SlotUsage = 0x02
+	SlotUsageAvailable   SlotUsage = 0x03
+	SlotUsageInUse       SlotUsage = 0x04
+	SlotUsageUnavailable SlotUsage = 0x05
+)
+
+var slotUsageNames = map[SlotUsage]string{
+	SlotUsageOther:       "Other",
+	SlotUsageUnknown:     "Unknown",
+	SlotUsageAvailable:   "Available",
+	SlotUsageInUse:       "In Use",
+	SlotUsageUnavailable: "Unavailable",
+}
+
+func (u SlotUsage) String() string {
+	if name, ok := slotUsageNames[u]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(u))
+}
+
+// Slot is the system slot structure (type 9).
+type Slot struct {
+	Designation  string
+	Type         uint8
+	DataBusWidth uint8
+	CurrentUsage SlotUsage
+	ID           uint16
+	// The address of the device in the slot, from SMBIOS 2.6. Segment is
+	// 0xffff and Bus and DevFn are 0xff when the slot has no address.
+	Segment uint16
+	Bus     uint8
+	DevFn   uint8
+}
+
+func decodeSlot(s Structure) Slot {
+	slot := Slot{
+		Designation:  s.String(0x04),
+		Type:         s.byte(0x05),
+		DataBusWidth: s.byte(0x06),
+		CurrentUsage: SlotUsage(s.byte(0x07)),
+		ID:           s.word(0x09),
+		Segment:      0xffff,
+		Bus:          0xff,
+		DevFn:        0xff,
+	}
+	if len(s.Formatted) >= 0x11 {
+		slot.Segment = s.word(0x0d)
+		slot.Bus = s.byte(0x0f)
+		slot.DevFn = s.byte(0x10)
+	}
+	return slot
+}
+
+// BusAddress returns the address of the device in the slot, eg.
+// "0000:1b:00.0", or an empty string when the slot has none.
+func (s Slot) BusAddress() string {
+	if s.Segment == 0xffff && s.Bus == 0xff && s.DevFn == 0xff {
+		return ""
+	}
+	return fmt.Sprintf("%04x:%02x:%02x.%x", s.Segment, s.Bus, s.DevFn>>3, s.DevFn&0x7)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stateSet exports an enum as an OpenMetrics StateSet: one series per known
+// state, with the state in a label named like the metric, that is 1 for the
+// current state and 0 for the others. A state that is not known is exported
+// on the <name>_unknown series with its raw name, so that new firmware
+// states are not silently read as one of the known ones.
+type stateSet struct {
+	desc        *prometheus.Desc
+	unknownDesc *prometheus.Desc
+	states      []string
+}
+
+// newStateSet returns a state set of the keys of states, which are the maps
+// the numeric gauges use. what describes the state, eg. "Link state".
+func newStateSet(name, what string, states map[string]float64, labels []string) *stateSet {
+	return &stateSet{
+		desc: prometheus.NewDesc(name,
+			what+", 1 for the current state and 0 for the others.",
+			append(append([]string{}, labels...), name), nil),
+		unknownDesc: prometheus.NewDesc(name+"_unknown",
+			what+" that is not a known state, value is always 1.",
+			append(append([]string{}, labels...), "state"), nil),
+		states: slices.Sorted(maps.Keys(states)),
+	}
+}
+
+func (s *stateSet) describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+	ch <- s.unknownDesc
+}
+
+// collect exports the state set for current. An empty state is not
+// reported by the source and is skipped.
+func (s *stateSet) collect(ch chan<- prometheus.Metric, current string, labelValues ...string) {
+	if current == "" {
+		return
+	}
+	known := false
+	for _, state := range s.states {
+		value := 0.0
+		if state == current {
+			value = 1
+			known = true
+		}
+		sendConstMetric(ch, s.desc, prometheus.GaugeValue, value, append(append([]string{}, labelValues...), state)...)
+	}
+	if !known {
+		sendConstMetric(ch, s.unknownDesc, prometheus.GaugeValue, 1, append(append([]string{}, labelValues...), current)...)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateSet(t *testing.T) {
+	set := newStateSet("smc_test_state", "Test state", map[string]float64{"Up": 1, "Down": 0}, []string{"port"})
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		set.collect(ch, "Up", "1")
+		set.collect(ch, "Testing", "2")
+		// Not reported by the source
+		set.collect(ch, "", "3")
+	})
+	expected := `
+# HELP smc_test_state Test state, 1 for the current state and 0 for the others.
+# TYPE smc_test_state gauge
+smc_test_state{port="1",smc_test_state="Down"} 0
+smc_test_state{port="1",smc_test_state="Up"} 1
+smc_test_state{port="2",smc_test_state="Down"} 0
+smc_test_state{port="2",smc_test_state="Up"} 0
+# HELP smc_test_state_unknown Test state that is not a known state, value is always 1.
+# TYPE smc_test_state_unknown gauge
+smc_test_state_unknown{port="2",state="Testing"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestNicModuleCollectorStates(t *testing.T) {
+	port := PortMetrics{
+		caname:             "mlx5_0",
+		stateName:          "Active",
+		physicalStateName:  "ETH_AN_FSM_FIX_REVERSALS",
+		moduleStateName:    "Ready state",
+		dataPathStateNames: []string{"DPActivated", "DPTestPattern"},
+		state:              3,
+		physicalState:      10,
+		moduleState:        3,
+		dataPathState:      []float64{4, 0},
+	}
+	for _, numericStates := range []bool{false, true} {
+		collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{NumericStates: numericStates})
+		collector.cacheMetrics(metricsSnapshot{ports: []PortMetrics{port}, time: time.Now()})
+
+		// ETH_AN_FSM_FIX_REVERSALS shares 10 with ETH_AN_FSM_ENABLE in the
+		// numeric gauge, the state set tells them apart
+		physicalState := `
+# HELP smc_nic_module_physical_state Physical link state, 1 for the current state and 0 for the others.
+# TYPE smc_nic_module_physical_state gauge
+`
+		for _, state := range []string{"Config Idle", "Config Test", "Disabled", "ETH_AN_FSM_ABILITY_DETECT", "ETH_AN_FSM_ACK_DETECT", "ETH_AN_FSM_AN_GOOD_CHECK", "ETH_AN_FSM_COMPLETE_ACK", "ETH_AN_FSM_ENABLE", "ETH_AN_FSM_EXTRA_TUNE", "ETH_AN_FSM_FIX_REVERSALS", "ETH_AN_FSM_IB_FAIL", "ETH_AN_FSM_LINK_STAT_CHECK", "ETH_AN_FSM_NEXT_PAGE_WAIT", "ETH_AN_FSM_POST_LOCK_TUNE", "ETH_AN_FSM_XMIT_DISABLE", "Initializing", "LinkUp", "Recover Config", "Wait Config Enhanced", "Wait Remote Test"} {
+			value := "0"
+			if state == "ETH_AN_FSM_FIX_REVERSALS" {
+				value = "1"
+			}
+			physicalState += `smc_nic_module_physical_state{caname="mlx5_0",hostname="",mode="",netdev="",part_number="",port="",product_serial="",serial="",slot="",smc_nic_module_physical_state="` + state + `",vendor=""} ` + value + "\n"
+		}
+		assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(physicalState), "smc_nic_module_physical_state"))
+
+		unknown := `
+# HELP smc_nic_module_datapath_lane_state_unknown DataPath state per lane that is not a known state, value is always 1.
+# TYPE smc_nic_module_datapath_lane_state_unknown gauge
+smc_nic_module_datapath_lane_state_unknown{caname="mlx5_0",hostname="",lane="2",mode="",netdev="",part_number="",port="",product_serial="",serial="",slot="",state="DPTestPattern",vendor=""} 1
+`
+		assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(unknown), "smc_nic_module_datapath_lane_state_unknown"))
+
+		numeric := 0
+		if numericStates {
+			numeric = 1
+		}
+		assert.Equal(t, numeric, testutil.CollectAndCount(collector, "smc_nic_module_infiniband_physical_state"))
+		assert.Equal(t, 2*numeric, testutil.CollectAndCount(collector, "smc_nic_module_datapath_state"))
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stateStoreVersion is bumped whenever the layout of a stored record
+// changes incompatibly. Records of another version are ignored.
+const stateStoreVersion = 1
+
+const (
+	snapshotRecord = "snapshot"
+	historyRecord  = "history"
+)
+
+// ErrNoState is returned by StateStore.Load when a record has never been
+// saved, or was discarded because it was corrupt or of another version.
+var ErrNoState = errors.New("no stored state")
+
+// StateStore keeps named records as JSON files in a directory. Every file
+// carries a format version and a SHA-256 checksum of its data, and is
+// replaced atomically so a crash mid-write leaves the previous record.
+type StateStore struct {
+	dir string
+}
+
+type storedRecord struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func NewStateStore(dir string) *StateStore {
+	return &StateStore{dir: dir}
+}
+
+func (s *StateStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Save encodes value and atomically replaces the named record.
+func (s *StateStore) Save(name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(data)
+	content, err := json.Marshal(storedRecord{
+		Version:  stateStoreVersion,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Data:     data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(name)); err != nil {
+		return err
+	}
+	// Persist the rename itself
+	if dir, err := os.Open(s.dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Load decodes the named record into value. A corrupt record is moved
+// aside with a .corrupt suffix and ErrNoState is returned.
+func (s *StateStore) Load(name string, value any) error {
+	content, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return ErrNoState
+	}
+	if err != nil {
+		return err
+	}
+
+	var record storedRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return s.discard(name, fmt.Errorf("invalid record: %w", err))
+	}
+	if record.Version != stateStoreVersion {
+		log.Warnf("Ignoring %s state of version %d, expected %d", name, record.Version, stateStoreVersion)
+		return ErrNoState
+	}
+	checksum := sha256.Sum256(record.Data)
+	if hex.EncodeToString(checksum[:]) != record.Checksum {
+		return s.discard(name, errors.New("checksum mismatch"))
+	}
+	if err := json.Unmarshal(record.Data, value); err != nil {
+		return s.discard(name, fmt.Errorf("invalid data: %w", err))
+	}
+	return nil
+}
+
+func (s *StateStore) discard(name string, reason error) error {
+	log.Errorf("Discarding corrupt %s state in %s: %s", name, s.path(name), reason)
+	if err := os.Rename(s.path(name), s.path(name)+".corrupt"); err != nil {
+		log.Errorf("Error moving corrupt state aside: %s", err)
+	}
+	return ErrNoState
+}
+
+// storedSnapshot is the on-disk form of the last metrics snapshot.
+type storedSnapshot struct {
+	Time  int64        `json:"time"`
+	Ports []storedPort `json:"ports"`
+}
+
+// storedHistory is the on-disk form of the per-port history.
+type storedHistory struct {
+	Trends map[string][]trendSample `json:"trends"`
+}
+
+type storedPort struct {
+	Source             string             `json:"source"`
+	Mode               string             `json:"mode"`
+	Caname             string             `json:"caname"`
+	Netdev             string             `json:"netdev"`
+	Serial             string             `json:"serial"`
+	Hostname           string             `json:"hostname"`
+	SystemSerial       string             `json:"systemserial"`
+	Vendor             string             `json:"vendor"`
+	PartNumber         string             `json:"part_number"`
+	Slot               string             `json:"slot"`
+	Port               string             `json:"port"`
+	Pkey               string             `json:"pkey"`
+	StateName          string             `json:"state_name"`
+	PhysicalStateName  string             `json:"physical_state_name"`
+	ModuleStateName    string             `json:"module_state_name"`
+	DataPathStateNames []string           `json:"datapath_state_names"`
+	MlxlinkSchema      string             `json:"mlxlink_schema"`
+	MftVersion         string             `json:"mft_version"`
+	UnrecognizedSpeed  string             `json:"unrecognized_speed,omitempty"`
+	EnabledSpeeds      []string           `json:"enabled_speeds"`
+	CableSpeeds        []string           `json:"supported_cable_speeds"`
+	FecMode            string             `json:"fec_mode"`
+	State              float64            `json:"state"`
+	PhysicalState      float64            `json:"physical_state"`
+	ModuleState        float64            `json:"module_state"`
+	DataPathState      []float64          `json:"datapath_state"`
+	RxLos              []bool             `json:"rx_los"`
+	Speed              float64            `json:"speed"`
+	LaneSpeed          float64            `json:"lane_speed"`
+	Width              float64            `json:"width"`
+	BiasCurrent        []float64          `json:"bias_current"`
+	Temperature        float64            `json:"temperature"`
+	Voltage            float64            `json:"voltage"`
+	Wavelength         float64            `json:"wavelength"`
+	TransferDistance   float64            `json:"transfer_distance"`
+	RxPower            []float64          `json:"rx_power"`
+	TxPower            []float64          `json:"tx_power"`
+	RxPowerRange       []float64          `json:"rx_power_range,omitempty"`
+	TxPowerRange       []float64          `json:"tx_power_range,omitempty"`
+	BiasCurrentRange   []float64          `json:"bias_current_range,omitempty"`
+	Trends             []storedTrend      `json:"trends,omitempty"`
+	SnrMedia           []float64          `json:"snr_media"`
+	SnrHost            []float64          `json:"snr_host"`
+	Attenuation        map[string]float64 `json:"attenuation"`
+	EffectiveBer       float64            `json:"effective_ber"`
+	EffectiveErrors    float64            `json:"effective_errors"`
+	RawBer             float64            `json:"raw_ber"`
+	RawErrors          []float64          `json:"raw_errors"`
+	FecErrors          []float64          `json:"fec_errors"`
+	SymbolBer          float64            `json:"symbol_ber"`
+	SymbolErrors       float64            `json:"symbol_errors"`
+	LinkDown           float64            `json:"link_down"`
+	LinkRecovery       float64            `json:"link_recovery"`
+	LastClearTime      float64            `json:"last_clear_time"`
+}
+
+// storedTrend is the on-disk form of a trendEstimate.
+type storedTrend struct {
+	Measurement        string  `json:"measurement"`
+	Lane               int     `json:"lane"`
+	Slope              float64 `json:"slope"`
+	Bound              string  `json:"bound,omitempty"`
+	SecondsToThreshold float64 `json:"seconds_to_threshold"`
+}
+
+func trendsToStored(trends []trendEstimate) []storedTrend {
+	if trends == nil {
+		return nil
+	}
+	result := make([]storedTrend, len(trends))
+	for i, trend := range trends {
+		result[i] = storedTrend{trend.measurement, trend.lane, trend.slope, trend.bound, trend.secondsToThreshold}
+	}
+	return result
+}
+
+func trendsFromStored(trends []storedTrend) []trendEstimate {
+	if trends == nil {
+		return nil
+	}
+	result := make([]trendEstimate, len(trends))
+	for i, trend := range trends {
+		result[i] = trendEstimate{trend.Measurement, trend.Lane, trend.Slope, trend.Bound, trend.SecondsToThreshold}
+	}
+	return result
+}
+
+func rangeToStored(r *valueRange) []float64 {
+	if r == nil {
+		return nil
+	}
+	return []float64{r.low, r.high}
+}
+
+func rangeFromStored(r []float64) *valueRange {
+	if len(r) != 2 {
+		return nil
+	}
+	return &valueRange{low: r[0], high: r[1]}
+}
+
+func toStoredPort(p PortMetrics) storedPort {
+	return storedPort{
+		Source:             p.source,
+		Mode:               p.mode,
+		Caname:             p.caname,
+		Netdev:             p.netdev,
+		Serial:             p.serial,
+		Hostname:           p.hostname,
+		SystemSerial:       p.systemserial,
+		Vendor:             p.vendor,
+		PartNumber:         p.partNumber,
+		Slot:               p.slot,
+		Port:               p.port,
+		Pkey:               p.pkey,
+		StateName:          p.stateName,
+		PhysicalStateName:  p.physicalStateName,
+		ModuleStateName:    p.moduleStateName,
+		DataPathStateNames: p.dataPathStateNames,
+		MlxlinkSchema:      p.mlxlinkSchema,
+		MftVersion:         p.mftVersion,
+		UnrecognizedSpeed:  p.unrecognizedSpeed,
+		EnabledSpeeds:      p.enabledSpeeds,
+		CableSpeeds:        p.supportedCableSpeeds,
+		FecMode:            p.fecMode,
+		State:              p.state,
+		PhysicalState:      p.physicalState,
+		ModuleState:        p.moduleState,
+		DataPathState:      p.dataPathState,
+		RxLos:              p.rxLos,
+		Speed:              p.speed,
+		LaneSpeed:          p.laneSpeed,
+		Width:              p.width,
+		BiasCurrent:        p.biasCurrent,
+		Temperature:        p.temperature,
+		Voltage:            p.voltage,
+		Wavelength:         p.wavelength,
+		TransferDistance:   p.transferDistance,
+		RxPower:            p.rxPower,
+		TxPower:            p.txPower,
+		RxPowerRange:       rangeToStored(p.rxPowerRange),
+		TxPowerRange:       rangeToStored(p.txPowerRange),
+		BiasCurrentRange:   rangeToStored(p.biasCurrentRange),
+		Trends:             trendsToStored(p.trends),
+		SnrMedia:           p.snrMedia,
+		SnrHost:            p.snrHost,
+		Attenuation:        p.attenuation,
+		EffectiveBer:       p.effectiveBer,
+		EffectiveErrors:    p.effectiveErrors,
+		RawBer:             p.rawBer,
+		RawErrors:          p.rawErrors,
+		FecErrors:          p.fecErrors,
+		SymbolBer:          p.symbolBer,
+		SymbolErrors:       p.symbolErrors,
+		LinkDown:           p.linkDown,
+		LinkRecovery:       p.linkRecovery,
+		LastClearTime:      p.lastClearTime,
+	}
+}
+
+func fromStoredPort(s storedPort) PortMetrics {
+	return PortMetrics{
+		source:               s.Source,
+		mode:                 s.Mode,
+		caname:               s.Caname,
+		netdev:               s.Netdev,
+		serial:               s.Serial,
+		hostname:             s.Hostname,
+		systemserial:         s.SystemSerial,
+		vendor:               s.Vendor,
+		partNumber:           s.PartNumber,
+		slot:                 s.Slot,
+		port:                 s.Port,
+		pkey:                 s.Pkey,
+		stateName:            s.StateName,
+		physicalStateName:    s.PhysicalStateName,
+		moduleStateName:      s.ModuleStateName,
+		dataPathStateNames:   s.DataPathStateNames,
+		mlxlinkSchema:        s.MlxlinkSchema,
+		mftVersion:           s.MftVersion,
+		unrecognizedSpeed:    s.UnrecognizedSpeed,
+		enabledSpeeds:        s.EnabledSpeeds,
+		supportedCableSpeeds: s.CableSpeeds,
+		fecMode:              s.FecMode,
+		state:                s.State,
+		physicalState:        s.PhysicalState,
+		moduleState:          s.ModuleState,
+		dataPathState:        s.DataPathState,
+		rxLos:                s.RxLos,
+		speed:                s.Speed,
+		laneSpeed:            s.LaneSpeed,
+		width:                s.Width,
+		biasCurrent:          s.BiasCurrent,
+		temperature:          s.Temperature,
+		voltage:              s.Voltage,
+		wavelength:           s.Wavelength,
+		transferDistance:     s.TransferDistance,
+		rxPower:              s.RxPower,
+		txPower:              s.TxPower,
+		rxPowerRange:         rangeFromStored(s.RxPowerRange),
+		txPowerRange:         rangeFromStored(s.TxPowerRange),
+		biasCurrentRange:     rangeFromStored(s.BiasCurrentRange),
+		trends:               trendsFromStored(s.Trends),
+		snrMedia:             s.SnrMedia,
+		snrHost:              s.SnrHost,
+		attenuation:          s.Attenuation,
+		effectiveBer:         s.EffectiveBer,
+		effectiveErrors:      s.EffectiveErrors,
+		rawBer:               s.RawBer,
+		rawErrors:            s.RawErrors,
+		fecErrors:            s.FecErrors,
+		symbolBer:            s.SymbolBer,
+		symbolErrors:         s.SymbolErrors,
+		linkDown:             s.LinkDown,
+		linkRecovery:         s.LinkRecovery,
+		lastClearTime:        s.LastClearTime,
+	}
+}
@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	port := PortMetrics{
+		source:               sourceMlxlink,
+		mode:                 "infiniband",
+		caname:               "mlx5_0",
+		netdev:               "ib0",
+		serial:               "5C2410312895",
+		hostname:             "hostname",
+		systemserial:         "systemserial",
+		vendor:               "Firmus",
+		partNumber:           "OSFP400I-SR4-5M",
+		slot:                 "38",
+		port:                 "1",
+		pkey:                 "0x7fff",
+		stateName:            "Active",
+		physicalStateName:    "LinkUp",
+		moduleStateName:      "Ready state",
+		dataPathStateNames:   []string{"DPActivated", "DPActivated"},
+		fecMode:              "Standard_RS-FEC - (544,514)",
+		mlxlinkSchema:        "mft-4.22",
+		mftVersion:           "mft 4.22.1-307",
+		unrecognizedSpeed:    "IB-ZDR",
+		enabledSpeeds:        []string{"NDR", "HDR"},
+		supportedCableSpeeds: []string{"NDR"},
+		state:                3,
+		physicalState:        5,
+		moduleState:          3,
+		dataPathState:        []float64{4, 4},
+		rxLos:                []bool{false, true},
+		speed:                400e9,
+		laneSpeed:            100e9,
+		width:                4,
+		biasCurrent:          []float64{7.5, 7.6},
+		temperature:          45,
+		voltage:              3300,
+		wavelength:           850,
+		transferDistance:     50,
+		rxPower:              []float64{-3, -2, -1, 0},
+		txPower:              []float64{1, 1.5},
+		rxPowerRange:         &valueRange{-10, 4},
+		txPowerRange:         &valueRange{-8, 5},
+		biasCurrentRange:     &valueRange{2, 15},
+		trends:               []trendEstimate{{"rx_power_dBm", 1, -0.001, "low", 86400}},
+		snrMedia:             []float64{20.5, 21},
+		snrHost:              []float64{22, 22.5},
+		attenuation:          map[string]float64{"5g": 3},
+		effectiveBer:         1e-15,
+		effectiveErrors:      1,
+		rawBer:               1e-8,
+		rawErrors:            []float64{10, 20},
+		fecErrors:            []float64{100, 5},
+		symbolBer:            1e-16,
+		symbolErrors:         2,
+		linkDown:             1,
+		linkRecovery:         3,
+		lastClearTime:        6000,
+	}
+	// Every field is set, so that a field added to PortMetrics but not to
+	// storedPort fails the round trip
+	value := reflect.ValueOf(port)
+	for i := range value.NumField() {
+		assert.False(t, value.Field(i).IsZero(), value.Type().Field(i).Name)
+	}
+	assert.NoError(t, store.Save(snapshotRecord, storedSnapshot{Time: 1700000000, Ports: []storedPort{toStoredPort(port)}}))
+
+	var loaded storedSnapshot
+	assert.NoError(t, store.Load(snapshotRecord, &loaded))
+	assert.Equal(t, int64(1700000000), loaded.Time)
+	assert.Equal(t, port, fromStoredPort(loaded.Ports[0]))
+}
+
+func TestStateStoreMissing(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	var loaded storedSnapshot
+	assert.Equal(t, ErrNoState, store.Load(snapshotRecord, &loaded))
+}
+
+func TestStateStoreCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStateStore(dir)
+	assert.NoError(t, store.Save(historyRecord, storedHistory{Trends: map[string][]trendSample{"A|rx_power_dBm|1": {{1, -2}}}}))
+
+	path := filepath.Join(dir, historyRecord+".json")
+	content, _ := os.ReadFile(path)
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Replace(string(content), "-2", "-9", 1)), 0o600))
+
+	var loaded storedHistory
+	assert.Equal(t, ErrNoState, store.Load(historyRecord, &loaded))
+	_, err := os.Stat(path + ".corrupt")
+	assert.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStateStoreOtherVersion(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStateStore(dir)
+	path := filepath.Join(dir, snapshotRecord+".json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":99,"checksum":"","data":{}}`), 0o600))
+
+	var loaded storedSnapshot
+	assert.Equal(t, ErrNoState, store.Load(snapshotRecord, &loaded))
+}
+
+func TestNicModuleCollectorWarmStart(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	port := PortMetrics{caname: "mlx5_0", serial: "5C2410312895"}
+	assert.NoError(t, store.Save(snapshotRecord, storedSnapshot{Time: 1700000000, Ports: []storedPort{toStoredPort(port)}}))
+
+	collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{StateStore: store})
+	snapshot := collector.getCachedMetrics()
+	assert.True(t, snapshot.stale)
+	assert.Equal(t, time.Unix(1700000000, 0), snapshot.time)
+	assert.Equal(t, "5C2410312895", snapshot.ports[0].serial)
+}
+
+func TestNicModuleCollectorSaveState(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	collector := NewNicModuleCollector("smc_nic_module", NicModuleOptions{StateStore: store, TrendResolution: time.Hour})
+	savedTime := func() int64 {
+		var loaded storedSnapshot
+		assert.NoError(t, store.Load(snapshotRecord, &loaded))
+		return loaded.Time
+	}
+	start := time.Unix(1700000000, 0)
+	ports := []PortMetrics{{caname: "mlx5_0", serial: "A", temperature: 40}}
+	changed := []PortMetrics{{caname: "mlx5_0", serial: "A", temperature: 41}}
+
+	collector.saveState(metricsSnapshot{ports: ports, time: start}, false)
+	assert.Equal(t, start.Unix(), savedTime())
+
+	// Nothing changed
+	collector.saveState(metricsSnapshot{ports: ports, time: start.Add(2 * time.Hour)}, false)
+	assert.Equal(t, start.Unix(), savedTime())
+
+	// Changed, but within the trend resolution of the last save
+	collector.saveState(metricsSnapshot{ports: changed, time: start.Add(time.Minute)}, false)
+	assert.Equal(t, start.Unix(), savedTime())
+
+	collector.saveState(metricsSnapshot{ports: changed, time: start.Add(2 * time.Hour)}, false)
+	assert.Equal(t, start.Add(2*time.Hour).Unix(), savedTime())
+}
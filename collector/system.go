@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"smc-exporter/collector/smbios"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// systemInventory reads the SMBIOS tables below sysPath, or dmidecode when
+// they are not readable, once: neither changes while the system is up. The
+// slots registered by the kernel are read on every call, as they follow
+// hotplug.
+type systemInventory struct {
+	sysPath string
+
+	tables    func() (*smbios.Tables, error)
+	dmiSlots  func() Slots
+	dmiSerial func() string
+}
+
+func newSystemInventory(sysPath string) *systemInventory {
+	return &systemInventory{
+		sysPath: sysPath,
+		tables: sync.OnceValues(func() (*smbios.Tables, error) {
+			tables, err := smbios.Read(sysPath)
+			if err != nil {
+				log.Warnf("Error reading SMBIOS tables, falling back to dmidecode: %s", err)
+			}
+			return tables, err
+		}),
+		dmiSlots: sync.OnceValue(func() Slots {
+			output, err := exec.Command("dmidecode", "-t", "slot").Output()
+			if err != nil {
+				log.Errorf("Error executing dmidecode: %s", err)
+				return Slots{}
+			}
+			return parseSlots(string(output))
+		}),
+		dmiSerial: sync.OnceValue(func() string {
+			output, _ := exec.Command("dmidecode", "-s", "system-serial-number").Output()
+			return strings.TrimSpace(string(output))
+		}),
+	}
+}
+
+// slots returns the slots from SMBIOS, read natively or with dmidecode
+// when the tables are not readable, followed by the ones the kernel
+// knows of, so that SMBIOS wins when both describe a slot.
+func (s *systemInventory) slots() Slots {
+	var slots Slots
+	if tables, err := s.tables(); err == nil {
+		slots = smbiosSlots(tables)
+	} else {
+		slots = append(Slots{}, s.dmiSlots()...)
+	}
+	return append(slots, getKernelSlots(s.sysPath)...)
+}
+
+// systemSerial returns the serial number of the system.
+func (s *systemInventory) systemSerial() string {
+	if tables, err := s.tables(); err == nil {
+		if tables.System != nil {
+			return tables.System.SerialNumber
+		}
+		return ""
+	}
+	return s.dmiSerial()
+}
+
+var slotNumberPattern = regexp.MustCompile(`\d+`)
+
+// smbiosSlots converts the SMBIOS system slots like parseSlots does with
+// the output of dmidecode.
+func smbiosSlots(tables *smbios.Tables) Slots {
+	result := Slots{}
+	for _, slot := range tables.Slots {
+		if slot.Designation == "" {
+			continue
+		}
+		result = append(result, SlotInfo{
+			Designation: slot.Designation,
+			BusAddress:  slot.BusAddress(),
+			SlotNumber:  slotNumberPattern.FindString(slot.Designation),
+		})
+	}
+	return result
+}
+
+// SystemCollector exports the system inventory from the SMBIOS tables.
+type SystemCollector struct {
+	system   *systemInventory
+	infoDesc *prometheus.Desc
+}
+
+func NewSystemCollector(namespace string) *SystemCollector {
+	return &SystemCollector{
+		system: newSystemInventory("/sys"),
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "system", "info"),
+			"System inventory from the SMBIOS tables, value is always 1.",
+			[]string{"manufacturer", "product", "serial", "uuid", "bios_vendor", "bios_version", "bios_date", "board_manufacturer", "board_product", "board_revision", "board_serial", "chassis_type", "smbios_version"},
+			nil,
+		),
+	}
+}
+
+// Describe sends the metric descriptions to Prometheus
+func (c *SystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.infoDesc
+}
+
+// Collect runs on every /metrics scrape
+func (c *SystemCollector) Collect(ch chan<- prometheus.Metric) {
+	defer recoverCollect("system", "")
+	tables, err := c.system.tables()
+	if err != nil {
+		return
+	}
+	c.collectSystem(ch, tables)
+}
+
+func (c *SystemCollector) collectSystem(ch chan<- prometheus.Metric, tables *smbios.Tables) {
+	system := tables.System
+	if system == nil {
+		system = &smbios.System{}
+	}
+	bios := tables.BIOS
+	if bios == nil {
+		bios = &smbios.BIOS{}
+	}
+	board := tables.Baseboard
+	if board == nil {
+		board = &smbios.Baseboard{}
+	}
+	var chassisType string
+	if tables.Chassis != nil {
+		chassisType = tables.Chassis.Type.String()
+	}
+	sendConstMetric(ch, c.infoDesc, prometheus.GaugeValue, 1,
+		system.Manufacturer, system.ProductName, system.SerialNumber, system.UUID,
+		bios.Vendor, bios.Version, bios.ReleaseDate,
+		board.Manufacturer, board.Product, board.Version, board.SerialNumber,
+		chassisType, tables.EntryPoint.Version())
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"smc-exporter/collector/smbios"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmbiosSlots(t *testing.T) {
+	tables, err := smbios.Read("smbios/testdata/smbios3")
+	require.NoError(t, err)
+
+	// The native reader agrees with dmidecode on the same table
+	output, err := os.ReadFile("testdata/dmidecode_output.txt")
+	require.NoError(t, err)
+	assert.Equal(t, parseSlots(string(output)), smbiosSlots(tables))
+}
+
+func TestSystemInventory(t *testing.T) {
+	tables, err := smbios.Read("smbios/testdata/smbios3")
+	require.NoError(t, err)
+	system := newSystemInventory("smbios/testdata/smbios3")
+	assert.Equal(t, smbiosSlots(tables), system.slots())
+	assert.Equal(t, "4JQ5M24", system.systemSerial())
+
+	// Each inventory reads its own path, and falls back to dmidecode when
+	// the tables are not there
+	system = newSystemInventory(t.TempDir())
+	system.dmiSlots = func() Slots {
+		return Slots{{Designation: "PCIe Slot 3", BusAddress: "0000:1b:00.0", SlotNumber: "3"}}
+	}
+	system.dmiSerial = func() string { return "DMI0001" }
+	assert.Equal(t, Slots{{Designation: "PCIe Slot 3", BusAddress: "0000:1b:00.0", SlotNumber: "3"}}, system.slots())
+	assert.Equal(t, "DMI0001", system.systemSerial())
+	_, err = system.tables()
+	assert.Error(t, err)
+}
+
+func TestCollectSystem(t *testing.T) {
+	tables, err := smbios.Read("smbios/testdata/smbios3")
+	require.NoError(t, err)
+
+	c := NewSystemCollector("smc")
+	collector := prometheus.CollectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectSystem(ch, tables)
+	})
+	expected := `
+# HELP smc_system_info System inventory from the SMBIOS tables, value is always 1.
+# TYPE smc_system_info gauge
+smc_system_info{bios_date="03/05/2024",bios_vendor="Dell Inc.",bios_version="1.6.10",board_manufacturer="Dell Inc.",board_product="0RKWCM",board_revision="A02",board_serial=".4JQ5M24.CNFCP0041G0123.",chassis_type="Rack Mount Chassis",manufacturer="Dell Inc.",product="PowerEdge XE9680",serial="4JQ5M24",smbios_version="3.3.0",uuid="4c4c4544-004a-3510-804b-b4c04f4d3234"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minTrendSamples is the number of samples a series needs before a slope is
+// reported.
+const minTrendSamples = 3
+
+// valueRange is the vendor [low..high] range mlxlink prints next to a
+// module reading.
+type valueRange struct {
+	low  float64
+	high float64
+}
+
+type trendSample struct {
+	Time  int64   `json:"t"`
+	Value float64 `json:"v"`
+}
+
+type trendEstimate struct {
+	measurement string
+	lane        int
+	slope       float64
+	// bound is "low" or "high", the end of the vendor range the value is
+	// heading towards. It is empty when there is no range or no movement.
+	bound              string
+	secondsToThreshold float64
+}
+
+type trendMeasurement struct {
+	name   string
+	values func(port PortMetrics) []float64
+	limits func(port PortMetrics) *valueRange
+}
+
+var trendMeasurements = []trendMeasurement{
+	{"rx_power_dBm", func(port PortMetrics) []float64 { return port.rxPower }, func(port PortMetrics) *valueRange { return port.rxPowerRange }},
+	{"tx_power_dBm", func(port PortMetrics) []float64 { return port.txPower }, func(port PortMetrics) *valueRange { return port.txPowerRange }},
+	{"bias_current_mA", func(port PortMetrics) []float64 { return port.biasCurrent }, func(port PortMetrics) *valueRange { return port.biasCurrentRange }},
+}
+
+// trendTracker keeps a rolling window of samples per module serial,
+// measurement and lane. It is only used from the UpdateMetrics loop.
+type trendTracker struct {
+	window     time.Duration
+	resolution time.Duration
+	series     map[string][]trendSample
+}
+
+func newTrendTracker(window, resolution time.Duration) *trendTracker {
+	return &trendTracker{
+		window:     window,
+		resolution: resolution,
+		series:     make(map[string][]trendSample),
+	}
+}
+
+// restore replaces the samples with ones saved by an earlier run.
+func (t *trendTracker) restore(series map[string][]trendSample) {
+	for key, samples := range series {
+		if strings.Count(key, "|") == 2 {
+			t.series[key] = samples
+		}
+	}
+}
+
+func trendSeriesKey(serial, measurement string, lane int) string {
+	return serial + "|" + measurement + "|" + strconv.Itoa(lane)
+}
+
+// observe records the readings of every port and fills in their trend
+// estimates. It reports whether any sample was added or dropped.
+func (t *trendTracker) observe(ports []PortMetrics, now time.Time) bool {
+	changed := false
+	for i, port := range ports {
+		if port.serial == "" || port.serial == "unknown" {
+			continue
+		}
+		for _, measurement := range trendMeasurements {
+			for laneIdx, value := range measurement.values(port) {
+				key := trendSeriesKey(port.serial, measurement.name, laneIdx+1)
+				if t.add(key, trendSample{Time: now.Unix(), Value: value}) {
+					changed = true
+				}
+				estimate, ok := estimateTrend(t.series[key], measurement.limits(port))
+				if !ok {
+					continue
+				}
+				estimate.measurement = measurement.name
+				estimate.lane = laneIdx + 1
+				ports[i].trends = append(ports[i].trends, estimate)
+			}
+		}
+	}
+	if t.prune(now) {
+		changed = true
+	}
+	return changed
+}
+
+// add appends a sample unless the series already has one newer than the
+// resolution, which keeps long windows small.
+func (t *trendTracker) add(key string, sample trendSample) bool {
+	samples := t.series[key]
+	if len(samples) > 0 && sample.Time-samples[len(samples)-1].Time < int64(t.resolution.Seconds()) {
+		return false
+	}
+	t.series[key] = append(samples, sample)
+	return true
+}
+
+func (t *trendTracker) prune(now time.Time) bool {
+	changed := false
+	oldest := now.Add(-t.window).Unix()
+	for key, samples := range t.series {
+		keep := 0
+		for keep < len(samples) && samples[keep].Time < oldest {
+			keep++
+		}
+		if keep == 0 {
+			continue
+		}
+		changed = true
+		if keep == len(samples) {
+			delete(t.series, key)
+		} else {
+			t.series[key] = samples[keep:]
+		}
+	}
+	return changed
+}
+
+// estimateTrend fits a least squares line to the samples. The time to
+// threshold is measured from the fitted value at the latest sample to the
+// end of the vendor range the line is heading towards, and is zero when
+// that end has already been crossed.
+func estimateTrend(samples []trendSample, limits *valueRange) (trendEstimate, bool) {
+	if len(samples) < minTrendSamples {
+		return trendEstimate{}, false
+	}
+
+	// Work relative to the first sample to keep the sums small.
+	origin := samples[0].Time
+	var sumX, sumY, sumXX, sumXY float64
+	for _, sample := range samples {
+		x := float64(sample.Time - origin)
+		sumX += x
+		sumY += sample.Value
+		sumXX += x * x
+		sumXY += x * sample.Value
+	}
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return trendEstimate{}, false
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+
+	estimate := trendEstimate{slope: slope}
+	if limits == nil || slope == 0 {
+		return estimate, true
+	}
+	latest := intercept + slope*float64(samples[len(samples)-1].Time-origin)
+	target := limits.high
+	estimate.bound = "high"
+	if slope < 0 {
+		target = limits.low
+		estimate.bound = "low"
+	}
+	estimate.secondsToThreshold = max((target-latest)/slope, 0)
+	return estimate, true
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateTrendFallingTowardsLow(t *testing.T) {
+	samples := []trendSample{{0, -2}, {3600, -2.5}, {7200, -3}}
+	estimate, ok := estimateTrend(samples, &valueRange{low: -10, high: 4})
+	assert.True(t, ok)
+	assert.InDelta(t, -0.5/3600, estimate.slope, 1e-12)
+	assert.Equal(t, "low", estimate.bound)
+	assert.InDelta(t, 14*3600, estimate.secondsToThreshold, 1e-6)
+}
+
+func TestEstimateTrendRisingPastHigh(t *testing.T) {
+	samples := []trendSample{{0, 14}, {60, 15}, {120, 16}}
+	estimate, ok := estimateTrend(samples, &valueRange{low: 3, high: 15})
+	assert.True(t, ok)
+	assert.Equal(t, "high", estimate.bound)
+	assert.Equal(t, 0.0, estimate.secondsToThreshold)
+}
+
+func TestEstimateTrendWithoutRange(t *testing.T) {
+	samples := []trendSample{{0, 1}, {60, 1}, {120, 1}}
+	estimate, ok := estimateTrend(samples, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, estimate.slope)
+	assert.Equal(t, "", estimate.bound)
+
+	_, ok = estimateTrend(samples[:2], nil)
+	assert.False(t, ok)
+}
+
+func TestTrendTrackerWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	port := PortMetrics{serial: "5C2410312895", rxPower: []float64{-2}, rxPowerRange: &valueRange{-10, 4}}
+	key := trendSeriesKey("5C2410312895", "rx_power_dBm", 1)
+
+	tracker := newTrendTracker(time.Hour, time.Minute)
+	assert.True(t, tracker.observe([]PortMetrics{port}, start))
+	// Below the resolution, not kept
+	assert.False(t, tracker.observe([]PortMetrics{port}, start.Add(30*time.Second)))
+	port.rxPower = []float64{-3}
+	tracker.observe([]PortMetrics{port}, start.Add(2*time.Minute))
+	assert.Len(t, tracker.series[key], 2)
+
+	restarted := newTrendTracker(time.Hour, time.Minute)
+	restarted.restore(tracker.series)
+	port.rxPower = []float64{-4}
+	ports := []PortMetrics{port}
+	restarted.observe(ports, start.Add(4*time.Minute))
+	assert.Len(t, ports[0].trends, 1)
+	assert.InDelta(t, -1.0/120, ports[0].trends[0].slope, 1e-9)
+	assert.Equal(t, 1, ports[0].trends[0].lane)
+
+	// Samples older than the window are dropped
+	restarted.observe(ports, start.Add(2*time.Hour))
+	assert.Len(t, restarted.series[key], 1)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package collector
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// Uevent is a kernel object event, as sent on NETLINK_KOBJECT_UEVENT.
+type Uevent struct {
+	Action    string // add, remove, bind, unbind, change, ...
+	DevPath   string // eg. /devices/pci0000:17/0000:17:01.0/0000:1b:00.0
+	Subsystem string
+	Env       map[string]string
+}
+
+// pciHotplugActions are the uevent actions that change the PCI inventory or
+// the driver bound to a device.
+var pciHotplugActions = []string{"add", "remove", "bind", "unbind"}
+
+// IsPciHotplug reports whether the event adds or removes a PCI device or
+// binds or unbinds its driver.
+func (u Uevent) IsPciHotplug() bool {
+	return u.Subsystem == "pci" && slices.Contains(pciHotplugActions, u.Action)
+}
+
+// parseUevent parses a kernel uevent message, "action@devpath" followed by
+// NUL separated KEY=value pairs. Messages from udev, which start with
+// "libudev", are ignored. ok is false when the message is not a kernel
+// uevent.
+func parseUevent(msg []byte) (event Uevent, ok bool) {
+	fields := bytes.Split(msg, []byte{0})
+	header, _, found := strings.Cut(string(fields[0]), "@")
+	if !found {
+		return Uevent{}, false
+	}
+	event.Env = make(map[string]string, len(fields)-1)
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(string(field), "=")
+		if !found {
+			continue
+		}
+		event.Env[key] = value
+	}
+	event.Action = event.Env["ACTION"]
+	if event.Action == "" {
+		event.Action = header
+	}
+	event.DevPath = event.Env["DEVPATH"]
+	event.Subsystem = event.Env["SUBSYSTEM"]
+	return event, true
+}
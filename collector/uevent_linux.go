@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ListenUevents subscribes to the kernel uevents and sends them on the
+// returned channel until reading from the socket fails.
+func ListenUevents() (<-chan Uevent, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+	// Group 1 carries the kernel events, group 2 the udev ones
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+
+	events := make(chan Uevent)
+	go func() {
+		defer close(events)
+		socket := os.NewFile(uintptr(fd), "uevent")
+		defer socket.Close()
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := socket.Read(buf)
+			if errors.Is(err, syscall.ENOBUFS) {
+				// Events were dropped, the periodic resync catches up
+				log.Warn("Uevent socket buffer overrun, events were lost")
+				continue
+			}
+			if err != nil {
+				log.Errorf("Error reading uevents: %s", err)
+				return
+			}
+			if event, ok := parseUevent(buf[:n]); ok {
+				events <- event
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ueventMessage(fields ...string) []byte {
+	return []byte(strings.Join(fields, "\x00") + "\x00")
+}
+
+func TestParseUevent(t *testing.T) {
+	event, ok := parseUevent(ueventMessage(
+		"bind@/devices/pci0000:17/0000:17:01.0/0000:1b:00.0",
+		"ACTION=bind",
+		"DEVPATH=/devices/pci0000:17/0000:17:01.0/0000:1b:00.0",
+		"SUBSYSTEM=pci",
+		"DRIVER=mlx5_core",
+		"PCI_SLOT_NAME=0000:1b:00.0",
+		"SEQNUM=4711",
+	))
+	assert.True(t, ok)
+	assert.Equal(t, "bind", event.Action)
+	assert.Equal(t, "/devices/pci0000:17/0000:17:01.0/0000:1b:00.0", event.DevPath)
+	assert.Equal(t, "pci", event.Subsystem)
+	assert.Equal(t, "mlx5_core", event.Env["DRIVER"])
+	assert.True(t, event.IsPciHotplug())
+}
+
+func TestParseUeventIgnored(t *testing.T) {
+	_, ok := parseUevent([]byte("libudev\x00\xfe\xed\xca\xfe"))
+	assert.False(t, ok)
+
+	event, ok := parseUevent(ueventMessage(
+		"add@/devices/virtual/net/veth0",
+		"ACTION=add",
+		"DEVPATH=/devices/virtual/net/veth0",
+		"SUBSYSTEM=net",
+	))
+	assert.True(t, ok)
+	assert.False(t, event.IsPciHotplug())
+
+	event, ok = parseUevent(ueventMessage(
+		"change@/devices/pci0000:17/0000:17:01.0",
+		"ACTION=change",
+		"SUBSYSTEM=pci",
+	))
+	assert.True(t, ok)
+	assert.False(t, event.IsPciHotplug())
+}
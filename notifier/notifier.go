@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package notifier pushes transceiver events published by the collectors to
+// outbound sinks such as HTTP webhooks and syslog.
+package notifier
+
+import (
+	sprom "smc-exporter/collector"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sink delivers a single event to an external system.
+type Sink interface {
+	Name() string
+	Notify(event sprom.Event) error
+}
+
+// DefaultEventTypes are the events forwarded when no explicit list is
+// configured.
+var DefaultEventTypes = []sprom.EventType{
+	sprom.EventModuleFault,
+	sprom.EventLinkDown,
+	sprom.EventBerThresholdExceeded,
+}
+
+const sinkQueueSize = 256
+
+// Dispatcher forwards events of the selected types from a broker to every
+// sink. Each sink has its own queue so a slow webhook does not hold back
+// syslog and vice versa.
+type Dispatcher struct {
+	broker     *sprom.EventBroker
+	eventTypes map[sprom.EventType]bool
+	sinks      []Sink
+}
+
+func NewDispatcher(broker *sprom.EventBroker, eventTypes []sprom.EventType, sinks ...Sink) *Dispatcher {
+	if len(eventTypes) == 0 {
+		eventTypes = DefaultEventTypes
+	}
+	d := &Dispatcher{
+		broker:     broker,
+		eventTypes: make(map[sprom.EventType]bool, len(eventTypes)),
+		sinks:      sinks,
+	}
+	for _, eventType := range eventTypes {
+		d.eventTypes[eventType] = true
+	}
+	return d
+}
+
+// Run consumes events until the broker subscription is closed.
+func (d *Dispatcher) Run() {
+	queues := make([]chan sprom.Event, len(d.sinks))
+	for i, sink := range d.sinks {
+		queues[i] = make(chan sprom.Event, sinkQueueSize)
+		go deliver(sink, queues[i])
+	}
+
+	events := d.broker.Subscribe()
+	for event := range events {
+		if !d.eventTypes[event.Type] {
+			continue
+		}
+		for i, queue := range queues {
+			select {
+			case queue <- event:
+			default:
+				log.Warnf("Dropping %s event for %s: %s queue is full", event.Type, event.Caname, d.sinks[i].Name())
+			}
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+}
+
+func deliver(sink Sink, queue chan sprom.Event) {
+	for event := range queue {
+		if err := sink.Notify(event); err != nil {
+			log.Errorf("Error sending %s event for %s to %s: %s", event.Type, event.Caname, sink.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	sprom "smc-exporter/collector"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEvent = sprom.Event{
+	Type:     sprom.EventModuleFault,
+	Time:     time.Date(2024, 11, 14, 3, 4, 5, 0, time.UTC),
+	Hostname: "node1",
+	Mode:     "infiniband",
+	Caname:   "mlx5_0",
+	Netdev:   "ib0",
+	Slot:     "40",
+	Port:     "1",
+	Serial:   "5C2410312316",
+	Previous: "Ready state",
+	Current:  "Fault state",
+}
+
+func TestWebhookSignatureAndRetry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+signature([]byte("secret"), body), r.Header.Get(webhookSignatureHeader))
+		assert.Equal(t, "module_fault", r.Header.Get(webhookEventHeader))
+		assert.Contains(t, string(body), `"serial":"5C2410312316"`)
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, []byte("secret"), 5)
+	sink.InitialBackoff = time.Millisecond
+	assert.NoError(t, sink.Notify(testEvent))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil, 5)
+	sink.InitialBackoff = time.Millisecond
+	assert.Error(t, sink.Notify(testEvent))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestWebhookReusesConnection(t *testing.T) {
+	attempts := 0
+	connections := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(strings.Repeat("unavailable ", 4000)))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections++
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil, 5)
+	sink.InitialBackoff = time.Millisecond
+	assert.NoError(t, sink.Notify(testEvent))
+	assert.NoError(t, sink.Notify(testEvent))
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, 1, connections)
+}
+
+func TestFormatSyslogMessage(t *testing.T) {
+	event := testEvent
+	event.Previous = `Ready "state"]`
+	result := formatSyslogMessage(event, 42)
+	assert.Equal(t, `<26>1 2024-11-14T03:04:05Z node1 smc-exporter 42 module_fault `+
+		`[transceiver@32473 type="module_fault" mode="infiniband" caname="mlx5_0" netdev="ib0" slot="40" port="1" serial="5C2410312316" previous="Ready \"state\"\]" current="Fault state"] `+
+		`module_fault on mlx5_0 port 1 (slot 40, serial 5C2410312316): Ready "state"] -> Fault state`, result)
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslogSink("udp://" + conn.LocalAddr().String())
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Notify(testEvent))
+
+	buf := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf[:n]), "<26>1 2024-11-14T03:04:05Z node1 "))
+}
+
+func TestNewSyslogSinkRejectsUnknownNetwork(t *testing.T) {
+	_, err := NewSyslogSink("http://host:514")
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package notifier
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	sprom "smc-exporter/collector"
+	"strings"
+	"time"
+)
+
+const (
+	syslogAppName = "smc-exporter"
+	// Structured data uses the IANA example enterprise number until a
+	// private one is registered.
+	syslogSDID = "transceiver@32473"
+
+	syslogFacilityDaemon = 3
+
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityNotice   = 5
+)
+
+var eventSeverities = map[sprom.EventType]int{
+	sprom.EventModuleFault:          severityCritical,
+	sprom.EventLinkDown:             severityError,
+	sprom.EventModuleRemoved:        severityError,
+	sprom.EventLaneLOS:              severityError,
+	sprom.EventBerThresholdExceeded: severityWarning,
+}
+
+// SyslogSink sends RFC 5424 messages over UDP, TCP or a unix socket. TCP
+// uses octet-counting framing (RFC 6587).
+type SyslogSink struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+// NewSyslogSink parses an address like udp://host:514, tcp://host:601 or
+// unix:///dev/log.
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", address, err)
+	}
+	sink := &SyslogSink{network: u.Scheme}
+	switch u.Scheme {
+	case "udp", "tcp":
+		sink.address = u.Host
+	case "unix":
+		sink.address = u.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", u.Scheme)
+	}
+	return sink, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Notify(event sprom.Event) error {
+	message := formatSyslogMessage(event, os.Getpid())
+	if s.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	// Reconnect once, the remote end may have closed an idle connection.
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return err
+			}
+		}
+		if _, err := s.conn.Write([]byte(message)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			if attempt == 1 {
+				return err
+			}
+			continue
+		}
+		return nil
+	}
+	return nil
+}
+
+func (s *SyslogSink) connect() error {
+	var err error
+	if s.network == "unix" {
+		// /dev/log is a datagram socket on most distributions
+		if s.conn, err = net.Dial("unixgram", s.address); err == nil {
+			return nil
+		}
+	}
+	s.conn, err = net.DialTimeout(s.network, s.address, 10*time.Second)
+	return err
+}
+
+func formatSyslogMessage(event sprom.Event, pid int) string {
+	severity, ok := eventSeverities[event.Type]
+	if !ok {
+		severity = severityNotice
+	}
+	priority := syslogFacilityDaemon*8 + severity
+
+	params := []string{
+		sdParam("type", string(event.Type)),
+		sdParam("mode", event.Mode),
+		sdParam("caname", event.Caname),
+		sdParam("netdev", event.Netdev),
+		sdParam("slot", event.Slot),
+		sdParam("port", event.Port),
+		sdParam("serial", event.Serial),
+	}
+	if event.Lane > 0 {
+		params = append(params, sdParam("lane", fmt.Sprint(event.Lane)))
+	}
+	params = append(params, sdParam("previous", event.Previous), sdParam("current", event.Current))
+
+	msg := fmt.Sprintf("%s on %s port %s (slot %s, serial %s): %s -> %s",
+		event.Type, event.Caname, event.Port, event.Slot, event.Serial, event.Previous, event.Current)
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s [%s %s] %s",
+		priority,
+		event.Time.UTC().Format(time.RFC3339Nano),
+		headerField(event.Hostname),
+		syslogAppName,
+		pid,
+		headerField(string(event.Type)),
+		syslogSDID,
+		strings.Join(params, " "),
+		msg,
+	)
+}
+
+// sdParam escapes '"', '\' and ']' as required by RFC 5424 section 6.3.3.
+func sdParam(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	return fmt.Sprintf(`%s="%s"`, name, value)
+}
+
+func headerField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, " ", "_")
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Sustainable Metal Cloud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	sprom "smc-exporter/collector"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-SMC-Signature"
+	webhookEventHeader     = "X-SMC-Event"
+	webhookMaxBackoff      = 30 * time.Second
+	// webhookMaxDrain is how much of a response body is read before the
+	// connection is reused; a longer body closes the connection.
+	webhookMaxDrain = 64 << 10
+)
+
+// WebhookSink POSTs every event as a JSON document. When a secret is set the
+// body is signed with HMAC-SHA256 and the hex digest is sent in the
+// X-SMC-Signature header as "sha256=<digest>".
+type WebhookSink struct {
+	URL            string
+	Secret         []byte
+	MaxRetries     int
+	InitialBackoff time.Duration
+	Client         *http.Client
+}
+
+func NewWebhookSink(url string, secret []byte, maxRetries int) *WebhookSink {
+	return &WebhookSink{
+		URL:            url,
+		Secret:         secret,
+		MaxRetries:     maxRetries,
+		InitialBackoff: time.Second,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Notify sends the event, retrying with exponential backoff on transport
+// errors, 429 and 5xx responses. Other 4xx responses are not retried.
+func (w *WebhookSink) Notify(event sprom.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.InitialBackoff
+	var lastErr error
+	for attempt := 0; attempt <= w.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, webhookMaxBackoff)
+		}
+		retry, err := w.post(event, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (w *WebhookSink) post(event sprom.Event, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, string(event.Type))
+	if len(w.Secret) > 0 {
+		request.Header.Set(webhookSignatureHeader, "sha256="+signature(w.Secret, body))
+	}
+
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		// Drain the body so that the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, webhookMaxDrain))
+		response.Body.Close()
+	}()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", response.Status)
+}
+
+func signature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -20,10 +20,14 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	sprom "smc-exporter/collector"
+	"smc-exporter/notifier"
 	"strings"
 	"time"
 
@@ -42,12 +46,53 @@ func main() {
 	var TLSEnabled bool
 	var crtfile string
 	var keyfile string
+	var rawBerThreshold float64
+	var numericStates bool
+	var webhookURL string
+	var webhookSecretFile string
+	var webhookMaxRetries int
+	var syslogAddress string
+	var notifyEvents string
+	var trendWindow time.Duration
+	var trendResolution time.Duration
+	var stateDir string
+	var ethtoolModules bool
+	var ethtoolStats bool
+	var ethtoolStatsAllowlist string
+	var pciAttributes bool
+	var pciExcludeVFs bool
+	var pciIdsPath string
+	var pciFilter pciFilterFlags
+	var pciResyncInterval time.Duration
 	flag.StringVar(&port, "port", "2112", "Port to expose metrics on")
 	flag.IntVar(&interval, "interval", 10, "Interval used to update metrics")
 	flag.BoolVar(&showVersion, "version", false, "Show application version")
 	flag.BoolVar(&TLSEnabled, "TLSEnabled", false, "Enable TLS")
 	flag.StringVar(&crtfile, "crtfile", "/etc/smc-exporter/tls.crt", "Define Crt file location")
 	flag.StringVar(&keyfile, "keyfile", "/etc/smc-exporter/tls.key", "Define Key file location")
+	flag.BoolVar(&numericStates, "numeric-states", false, "Also export the link, physical, module and datapath states as the numeric gauges of earlier versions")
+	flag.Float64Var(&rawBerThreshold, "events.raw-ber-threshold", 0, "Publish an event when a port's raw BER rises above this value (0 disables)")
+	flag.StringVar(&webhookURL, "notify.webhook-url", "", "URL to POST transceiver events to")
+	flag.StringVar(&webhookSecretFile, "notify.webhook-secret-file", "", "File holding the HMAC-SHA256 key used to sign webhook bodies")
+	flag.IntVar(&webhookMaxRetries, "notify.webhook-max-retries", 5, "Number of times a failed webhook delivery is retried")
+	flag.StringVar(&syslogAddress, "notify.syslog-address", "", "Syslog destination for transceiver events, eg. udp://host:514, tcp://host:601 or unix:///dev/log")
+	flag.StringVar(&notifyEvents, "notify.events", "", "Comma separated event types to notify on (default module_fault,link_down,ber_threshold_exceeded)")
+	flag.DurationVar(&trendWindow, "trend.window", 7*24*time.Hour, "Window of optical power and bias current samples used for the degradation trend (0 disables)")
+	flag.DurationVar(&trendResolution, "trend.resolution", 5*time.Minute, "Minimum time between two samples kept for the degradation trend")
+	flag.StringVar(&stateDir, "state.dir", "/var/lib/smc-exporter", "Directory the last snapshot and per-port history are kept in across restarts (empty disables)")
+	flag.BoolVar(&ethtoolModules, "ethtool-modules", true, "Read transceivers of non-Mellanox NICs through the ethtool module EEPROM interface")
+	flag.BoolVar(&ethtoolStats, "ethtool-stats", true, "Export ethtool -S driver statistics of Mellanox netdevs")
+	flag.StringVar(&ethtoolStatsAllowlist, "ethtool-stats.allowlist", sprom.DefaultEthtoolStatsAllowlist, "Regular expression matching the ethtool -S statistics to export")
+	flag.BoolVar(&pciAttributes, "pci.attributes", false, "Export the NUMA node, local CPUs, driver, IOMMU group, power state and interrupts of PCI devices")
+	flag.BoolVar(&pciExcludeVFs, "pci.exclude-vfs", false, "Do not export SR-IOV virtual functions as PCI devices")
+	flag.StringVar(&pciIdsPath, "pci.ids-path", "", "pci.ids file used for PCI device names, reloaded when it changes (default /usr/share/misc/pci.ids or /usr/share/hwdata/pci.ids, then the embedded copy)")
+	flag.StringVar(&pciFilter.includeClasses, "pci.include-classes", "", "Comma separated class ID prefixes of the PCI devices to export, eg. 0x02,0x0302,0x0108")
+	flag.StringVar(&pciFilter.excludeClasses, "pci.exclude-classes", "", "Comma separated class ID prefixes of the PCI devices not to export")
+	flag.StringVar(&pciFilter.includeVendors, "pci.include-vendors", "", "Comma separated vendor IDs of the PCI devices to export, eg. 0x15b3,0x10de")
+	flag.StringVar(&pciFilter.excludeVendors, "pci.exclude-vendors", "", "Comma separated vendor IDs of the PCI devices not to export")
+	flag.StringVar(&pciFilter.includeAddresses, "pci.include-addresses", "", "Comma separated address globs of the PCI devices to export, eg. 0000:1b:*")
+	flag.StringVar(&pciFilter.excludeAddresses, "pci.exclude-addresses", "", "Comma separated address globs of the PCI devices not to export")
+	flag.DurationVar(&pciResyncInterval, "pci.resync-interval", 10*time.Minute, "How long the PCI device inventory is cached; it is also refreshed on PCI hotplug uevents (0 reads it on every scrape)")
 	logLevel := flag.String("loglevel", "info", "Set the log level: trace, debug, info, warn, error, fatal, panic")
 	flag.Parse()
 
@@ -62,32 +107,138 @@ func main() {
 		os.Exit(0)
 	}
 
+	filter := pciFilter.filter()
+	if err := filter.Validate(); err != nil {
+		log.Fatalf("Invalid PCI device filter: %s", err)
+	}
+
 	router := gin.Default()
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(versioncollector.NewCollector("smc_exporter"))
 
+	var stateStore *sprom.StateStore
+	if stateDir != "" {
+		stateStore = sprom.NewStateStore(stateDir)
+	}
+
+	var moduleCollectors []sprom.ModuleCollector
+	if ethtoolModules {
+		moduleCollectors = append(moduleCollectors, sprom.NewEthtoolModuleCollector())
+	}
+
 	// NIC Module Collector
-	nm := sprom.NewNicModuleCollector(PREFIX + "_nic_module")
+	nm := sprom.NewNicModuleCollector(PREFIX+"_nic_module", sprom.NicModuleOptions{
+		RawBerThreshold:  rawBerThreshold,
+		TrendWindow:      trendWindow,
+		TrendResolution:  trendResolution,
+		ModuleCollectors: moduleCollectors,
+		StateStore:       stateStore,
+		NumericStates:    numericStates,
+	})
 	// Start collection loop (prometheus scrape is async)
 	go func() {
 		for {
 			nm.UpdateMetrics()
-			time.Sleep(time.Duration(interval) * time.Second)
+			nm.Wait(time.Duration(interval) * time.Second)
 		}
 	}()
 	reg.MustRegister(nm)
 
+	// Outbound notifications
+	var sinks []notifier.Sink
+	if webhookURL != "" {
+		var secret []byte
+		if webhookSecretFile != "" {
+			if secret, err = os.ReadFile(webhookSecretFile); err != nil {
+				log.Fatalf("Error reading webhook secret: %s", err)
+			}
+			secret = bytes.TrimSpace(secret)
+		}
+		sinks = append(sinks, notifier.NewWebhookSink(webhookURL, secret, webhookMaxRetries))
+	}
+	if syslogAddress != "" {
+		syslogSink, err := notifier.NewSyslogSink(syslogAddress)
+		if err != nil {
+			log.Fatalf("Error configuring syslog: %s", err)
+		}
+		sinks = append(sinks, syslogSink)
+	}
+	var eventTypes []sprom.EventType
+	for _, name := range splitList(notifyEvents) {
+		eventType, err := sprom.ParseEventType(name)
+		if err != nil {
+			log.Fatalf("Invalid -notify.events: %s", err)
+		}
+		eventTypes = append(eventTypes, eventType)
+	}
+	if len(sinks) > 0 {
+		go notifier.NewDispatcher(nm.Events(), eventTypes, sinks...).Run()
+	}
+
 	// PCI Device Collector
-	pd, err := sprom.NewPCIDeviceCollector(PREFIX)
+	pd, err := sprom.NewPCIDeviceCollector(PREFIX, sprom.PCIDeviceOptions{
+		Attributes:     pciAttributes,
+		ExcludeVFs:     pciExcludeVFs,
+		PCIIdsPath:     pciIdsPath,
+		Filter:         filter,
+		ResyncInterval: pciResyncInterval,
+	})
 	if err != nil {
 		log.Errorf("Error creating PCIDeviceCollector: %s", err)
 	} else if err = reg.Register(pd); err != nil {
 		log.Errorf("Error registering PCIDeviceCollector: %s", err)
 	}
 
+	// PCI hotplug refreshes the PCI inventory and rediscovers NICs
+	if uevents, err := sprom.ListenUevents(); err != nil {
+		log.Warnf("Error listening for uevents, hotplugged devices are found on the next resync: %s", err)
+	} else {
+		go func() {
+			for event := range uevents {
+				if !event.IsPciHotplug() {
+					continue
+				}
+				log.Debugf("PCI %s uevent for %s", event.Action, event.DevPath)
+				if pd != nil {
+					pd.Invalidate()
+				}
+				nm.Rediscover()
+			}
+		}()
+	}
+
+	// InfiniBand Collector
+	ib, err := sprom.NewInfinibandCollector(PREFIX, nm.Slots, nm.Ports)
+	if err != nil {
+		log.Errorf("Error creating InfinibandCollector: %s", err)
+	} else if err = reg.Register(ib); err != nil {
+		log.Errorf("Error registering InfinibandCollector: %s", err)
+	}
+
+	// System inventory from SMBIOS
+	reg.MustRegister(sprom.NewSystemCollector(PREFIX))
+
+	// Panics recovered from by the collectors
+	reg.MustRegister(sprom.NewCollectErrorsCollector(PREFIX))
+
+	// ethtool Statistics Collector
+	if ethtoolStats {
+		es, err := sprom.NewEthtoolStatsCollector(PREFIX, ethtoolStatsAllowlist, nm.Devices, nm.Slots)
+		if err != nil {
+			log.Fatalf("Error creating EthtoolStatsCollector: %s", err)
+		}
+		if err = reg.Register(es); err != nil {
+			log.Errorf("Error registering EthtoolStatsCollector: %s", err)
+		}
+	}
+
 	// Expose /metrics endpoint
 	sh := SmcPrometheusHandler(reg)
 	router.GET("/metrics", sh)
+	router.GET("/api/v1/events", SmcEventsHandler(nm.Events()))
+	if pd != nil {
+		router.GET("/api/v1/pci/tree", SmcPciTreeHandler(pd))
+	}
 	log.Println("Starting smc-exporter on port "+port, "version", version.Info())
 	log.Info("Build context", "build_context", version.BuildContext())
 	if TLSEnabled {
@@ -103,9 +254,84 @@ const (
 	PREFIX = "smc"
 )
 
+// pciFilterFlags holds the comma separated PCI device filter flags.
+type pciFilterFlags struct {
+	includeClasses   string
+	excludeClasses   string
+	includeVendors   string
+	excludeVendors   string
+	includeAddresses string
+	excludeAddresses string
+}
+
+func (f pciFilterFlags) filter() sprom.PCIDeviceFilter {
+	return sprom.PCIDeviceFilter{
+		IncludeClasses:   splitList(f.includeClasses),
+		ExcludeClasses:   splitList(f.excludeClasses),
+		IncludeVendors:   splitList(f.includeVendors),
+		ExcludeVendors:   splitList(f.excludeVendors),
+		IncludeAddresses: splitList(f.includeAddresses),
+		ExcludeAddresses: splitList(f.excludeAddresses),
+	}
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
 func SmcPrometheusHandler(reg prometheus.Gatherer) gin.HandlerFunc {
 	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
 	}
 }
+
+// SmcEventsHandler streams port and module events to the client as
+// Server-Sent Events until the client disconnects.
+func SmcEventsHandler(broker *sprom.EventBroker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events := broker.Subscribe()
+		defer broker.Unsubscribe(events)
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				c.SSEvent(string(event.Type), event)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// SmcPciTreeHandler serves the PCIe topology as JSON, or as a Graphviz
+// digraph with ?format=dot.
+func SmcPciTreeHandler(pd *sprom.PCIDeviceCollector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tree, err := pd.PciTree("/sys")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Query("format") != "dot" {
+			c.JSON(http.StatusOK, tree)
+			return
+		}
+		var buf bytes.Buffer
+		if err := tree.WriteDOT(&buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", buf.Bytes())
+	}
+}
@@ -0,0 +1,7 @@
+#!/bin/bash
+# Refreshes the pci.ids snapshot embedded in the binary.
+
+set -e
+
+curl -fsSL https://pci-ids.ucw.cz/v2.2/pci.ids -o collector/pci.ids
+grep -m2 -E '^#\s+(Version|Date):' collector/pci.ids